@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"tickets/entities"
+
+	"github.com/google/uuid"
+)
+
+// LocalHotelService is a stand-in for the hotel partner until its API is available.
+// It books every request, and keeps bookings in memory.
+type LocalHotelService struct {
+	lock sync.Mutex
+
+	// bookings by idempotency key, so retried requests book the room once
+	bookings map[string]uuid.UUID
+}
+
+func NewLocalHotelService() *LocalHotelService {
+	return &LocalHotelService{
+		bookings: map[string]uuid.UUID{},
+	}
+}
+
+func (s *LocalHotelService) BookHotel(ctx context.Context, request entities.BookHotelRequest) (uuid.UUID, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if bookingID, ok := s.bookings[request.IdempotencyKey]; ok {
+		return bookingID, nil
+	}
+
+	bookingID := uuid.New()
+	s.bookings[request.IdempotencyKey] = bookingID
+
+	return bookingID, nil
+}
+
+func (s *LocalHotelService) CancelHotelBooking(ctx context.Context, hotelBookingID uuid.UUID) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for idempotencyKey, bookingID := range s.bookings {
+		if bookingID == hotelBookingID {
+			delete(s.bookings, idempotencyKey)
+		}
+	}
+
+	return nil
+}
@@ -36,7 +36,7 @@ func (c ReceiptsServiceClient) RefundPayment(ctx context.Context, cmd entities.T
 		return fmt.Errorf("Error refunding payments  %w", err)
 	}
 
-	slog.Info("response %w", resp)
+	slog.Info("refund payment response", "status", resp.StatusCode())
 	return nil
 }
 
@@ -63,13 +63,33 @@ func (t Transportation) BookFlight(
 	}
 }
 
-func (t Transportation) DeleteFlightTickets(ctx context.Context, ticketID uuid.UUID) error {
-	_, err := t.clients.Transportation.DeleteFlightTicketsTicketIdWithResponse(ctx, ticketID)
+// DeleteFlightTickets cancels the ticket, idempotencyKey is sent in the Idempotency-Key header.
+func (t Transportation) DeleteFlightTickets(ctx context.Context, ticketID uuid.UUID, idempotencyKey string) error {
+	resp, err := t.clients.Transportation.DeleteFlightTicketsTicketIdWithResponse(
+		ctx,
+		ticketID,
+		func(ctx context.Context, req *http.Request) error {
+			if idempotencyKey != "" {
+				req.Header.Set("Idempotency-Key", idempotencyKey)
+			}
+			return nil
+		},
+	)
 	if err != nil {
 		return fmt.Errorf("failed to cancel flight tickets: %w", err)
 	}
 
-	return nil
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		// a ticket that isn't found was already canceled
+		return nil
+	default:
+		return fmt.Errorf(
+			"unexpected status code for DELETE transportation-api/transportation/flight-tickets/%s: %d",
+			ticketID,
+			resp.StatusCode(),
+		)
+	}
 }
 
 func (t Transportation) BookTaxi(ctx context.Context, bookTaxi entities.BookTaxi) (uuid.UUID, error) {
@@ -86,7 +106,7 @@ func (t Transportation) BookTaxi(ctx context.Context, bookTaxi entities.BookTaxi
 	}
 
 	if resp.StatusCode() == http.StatusBadRequest {
-		return uuid.Nil, fmt.Errorf("bad request %w", resp.Body)
+		return uuid.Nil, fmt.Errorf("bad request: %s", resp.Body)
 	}
 	var bookingID uuid.UUID
 	err = json.Unmarshal(resp.Body, &bookingID)
@@ -95,3 +115,22 @@ func (t Transportation) BookTaxi(ctx context.Context, bookTaxi entities.BookTaxi
 	}
 	return bookingID, nil
 }
+
+func (t Transportation) CancelTaxiBooking(ctx context.Context, taxiBookingID uuid.UUID) error {
+	resp, err := t.clients.Transportation.DeleteTaxiBookingBookingIdWithResponse(ctx, taxiBookingID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel taxi booking: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		// a booking that isn't found was already canceled
+		return nil
+	default:
+		return fmt.Errorf(
+			"unexpected status code for DELETE transportation-api/transportation/taxi-booking/%s: %d",
+			taxiBookingID,
+			resp.StatusCode(),
+		)
+	}
+}
@@ -0,0 +1,56 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tickets/api"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/clients"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportation_DeleteFlightTickets(t *testing.T) {
+	testCases := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{name: "canceled", statusCode: http.StatusNoContent},
+		{name: "canceled_with_body", statusCode: http.StatusOK},
+		{name: "already_canceled", statusCode: http.StatusNotFound},
+		{name: "server_error", statusCode: http.StatusInternalServerError, wantErr: true},
+		{name: "bad_request", statusCode: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ticketID := uuid.New()
+
+			var requests []*http.Request
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer server.Close()
+
+			apiClients, err := clients.NewClients(server.URL, nil)
+			require.NoError(t, err)
+
+			err = api.NewTransportationClient(apiClients).DeleteFlightTickets(context.Background(), ticketID, "idempotency-key")
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			require.Len(t, requests, 1)
+			assert.Equal(t, http.MethodDelete, requests[0].Method)
+			assert.Equal(t, "/transportation-api/flight-tickets/"+ticketID.String(), requests[0].URL.Path)
+			assert.Equal(t, "idempotency-key", requests[0].Header.Get("Idempotency-Key"))
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"compress/gzip"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"tickets/db"
+	"tickets/entities"
+	"time"
+)
+
+// Archive stores events moved out of the events table in gzipped NDJSON files,
+// one per event name and month (plus parts for events archived later), listed in the manifest.
+type Archive struct {
+	dir string
+}
+
+func NewArchive(dir string) Archive {
+	if dir == "" {
+		panic("dir is empty")
+	}
+
+	return Archive{dir: dir}
+}
+
+type archivedEvent struct {
+	EventID     string          `json:"event_id"`
+	PublishedAt time.Time       `json:"published_at"`
+	EventName   string          `json:"event_name"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// Count returns the number of archived events deleted from the events table.
+func (a Archive) Count() (int, error) {
+	manifest, err := loadManifest(a.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range manifest.Files {
+		if file.HotDeleted {
+			count += file.Events
+		}
+	}
+
+	return count, nil
+}
+
+// Events reads archived events in the data lake order.
+// Files are opened only once the next event may come from them, so a range opens just a few files at a time.
+func (a Archive) Events(_ context.Context, query entities.EventQuery, after db.DataLakePosition) (db.ColdEvents, error) {
+	manifest, err := loadManifest(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	events := &archivedEvents{dir: a.dir, query: query, after: after}
+	for _, file := range manifest.Files {
+		if !events.overlaps(file) {
+			continue
+		}
+		heap.Push(&events.heap, &fileStream{file: file})
+	}
+
+	return events, nil
+}
+
+type archivedEvents struct {
+	dir   string
+	query entities.EventQuery
+	after db.DataLakePosition
+	heap  streamHeap
+}
+
+func (e *archivedEvents) overlaps(file ManifestFile) bool {
+	if len(e.query.EventNames) > 0 && !slices.Contains(e.query.EventNames, file.EventName) {
+		return false
+	}
+	if e.query.From != nil && file.LastPublishedAt.Before(*e.query.From) {
+		return false
+	}
+	if e.query.To != nil && !file.FirstPublishedAt.Before(*e.query.To) {
+		return false
+	}
+
+	return !file.LastPublishedAt.Before(e.after.PublishedAt)
+}
+
+func (e *archivedEvents) Next() (entities.Event, bool, error) {
+	for e.heap.Len() > 0 {
+		stream := heap.Pop(&e.heap).(*fileStream)
+
+		if stream.decoder == nil {
+			if err := stream.open(e.dir); err != nil {
+				return entities.Event{}, false, err
+			}
+			if err := e.advance(stream); err != nil {
+				return entities.Event{}, false, err
+			}
+			continue
+		}
+
+		event := stream.next
+		if err := e.advance(stream); err != nil {
+			return entities.Event{}, false, err
+		}
+
+		return event, true, nil
+	}
+
+	return entities.Event{}, false, nil
+}
+
+// advance reads the next matching event of the stream and puts it back on the heap, or closes it when done.
+func (e *archivedEvents) advance(stream *fileStream) error {
+	for {
+		var archived archivedEvent
+		err := stream.decoder.Decode(&archived)
+		if errors.Is(err, io.EOF) {
+			return stream.Close()
+		}
+		if err != nil {
+			_ = stream.Close()
+			return fmt.Errorf("could not read archived event from %s: %w", stream.file.Path, err)
+		}
+
+		event := entities.Event{
+			EventID:      archived.EventID,
+			PublishedAt:  archived.PublishedAt,
+			EventName:    archived.EventName,
+			EventPayload: archived.Payload,
+		}
+		position := db.DataLakePosition{PublishedAt: event.PublishedAt, EventID: event.EventID}
+
+		if e.query.To != nil && !event.PublishedAt.Before(*e.query.To) {
+			// files are sorted, so nothing further matches
+			return stream.Close()
+		}
+		if e.query.From != nil && event.PublishedAt.Before(*e.query.From) {
+			continue
+		}
+		if !e.after.Before(position) {
+			continue
+		}
+
+		stream.next = event
+		heap.Push(&e.heap, stream)
+		return nil
+	}
+}
+
+func (e *archivedEvents) Close() error {
+	var errs []error
+	for _, stream := range e.heap {
+		errs = append(errs, stream.Close())
+	}
+	e.heap = nil
+
+	return errors.Join(errs...)
+}
+
+type fileStream struct {
+	file ManifestFile
+
+	f       *os.File
+	gz      *gzip.Reader
+	decoder *json.Decoder
+	next    entities.Event
+}
+
+func (s *fileStream) open(dir string) error {
+	f, err := os.Open(filepath.Join(dir, s.file.Path))
+	if err != nil {
+		return fmt.Errorf("could not open archive file: %w", err)
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not read archive file %s: %w", s.file.Path, err)
+	}
+
+	s.f, s.gz, s.decoder = f, gz, json.NewDecoder(gz)
+	return nil
+}
+
+// position is the file's first event until it's opened.
+func (s *fileStream) position() db.DataLakePosition {
+	if s.decoder == nil {
+		return db.DataLakePosition{PublishedAt: s.file.FirstPublishedAt}
+	}
+
+	return db.DataLakePosition{PublishedAt: s.next.PublishedAt, EventID: s.next.EventID}
+}
+
+func (s *fileStream) Close() error {
+	if s.f == nil {
+		return nil
+	}
+
+	_ = s.gz.Close()
+	err := s.f.Close()
+	s.f = nil
+
+	return err
+}
+
+type streamHeap []*fileStream
+
+func (h streamHeap) Len() int { return len(h) }
+func (h streamHeap) Less(i, j int) bool {
+	return h[i].position().Before(h[j].position())
+}
+func (h streamHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *streamHeap) Push(x any)   { *h = append(*h, x.(*fileStream)) }
+func (h *streamHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"tickets/db"
+	"tickets/entities"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+)
+
+const deleteBatchSize = 1000
+
+// HotEvents are events in the events table. It must not read the archive.
+type HotEvents interface {
+	Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error
+	Months(ctx context.Context, before time.Time) ([]db.EventMonth, error)
+	Delete(ctx context.Context, eventIDs []string) error
+}
+
+// Policy sets how many days events stay hot in Postgres before they are archived.
+// Zero or fewer days keep events hot forever.
+type Policy struct {
+	HotDays        int
+	HotDaysByEvent map[string]int
+}
+
+func (p Policy) hotDays(eventName string) int {
+	if days, ok := p.HotDaysByEvent[eventName]; ok {
+		return days
+	}
+
+	return p.HotDays
+}
+
+type Result struct {
+	FilesWritten   int
+	EventsArchived int
+}
+
+// Archiver moves events past their retention from the events table to the archive.
+//
+// Each month is written to a temporary file, synced and renamed, then read back and verified against its checksum.
+// Only then it's added to the manifest, and only after that its events are deleted from the events table.
+// A crash at any step leaves the events hot or archived, and the next run finishes pending deletes.
+// Only one archiver should run at a time.
+type Archiver struct {
+	hot     HotEvents
+	archive Archive
+	policy  Policy
+	now     func() time.Time
+}
+
+func NewArchiver(hot HotEvents, archive Archive, policy Policy) Archiver {
+	if hot == nil {
+		panic("hot is nil")
+	}
+
+	return Archiver{
+		hot:     hot,
+		archive: archive,
+		policy:  policy,
+		now:     time.Now,
+	}
+}
+
+func (a Archiver) Run(ctx context.Context) (Result, error) {
+	var result Result
+
+	manifest, err := loadManifest(a.archive.dir)
+	if err != nil {
+		return result, err
+	}
+
+	for _, file := range manifest.Files {
+		if file.HotDeleted {
+			continue
+		}
+		if err := a.deleteHot(ctx, &manifest, file); err != nil {
+			return result, err
+		}
+	}
+
+	months, err := a.hot.Months(ctx, a.now())
+	if err != nil {
+		return result, err
+	}
+
+	for _, month := range months {
+		days := a.policy.hotDays(month.EventName)
+		if days <= 0 {
+			continue
+		}
+
+		monthEnd := month.Month.AddDate(0, 1, 0)
+		if monthEnd.After(a.now().AddDate(0, 0, -days)) {
+			continue
+		}
+
+		file, err := a.writeMonth(ctx, manifest, month.EventName, month.Month)
+		if err != nil {
+			return result, err
+		}
+		if file.Events == 0 {
+			continue
+		}
+
+		manifest.Files = append(manifest.Files, file)
+		if err := manifest.save(a.archive.dir); err != nil {
+			return result, err
+		}
+
+		if err := a.deleteHot(ctx, &manifest, file); err != nil {
+			return result, err
+		}
+
+		result.FilesWritten++
+		result.EventsArchived += file.Events
+	}
+
+	return result, nil
+}
+
+// writeMonth writes the month's hot events to a new verified part file.
+// A rerun after a crash overwrites the same part, as it's not in the manifest yet.
+func (a Archiver) writeMonth(ctx context.Context, manifest Manifest, eventName string, month time.Time) (ManifestFile, error) {
+	file := ManifestFile{
+		EventName: eventName,
+		Month:     month.UTC().Format("2006-01"),
+		Part:      manifest.nextPart(eventName, month.UTC().Format("2006-01")),
+	}
+	file.Path = filepath.Join(eventName, fmt.Sprintf("%s.%d.ndjson.gz", file.Month, file.Part))
+
+	from := month.UTC()
+	to := from.AddDate(0, 1, 0)
+	hash := sha256.New()
+
+	err := writeFileAtomically(filepath.Join(a.archive.dir, file.Path), func(f *os.File) error {
+		gz := gzip.NewWriter(io.MultiWriter(f, hash))
+		encoder := json.NewEncoder(gz)
+
+		err := a.hot.Query(
+			ctx,
+			entities.EventQuery{EventNames: []string{eventName}, From: &from, To: &to},
+			func(event entities.Event, _ string) error {
+				if file.Events == 0 {
+					file.FirstPublishedAt = event.PublishedAt
+				}
+				file.LastPublishedAt = event.PublishedAt
+				file.Events++
+
+				return encoder.Encode(archivedEvent{
+					EventID:     event.EventID,
+					PublishedAt: event.PublishedAt,
+					EventName:   event.EventName,
+					Payload:     event.EventPayload,
+				})
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		return gz.Close()
+	})
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("could not archive %s events of %s: %w", eventName, file.Month, err)
+	}
+
+	if file.Events == 0 {
+		// the events were deleted meanwhile
+		return file, os.Remove(filepath.Join(a.archive.dir, file.Path))
+	}
+
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	file.ArchivedAt = a.now().UTC()
+
+	if _, err := a.archive.verify(file); err != nil {
+		return ManifestFile{}, err
+	}
+
+	log.FromContext(ctx).WithField("path", file.Path).WithField("events", file.Events).Info("Archived events")
+
+	return file, nil
+}
+
+// deleteHot deletes the file's events from the events table, after verifying the file again.
+func (a Archiver) deleteHot(ctx context.Context, manifest *Manifest, file ManifestFile) error {
+	eventIDs, err := a.archive.verify(file)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(eventIDs); start += deleteBatchSize {
+		end := min(start+deleteBatchSize, len(eventIDs))
+		if err := a.hot.Delete(ctx, eventIDs[start:end]); err != nil {
+			return fmt.Errorf("could not delete archived events of %s: %w", file.Path, err)
+		}
+	}
+
+	manifest.markHotDeleted(file.Path)
+	return manifest.save(a.archive.dir)
+}
+
+var ErrChecksumMismatch = errors.New("archive file checksum mismatch")
+
+// verify checks the file against its checksum and event count, and returns its event IDs.
+func (a Archive) verify(file ManifestFile) ([]string, error) {
+	f, err := os.Open(filepath.Join(a.dir, file.Path))
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, fmt.Errorf("could not read archive file %s: %w", file.Path, err)
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != file.SHA256 {
+		return nil, fmt.Errorf("%w: %s has %s, manifest has %s", ErrChecksumMismatch, file.Path, checksum, file.SHA256)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not read archive file %s: %w", file.Path, err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive file %s: %w", file.Path, err)
+	}
+
+	var eventIDs []string
+	decoder := json.NewDecoder(gz)
+	for {
+		var event archivedEvent
+		err := decoder.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read archive file %s: %w", file.Path, err)
+		}
+		eventIDs = append(eventIDs, event.EventID)
+	}
+
+	if len(eventIDs) != file.Events {
+		return nil, fmt.Errorf("%w: %s has %d events, manifest has %d", ErrChecksumMismatch, file.Path, len(eventIDs), file.Events)
+	}
+
+	return eventIDs, nil
+}
@@ -0,0 +1,178 @@
+package archive_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"tickets/archive"
+	"tickets/db"
+	"tickets/entities"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHotEvents struct {
+	events    []entities.Event
+	deleteErr error
+}
+
+func (f *fakeHotEvents) Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error {
+	for _, event := range f.events {
+		if !slices.Contains(query.EventNames, event.EventName) ||
+			event.PublishedAt.Before(*query.From) || !event.PublishedAt.Before(*query.To) {
+			continue
+		}
+		if err := fn(event, event.EventID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeHotEvents) Months(ctx context.Context, before time.Time) ([]db.EventMonth, error) {
+	var months []db.EventMonth
+	for _, event := range f.events {
+		month := db.EventMonth{
+			EventName: event.EventName,
+			Month:     time.Date(event.PublishedAt.Year(), event.PublishedAt.Month(), 1, 0, 0, 0, 0, time.UTC),
+		}
+		if event.PublishedAt.Before(before) && !slices.Contains(months, month) {
+			months = append(months, month)
+		}
+	}
+
+	return months, nil
+}
+
+func (f *fakeHotEvents) Delete(ctx context.Context, eventIDs []string) error {
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+
+	f.events = slices.DeleteFunc(f.events, func(event entities.Event) bool {
+		return slices.Contains(eventIDs, event.EventID)
+	})
+	return nil
+}
+
+func newEvent(name string, publishedAt time.Time) entities.Event {
+	return entities.Event{
+		EventID:      uuid.NewString(),
+		PublishedAt:  publishedAt,
+		EventName:    name,
+		EventPayload: []byte(`{"ticket_id":"` + uuid.NewString() + `"}`),
+	}
+}
+
+func TestArchiver_Run(t *testing.T) {
+	may := time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC)
+	june := time.Date(2024, 6, 10, 10, 0, 0, 0, time.UTC)
+	recent := time.Now().UTC().Add(-time.Hour)
+
+	archived := []entities.Event{
+		newEvent("TicketBookingConfirmed_v1", may),
+		newEvent("TicketPrinted_v1", may.Add(time.Minute)),
+		newEvent("TicketBookingConfirmed_v1", june),
+		newEvent("TicketPrinted_v1", june.Add(time.Minute)),
+	}
+	keptByPolicy := newEvent("TicketRefunded_v1", may)
+	hotEvent := newEvent("TicketPrinted_v1", recent)
+
+	hot := &fakeHotEvents{events: append(slices.Clone(archived), keptByPolicy, hotEvent)}
+	dir := t.TempDir()
+	a := archive.NewArchive(dir)
+
+	archiver := archive.NewArchiver(hot, a, archive.Policy{
+		HotDays:        30,
+		HotDaysByEvent: map[string]int{"TicketRefunded_v1": 0},
+	})
+
+	result, err := archiver.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, archive.Result{FilesWritten: 4, EventsArchived: 4}, result)
+	assert.Equal(t, []entities.Event{keptByPolicy, hotEvent}, hot.events)
+
+	count, err := a.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	assert.Equal(t, archived, readAll(t, a, entities.EventQuery{}, db.DataLakePosition{}))
+	assert.Equal(
+		t,
+		[]entities.Event{archived[2]},
+		readAll(t, a, entities.EventQuery{EventNames: []string{"TicketBookingConfirmed_v1"}}, db.DataLakePosition{PublishedAt: may.Add(time.Minute)}),
+	)
+
+	// events published late to an archived month go to the next part
+	late := newEvent("TicketPrinted_v1", may.Add(time.Second))
+	hot.events = append(hot.events, late)
+
+	result, err = archiver.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, archive.Result{FilesWritten: 1, EventsArchived: 1}, result)
+	assert.FileExists(t, filepath.Join(dir, "TicketPrinted_v1", "2024-05.2.ndjson.gz"))
+
+	assert.Equal(
+		t,
+		[]entities.Event{archived[0], late, archived[1], archived[2], archived[3]},
+		readAll(t, a, entities.EventQuery{}, db.DataLakePosition{}),
+	)
+}
+
+func TestArchiver_Run_finishes_pending_deletes(t *testing.T) {
+	event := newEvent("TicketPrinted_v1", time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC))
+	hot := &fakeHotEvents{events: []entities.Event{event}, deleteErr: errors.New("connection lost")}
+	dir := t.TempDir()
+
+	archiver := archive.NewArchiver(hot, archive.NewArchive(dir), archive.Policy{HotDays: 30})
+
+	_, err := archiver.Run(context.Background())
+	require.Error(t, err)
+	assert.Len(t, hot.events, 1)
+
+	// not counted until deleted from Postgres
+	count, err := archive.NewArchive(dir).Count()
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	path := filepath.Join(dir, "TicketPrinted_v1", "2024-05.1.ndjson.gz")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(content, 0), 0o644))
+
+	hot.deleteErr = nil
+	_, err = archiver.Run(context.Background())
+	assert.ErrorIs(t, err, archive.ErrChecksumMismatch)
+	assert.Len(t, hot.events, 1, "events of a corrupted file must not be deleted")
+
+	require.NoError(t, os.WriteFile(path, content, 0o644))
+	result, err := archiver.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, archive.Result{}, result)
+	assert.Empty(t, hot.events)
+}
+
+func readAll(t *testing.T, a archive.Archive, query entities.EventQuery, after db.DataLakePosition) []entities.Event {
+	t.Helper()
+
+	cold, err := a.Events(context.Background(), query, after)
+	require.NoError(t, err)
+	defer cold.Close()
+
+	var events []entities.Event
+	for {
+		event, ok, err := cold.Next()
+		require.NoError(t, err)
+		if !ok {
+			return events
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,127 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const manifestFile = "manifest.json"
+
+// Manifest lists archive files. A file is added only after it was written and verified,
+// and marked HotDeleted once its events were deleted from the events table.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	// Path is relative to the archive directory.
+	Path      string `json:"path"`
+	EventName string `json:"event_name"`
+	// Month is in the 2006-01 format. Events published in an already archived month are archived in the next part.
+	Month            string    `json:"month"`
+	Part             int       `json:"part"`
+	Events           int       `json:"events"`
+	FirstPublishedAt time.Time `json:"first_published_at"`
+	LastPublishedAt  time.Time `json:"last_published_at"`
+	// SHA256 is the checksum of the compressed file.
+	SHA256     string    `json:"sha256"`
+	ArchivedAt time.Time `json:"archived_at"`
+	HotDeleted bool      `json:"hot_deleted"`
+}
+
+func loadManifest(dir string) (Manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Manifest{}, nil
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("could not read archive manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("could not unmarshal archive manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// save replaces the manifest atomically, so readers see either the old or the new version.
+func (m Manifest) save(dir string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal archive manifest: %w", err)
+	}
+
+	return writeFileAtomically(filepath.Join(dir, manifestFile), func(f *os.File) error {
+		_, err := f.Write(content)
+		return err
+	})
+}
+
+func (m Manifest) nextPart(eventName, month string) int {
+	part := 1
+	for _, file := range m.Files {
+		if file.EventName == eventName && file.Month == month && file.Part >= part {
+			part = file.Part + 1
+		}
+	}
+
+	return part
+}
+
+func (m *Manifest) markHotDeleted(path string) {
+	for i := range m.Files {
+		if m.Files[i].Path == path {
+			m.Files[i].HotDeleted = true
+		}
+	}
+}
+
+// writeFileAtomically writes the file under a temporary name, syncs it and renames it.
+func writeFileAtomically(path string, write func(f *os.File) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create directory for %s: %w", path, err)
+	}
+
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", path, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("could not sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not rename %s: %w", path, err)
+	}
+
+	// the rename is durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("could not open directory of %s: %w", path, err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory of %s: %w", path, err)
+	}
+
+	return nil
+}
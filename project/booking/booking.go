@@ -0,0 +1,185 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"tickets/entities"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound    = errors.New("booking not found")
+	ErrCanceled    = errors.New("booking is canceled")
+	ErrNotCanceled = errors.New("booking must be canceled before it's refunded")
+	ErrRefunded    = errors.New("booking is already refunded")
+)
+
+// Booking is an event-sourced aggregate. Its state is built only by applying the events of its stream,
+// and decisions record new events, which the repository appends to the stream.
+type Booking struct {
+	BookingID       uuid.UUID  `json:"booking_id"`
+	ShowID          uuid.UUID  `json:"show_id"`
+	NumberOfTickets int        `json:"number_of_tickets"`
+	CustomerEmail   string     `json:"customer_email"`
+	BookedAt        time.Time  `json:"booked_at"`
+	CanceledAt      *time.Time `json:"canceled_at"`
+	RefundedAt      *time.Time `json:"refunded_at"`
+
+	// version is the number of events in the stream, without the changes.
+	version int
+	changes []entities.IEvent
+}
+
+// Snapshot is the state of the booking after Version events of its stream.
+type Snapshot struct {
+	Version int
+	State   Booking
+}
+
+func New(bookingID uuid.UUID, showID uuid.UUID, numberOfTickets int, customerEmail string) (*Booking, error) {
+	if bookingID == uuid.Nil {
+		return nil, fmt.Errorf("booking id must be set")
+	}
+	if showID == uuid.Nil {
+		return nil, fmt.Errorf("show id must be set")
+	}
+	if numberOfTickets <= 0 {
+		return nil, fmt.Errorf("number of tickets must be greater than 0")
+	}
+	if customerEmail == "" {
+		return nil, fmt.Errorf("customer email must be set")
+	}
+
+	b := &Booking{}
+	b.record(&entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		BookingID:       bookingID,
+		NumberOfTickets: numberOfTickets,
+		CustomerEmail:   customerEmail,
+		ShowId:          showID,
+	})
+
+	return b, nil
+}
+
+// FromHistory rebuilds the booking from the snapshot (the zero value for none) and the events following it.
+func FromHistory(snapshot Snapshot, events []entities.IEvent) (*Booking, error) {
+	b := snapshot.State
+	b.version = snapshot.Version
+	b.changes = nil
+
+	for _, event := range events {
+		if err := b.apply(event); err != nil {
+			return nil, err
+		}
+		b.version++
+	}
+
+	if b.BookingID == uuid.Nil {
+		return nil, fmt.Errorf("booking stream doesn't start with BookingMade_v1")
+	}
+
+	return &b, nil
+}
+
+// Version is the version of the stream the booking was loaded from, expected when its changes are appended.
+func (b *Booking) Version() int {
+	return b.version
+}
+
+// Changes are the events recorded since the booking was loaded.
+func (b *Booking) Changes() []entities.IEvent {
+	return b.changes
+}
+
+// Snapshot returns the state including the changes.
+func (b *Booking) Snapshot() Snapshot {
+	state := *b
+	state.version = 0
+	state.changes = nil
+
+	return Snapshot{Version: b.version + len(b.changes), State: state}
+}
+
+func (b *Booking) Cancel(reason string) error {
+	if b.CanceledAt != nil {
+		return ErrCanceled
+	}
+
+	b.record(&entities.BookingCanceled_v1{
+		Header:          entities.NewEventHeader(),
+		BookingID:       b.BookingID,
+		NumberOfTickets: b.NumberOfTickets,
+		Reason:          reason,
+	})
+	return nil
+}
+
+// Transfer moves the booking to another customer. Transferring to the current customer changes nothing.
+func (b *Booking) Transfer(customerEmail string) error {
+	if customerEmail == "" {
+		return fmt.Errorf("customer email must be set")
+	}
+	if b.CanceledAt != nil {
+		return ErrCanceled
+	}
+	if customerEmail == b.CustomerEmail {
+		return nil
+	}
+
+	b.record(&entities.BookingTransferred_v1{
+		Header:                entities.NewEventHeader(),
+		BookingID:             b.BookingID,
+		PreviousCustomerEmail: b.CustomerEmail,
+		CustomerEmail:         customerEmail,
+	})
+	return nil
+}
+
+func (b *Booking) Refund() error {
+	if b.CanceledAt == nil {
+		return ErrNotCanceled
+	}
+	if b.RefundedAt != nil {
+		return ErrRefunded
+	}
+
+	b.record(&entities.BookingRefunded_v1{
+		Header:          entities.NewEventHeader(),
+		BookingID:       b.BookingID,
+		NumberOfTickets: b.NumberOfTickets,
+		CustomerEmail:   b.CustomerEmail,
+	})
+	return nil
+}
+
+func (b *Booking) record(event entities.IEvent) {
+	if err := b.apply(event); err != nil {
+		// decisions record only events the booking handles
+		panic(err)
+	}
+	b.changes = append(b.changes, event)
+}
+
+func (b *Booking) apply(event entities.IEvent) error {
+	switch e := event.(type) {
+	case *entities.BookingMade_v1:
+		b.BookingID = e.BookingID
+		b.ShowID = e.ShowId
+		b.NumberOfTickets = e.NumberOfTickets
+		b.CustomerEmail = e.CustomerEmail
+		b.BookedAt = e.Header.PublishedAt
+	case *entities.BookingCanceled_v1:
+		b.CanceledAt = &e.Header.PublishedAt
+	case *entities.BookingTransferred_v1:
+		b.CustomerEmail = e.CustomerEmail
+	case *entities.BookingRefunded_v1:
+		b.RefundedAt = &e.Header.PublishedAt
+	default:
+		return fmt.Errorf("booking can't apply %T", event)
+	}
+
+	return nil
+}
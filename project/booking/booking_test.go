@@ -0,0 +1,51 @@
+package booking_test
+
+import (
+	"testing"
+	"tickets/booking"
+	"tickets/entities"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBooking_decisions(t *testing.T) {
+	b, err := booking.New(uuid.New(), uuid.New(), 2, "old@example.com")
+	require.NoError(t, err)
+
+	loaded, err := booking.FromHistory(booking.Snapshot{}, b.Changes())
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded.Version())
+	assert.Empty(t, loaded.Changes())
+
+	assert.ErrorIs(t, loaded.Refund(), booking.ErrNotCanceled)
+
+	require.NoError(t, loaded.Transfer("new@example.com"))
+	require.NoError(t, loaded.Transfer("new@example.com"))
+	require.NoError(t, loaded.Cancel("customer request"))
+	assert.ErrorIs(t, loaded.Cancel("customer request"), booking.ErrCanceled)
+	assert.ErrorIs(t, loaded.Transfer("other@example.com"), booking.ErrCanceled)
+	require.NoError(t, loaded.Refund())
+	assert.ErrorIs(t, loaded.Refund(), booking.ErrRefunded)
+
+	require.Len(t, loaded.Changes(), 3)
+	refunded := loaded.Changes()[2].(*entities.BookingRefunded_v1)
+	assert.Equal(t, "new@example.com", refunded.CustomerEmail)
+	assert.Equal(t, 2, refunded.NumberOfTickets)
+
+	// replaying the snapshot and the changes gives the same state
+	snapshot := loaded.Snapshot()
+	assert.Equal(t, 4, snapshot.Version)
+
+	replayed, err := booking.FromHistory(booking.Snapshot{}, append(b.Changes(), loaded.Changes()...))
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, replayed.Snapshot())
+}
+
+func TestFromHistory_requires_BookingMade(t *testing.T) {
+	_, err := booking.FromHistory(booking.Snapshot{}, []entities.IEvent{
+		&entities.BookingCanceled_v1{Header: entities.NewEventHeader()},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"tickets/archive"
+	"tickets/db"
+	"tickets/export"
+	"tickets/message"
+	"tickets/message/protobuf"
+	"tickets/message/replay"
+	"tickets/schema"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var dataLakeCommand = &cli.Command{
+	Name:  "datalake",
+	Usage: "Work with the events data lake",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "export",
+			Usage: "export events not exported by the previous runs to files partitioned by event name and day",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "parquet or csv (with the payload flattened to columns)",
+					Value: string(export.FormatParquet),
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Value: "datalake-export",
+				},
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "name of the export's high-water mark, defaults to the format; use different names for different filters",
+				},
+				&cli.StringSliceFlag{
+					Name:  "event-name",
+					Usage: "export only events with the name, can be repeated",
+				},
+				&cli.TimestampFlag{
+					Name:   "from",
+					Usage:  "export events published from the day (inclusive)",
+					Layout: time.DateOnly,
+				},
+				&cli.TimestampFlag{
+					Name:   "to",
+					Usage:  "export events published before the day (exclusive)",
+					Layout: time.DateOnly,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+				if err != nil {
+					return err
+				}
+				defer database.Close()
+				database.MigrateSchema()
+
+				config := export.Config{
+					Name:       c.String("name"),
+					Format:     export.Format(c.String("format")),
+					Dir:        c.String("dir"),
+					EventNames: c.StringSlice("event-name"),
+					From:       c.Timestamp("from"),
+					To:         c.Timestamp("to"),
+				}
+				if config.Name == "" {
+					config.Name = string(config.Format)
+				}
+
+				registry, err := schema.NewRegistry()
+				if err != nil {
+					return err
+				}
+
+				exporter := export.NewExporter(
+					newDataLake(&database),
+					db.NewDataLakeExportRepository(&database),
+					registry,
+				)
+
+				result, err := exporter.Export(c.Context, config)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("events exported:\t%d\n", result.EventsExported)
+				fmt.Printf("files written:\t%d\n", result.FilesWritten)
+
+				return nil
+			},
+		},
+		{
+			Name:  "archive",
+			Usage: "move events past their retention from Postgres to monthly archive files",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "dir",
+					EnvVars: []string{"DATA_LAKE_ARCHIVE_DIR"},
+					Value:   "datalake-archive",
+				},
+				&cli.IntFlag{
+					Name:  "hot-days",
+					Usage: "days events are kept in Postgres, 0 keeps them forever",
+					Value: 90,
+				},
+				&cli.StringSliceFlag{
+					Name:  "policy",
+					Usage: "days events with the name are kept in Postgres, for example TicketPrinted_v1=30, can be repeated",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				policy := archive.Policy{
+					HotDays:        c.Int("hot-days"),
+					HotDaysByEvent: map[string]int{},
+				}
+				for _, p := range c.StringSlice("policy") {
+					eventName, days, ok := strings.Cut(p, "=")
+					if !ok {
+						return fmt.Errorf("invalid policy %q, expected EventName=days", p)
+					}
+					var err error
+					if policy.HotDaysByEvent[eventName], err = strconv.Atoi(days); err != nil {
+						return fmt.Errorf("invalid policy %q: %w", p, err)
+					}
+				}
+
+				database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+				if err != nil {
+					return err
+				}
+				defer database.Close()
+				database.MigrateSchema()
+
+				archiver := archive.NewArchiver(
+					db.NewEventRepository(&database, nil),
+					archive.NewArchive(c.String("dir")),
+					policy,
+				)
+
+				result, err := archiver.Run(c.Context)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("events archived:\t%d\n", result.EventsArchived)
+				fmt.Printf("files written:\t%d\n", result.FilesWritten)
+
+				return nil
+			},
+		},
+		{
+			Name:  "replay",
+			Usage: "republish events to the events.<name> topics, marked as replayed",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:     "event-name",
+					Usage:    "replay events with the name (and their older versions), can be repeated",
+					Required: true,
+				},
+				&cli.TimestampFlag{
+					Name:   "from",
+					Usage:  "replay events published from the time (inclusive)",
+					Layout: time.RFC3339,
+				},
+				&cli.TimestampFlag{
+					Name:   "to",
+					Usage:  "replay events published before the time (exclusive)",
+					Layout: time.RFC3339,
+				},
+				&cli.StringFlag{
+					Name:  "target",
+					Usage: "name of the only handler (consumer group) handling the replayed events",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+				if err != nil {
+					return err
+				}
+				defer database.Close()
+
+				redisClient := message.NewRedisClient(os.Getenv("REDIS_ADDR"))
+				defer redisClient.Close()
+
+				transport, err := newTransport(c.Context, redisClient)
+				if err != nil {
+					return err
+				}
+
+				transportPublisher, err := transport.NewPublisher()
+				if err != nil {
+					return err
+				}
+				publisher := protobuf.NewPublisherDecorator(transportPublisher, listFromEnv("PROTOBUF_TOPICS"))
+				defer publisher.Close()
+
+				result, err := replay.NewReplayer(newDataLake(&database), publisher).Replay(
+					c.Context,
+					replay.Config{
+						EventNames: c.StringSlice("event-name"),
+						From:       c.Timestamp("from"),
+						To:         c.Timestamp("to"),
+						Target:     c.String("target"),
+					},
+				)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("events replayed:\t%d\n", result.EventsReplayed)
+				fmt.Printf("correlation id:\t%s\n", result.CorrelationID)
+
+				return nil
+			},
+		},
+	},
+}
@@ -2,329 +2,155 @@ package db
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
-	"fmt"
 	"tickets/entities"
+	"tickets/message/outbox"
 	"time"
 
-	"github.com/ThreeDotsLabs/go-event-driven/common/log"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
-	"github.com/jmoiron/sqlx"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/samber/lo"
 )
 
-type OpsBookingReadModel struct {
-	eventBus *cqrs.EventBus
-	conn     *sqlx.DB
-}
+const opsBookingsProjection = "ops_bookings"
 
-func NewOpsBookingReadModel(db *DB, eventBus *cqrs.EventBus) OpsBookingReadModel {
-	return OpsBookingReadModel{
-		conn:     db.Conn,
-		eventBus: eventBus,
-	}
+// opsBookingsHandlerNames are the names the handlers had before the read model became a projection.
+// The names are consumer groups of the handlers, so they are kept to continue from their offsets.
+var opsBookingsHandlerNames = map[string]string{
+	"BookingMade_v1":            "ops_read_model.OnBookingMade",
+	"TicketBookingConfirmed_v1": "ops_read_model.OnTicketBookingConfirmed",
+	"TicketReceiptIssued_v1":    "ops_read_model.IssueReceiptHandler",
+	"TicketPrinted_v1":          "ops_read_model.OnTicketPrinted",
+	"TicketRefunded_v1":         "ops_read_model.OnTicketRefunded",
 }
 
-func (r OpsBookingReadModel) GetAll(ctx context.Context, date *string) ([]entities.OpsBooking_v1, error) {
-	query := "SELECT payload FROM read_model_ops_bookings"
-	var quaryArgs []any
-
-	if date != nil {
-		query += fmt.Sprintf(`
-			WHERE booking_id IN (
-				SELECT booking_id FROM (
-					SELECT booking_id, 
-						DATE(jsonb_path_query(payload, '$.tickets.*.receipt_issued_at')::text) as receipt_issued_at 
-					FROM 
-						read_model_ops_bookings
-				) bookings_within_date 
-				WHERE receipt_issued_at = $1
-			)
-		`)
-		quaryArgs = append(quaryArgs, date)
-	}
-
-	rows, err := r.conn.Query(query, quaryArgs...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var result []entities.OpsBooking_v1
-	for rows.Next() {
-		var payload []byte
-		if err := rows.Scan(&payload); err != nil {
-			return nil, err
-		}
-
-		var booking entities.OpsBooking_v1
-		if err := json.Unmarshal(payload, &booking); err != nil {
-			return nil, err
-		}
-
-		result = append(result, booking)
-	}
-	return result, nil
+// OpsBookingReadModel is the projection of bookings and their tickets for the operations team.
+// Ticket events find their booking by the ticket ID, which is a lookup key of the booking.
+type OpsBookingReadModel struct {
+	projection *Projection[entities.OpsBooking_v1]
 }
 
-func (r OpsBookingReadModel) GetByID(ctx context.Context, bookingID string) (entities.OpsBooking_v1, error) {
-	var bookingPayload []byte
-
-	err := r.conn.QueryRowContext(
-		ctx,
-		"SELECT payload FROM read_model_ops_bookings WHERE booking_id = $1",
-		bookingID,
-	).Scan(&bookingPayload)
-	if err != nil {
-		return entities.OpsBooking_v1{}, err
-	}
+func NewOpsBookingReadModel(db *DB, outboxPartitions outbox.Partitions, newEventBus func(message.Publisher) *cqrs.EventBus) OpsBookingReadModel {
+	p := NewProjection[entities.OpsBooking_v1](db, opsBookingsProjection, outboxPartitions, newEventBus)
 
-	var booking entities.OpsBooking_v1
-
-	err = json.Unmarshal(bookingPayload, &booking)
-	if err != nil {
-		return entities.OpsBooking_v1{}, err
-	}
-
-	return booking, nil
-}
-
-func (r OpsBookingReadModel) OnBookingMade(ctx context.Context, bookingMade *entities.BookingMade_v1) error {
-	// this is the first event that should arrive, so we create the read model
-	err := r.createReadModel(ctx, entities.OpsBooking_v1{
-		BookingID:  bookingMade.BookingID,
-		Tickets:    nil,
-		LastUpdate: time.Now(),
-		BookedAt:   bookingMade.Header.PublishedAt,
+	p.LookupKeys(func(rm entities.OpsBooking_v1) []string {
+		return lo.Keys(rm.Tickets)
 	})
-	if err != nil {
-		return fmt.Errorf("could not create read model: %w", err)
-	}
-
-	return nil
-}
-func (r OpsBookingReadModel) OnTicketBookingConfirmed(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error {
-	return r.updateBookingReadModel(
-		ctx,
-		event.BookingID,
-		func(rm entities.OpsBooking_v1) (entities.OpsBooking_v1, error) {
-
-			ticket, ok := rm.Tickets[event.TicketID]
-			if !ok {
-				// we are using zero-value of OpsTicket_v1
-				log.
-					FromContext(ctx).
-					WithField("ticket_id", event.TicketID).
-					Debug("Creating ticket read model for ticket %s")
+	p.Field("receipt_issued_date", func(rm entities.OpsBooking_v1) []string {
+		var dates []string
+		for _, ticket := range rm.Tickets {
+			if !ticket.ReceiptIssuedAt.IsZero() {
+				dates = append(dates, ticket.ReceiptIssuedAt.UTC().Format(time.DateOnly))
 			}
+		}
+		return dates
+	})
+	p.HandlerNames(func(eventName string) string {
+		if name, ok := opsBookingsHandlerNames[eventName]; ok {
+			return name
+		}
+		return opsBookingsProjection + "." + eventName
+	})
+	p.OnUpdated(func(_ string, rm entities.OpsBooking_v1) any {
+		return &entities.InternalOpsReadModelUpdated{
+			Header:    entities.NewEventHeader(),
+			BookingID: rm.BookingID,
+		}
+	})
 
-			ticket.PriceAmount = event.Price.Amount
-			ticket.PriceCurrency = event.Price.Currency
-			ticket.CustomerEmail = event.CustomerEmail
-			ticket.ConfirmedAt = event.Header.PublishedAt
-
-			rm.Tickets[event.TicketID] = ticket
-
-			return rm, nil
-		},
-	)
-}
-func (r OpsBookingReadModel) OnTicketReceiptIssued(ctx context.Context, issued *entities.TicketReceiptIssued_v1) error {
-	return r.updateTicketInBookingReadModel(
-		ctx,
-		issued.TicketID,
-		func(rm entities.OpsTicket_v1) (entities.OpsTicket_v1, error) {
-			rm.ReceiptIssuedAt = issued.IssuedAt
-			rm.ReceiptNumber = issued.ReceiptNumber
+	HandleCreate(p, func(e *entities.BookingMade_v1) string { return e.BookingID.String() }, onBookingMade)
+	Handle(p, func(e *entities.TicketBookingConfirmed_v1) string { return e.BookingID }, onTicketBookingConfirmed)
+	Handle(p, func(e *entities.TicketReceiptIssued_v1) string { return e.TicketID }, onTicketReceiptIssued)
+	Handle(p, func(e *entities.TicketPrinted_v1) string { return e.TicketID }, onTicketPrinted)
+	Handle(p, func(e *entities.TicketRefunded_v1) string { return e.TicketID }, onTicketRefunded)
 
-			return rm, nil
-		},
-	)
+	return OpsBookingReadModel{projection: p}
 }
 
-func (r OpsBookingReadModel) OnTicketPrinted(ctx context.Context, event *entities.TicketPrinted_v1) error {
-	return r.updateTicketInBookingReadModel(
-		ctx,
-		event.TicketID,
-		func(rm entities.OpsTicket_v1) (entities.OpsTicket_v1, error) {
-			rm.PrintedAt = time.Now()
-			rm.PrintedFileName = event.FileName
-			return rm, nil
-		},
-	)
+func (r OpsBookingReadModel) Projection() *Projection[entities.OpsBooking_v1] {
+	return r.projection
 }
 
-func (r OpsBookingReadModel) OnTicketRefunded(ctx context.Context, event *entities.TicketRefunded_v1) error {
-	return r.updateTicketInBookingReadModel(
-		ctx,
-		event.TicketID,
-		func(rm entities.OpsTicket_v1) (entities.OpsTicket_v1, error) {
-			rm.RefundedAt = event.Header.PublishedAt
-
-			return rm, nil
-		},
-	)
+func (r OpsBookingReadModel) EventHandlers() []cqrs.EventHandler {
+	return r.projection.EventHandlers()
 }
 
-func (r OpsBookingReadModel) createReadModel(ctx context.Context, opsBooking entities.OpsBooking_v1) error {
-	payload, err := json.Marshal(opsBooking)
-	if err != nil {
-		return err
-	}
-
-	_, err = r.conn.ExecContext(ctx, `
-		INSERT INTO 
-		    read_model_ops_bookings (payload, booking_id)
-		VALUES
-			($1, $2)
-		ON CONFLICT (booking_id) DO NOTHING; -- read model may be already updated by another event - we don't want to override
-`, payload, opsBooking.BookingID)
-
-	if err != nil {
-		return fmt.Errorf("could not create read model: %w", err)
+// GetAll returns bookings with a receipt issued on the date (in UTC, formatted as YYYY-MM-DD), or all of them.
+func (r OpsBookingReadModel) GetAll(ctx context.Context, date *string) ([]entities.OpsBooking_v1, error) {
+	query := ProjectionQuery{}
+	if date != nil {
+		query.Filter = map[string]string{"receipt_issued_date": *date}
 	}
 
-	return nil
+	bookings, _, err := r.projection.Find(ctx, query)
+	return bookings, err
 }
 
-func (r OpsBookingReadModel) updateTicketInBookingReadModel(
-	ctx context.Context,
-	ticketID string,
-	updateFunc func(ticket entities.OpsTicket_v1) (entities.OpsTicket_v1, error),
-) (err error) {
-	return updateInTx(
-		ctx,
-		r.conn,
-		sql.LevelRepeatableRead,
-		func(ctx context.Context, tx *sqlx.Tx) error {
-			rm, err := r.findReadModelByTicketID(ctx, ticketID, tx)
-			if err == sql.ErrNoRows {
-				// events arrived out of order - it should spin until the read model is created
-				return fmt.Errorf("read model for ticket %s not exist yet", ticketID)
-			} else if err != nil {
-				return fmt.Errorf("could not find read model: %w", err)
-			}
-
-			ticket, _ := rm.Tickets[ticketID]
-
-			updatedRm, err := updateFunc(ticket)
-			if err != nil {
-				return err
-			}
-
-			rm.Tickets[ticketID] = updatedRm
-
-			return r.updateReadModel(ctx, tx, rm)
-		},
-	)
+func (r OpsBookingReadModel) GetByID(ctx context.Context, bookingID string) (entities.OpsBooking_v1, error) {
+	return r.projection.Get(ctx, bookingID)
 }
 
-func (r OpsBookingReadModel) updateBookingReadModel(ctx context.Context,
-	bookingID string,
-	updateFunc func(ticket entities.OpsBooking_v1) (entities.OpsBooking_v1, error),
-) error {
-	return updateInTx(ctx,
-		r.conn,
-		sql.LevelRepeatableRead,
-		func(ctx context.Context, tx *sqlx.Tx) error {
-			rm, err := r.findModelByBookingID(ctx, bookingID, tx)
-			if err == sql.ErrNoRows {
-				// events arrived out of order - it should spin until the read model is created
-				return fmt.Errorf("read model for booking %s not exist yet", bookingID)
-			} else if err != nil {
-				return fmt.Errorf("could not find read model: %w", err)
-			}
-
-			updatedRm, err := updateFunc(rm)
-			if err != nil {
-				return err
-			}
-
-			return r.updateReadModel(ctx, tx, updatedRm)
-		},
-	)
+func onBookingMade(bookingMade *entities.BookingMade_v1) (entities.OpsBooking_v1, error) {
+	return entities.OpsBooking_v1{
+		BookingID:  bookingMade.BookingID,
+		Tickets:    map[string]entities.OpsTicket_v1{},
+		LastUpdate: bookingMade.Header.PublishedAt,
+		BookedAt:   bookingMade.Header.PublishedAt,
+	}, nil
 }
 
-func (r OpsBookingReadModel) updateReadModel(
-	ctx context.Context,
-	tx *sqlx.Tx,
-	rm entities.OpsBooking_v1,
-) error {
-	rm.LastUpdate = time.Now()
+func onTicketBookingConfirmed(rm entities.OpsBooking_v1, event *entities.TicketBookingConfirmed_v1) (entities.OpsBooking_v1, error) {
+	return updateOpsTicket(rm, event.TicketID, event.Header, func(ticket entities.OpsTicket_v1) entities.OpsTicket_v1 {
+		ticket.PriceAmount = event.Price.Amount
+		ticket.PriceCurrency = event.Price.Currency
+		ticket.CustomerEmail = event.CustomerEmail
+		ticket.ConfirmedAt = event.Header.PublishedAt
 
-	payload, err := json.Marshal(rm)
-	if err != nil {
-		return err
-	}
+		return ticket
+	}), nil
+}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO 
-			read_model_ops_bookings (payload, booking_id)
-		VALUES
-			($1, $2)
-		ON CONFLICT (booking_id) DO UPDATE SET payload = excluded.payload;
-		`, payload, rm.BookingID)
-	if err != nil {
-		return fmt.Errorf("could not update read model: %w", err)
-	}
+func onTicketReceiptIssued(rm entities.OpsBooking_v1, issued *entities.TicketReceiptIssued_v1) (entities.OpsBooking_v1, error) {
+	return updateOpsTicket(rm, issued.TicketID, issued.Header, func(ticket entities.OpsTicket_v1) entities.OpsTicket_v1 {
+		ticket.ReceiptIssuedAt = issued.IssuedAt
+		ticket.ReceiptNumber = issued.ReceiptNumber
 
-	return r.eventBus.Publish(ctx, &entities.InternalOpsReadModelUpdated{
-		Header:    entities.NewEventHeader(),
-		BookingID: rm.BookingID,
-	})
+		return ticket
+	}), nil
 }
 
-func (r OpsBookingReadModel) findModelByBookingID(ctx context.Context, bookingID string, tx *sqlx.Tx) (entities.OpsBooking_v1, error) {
-	var payload []byte
+func onTicketPrinted(rm entities.OpsBooking_v1, event *entities.TicketPrinted_v1) (entities.OpsBooking_v1, error) {
+	return updateOpsTicket(rm, event.TicketID, event.Header, func(ticket entities.OpsTicket_v1) entities.OpsTicket_v1 {
+		ticket.PrintedAt = event.Header.PublishedAt
+		ticket.PrintedFileName = event.FileName
 
-	err := r.conn.QueryRowContext(
-		ctx,
-		"SELECT payload FROM read_model_ops_bookings WHERE booking_id = $1",
-		bookingID,
-	).Scan(&payload)
-	if err != nil {
-		return entities.OpsBooking_v1{}, err
-	}
+		return ticket
+	}), nil
+}
 
-	return r.unmarshalReadModelFromDB(payload)
+func onTicketRefunded(rm entities.OpsBooking_v1, event *entities.TicketRefunded_v1) (entities.OpsBooking_v1, error) {
+	return updateOpsTicket(rm, event.TicketID, event.Header, func(ticket entities.OpsTicket_v1) entities.OpsTicket_v1 {
+		ticket.RefundedAt = event.Header.PublishedAt
 
+		return ticket
+	}), nil
 }
 
-func (r OpsBookingReadModel) findReadModelByTicketID(
-	ctx context.Context,
+func updateOpsTicket(
+	rm entities.OpsBooking_v1,
 	ticketID string,
-	db dbExecutor,
-) (entities.OpsBooking_v1, error) {
-	var payload []byte
-
-	err := db.QueryRowContext(
-		ctx,
-		"SELECT payload FROM read_model_ops_bookings WHERE payload::jsonb -> 'tickets' ? $1",
-		ticketID,
-	).Scan(&payload)
-	if err != nil {
-		return entities.OpsBooking_v1{}, err
+	header entities.EventHeader,
+	updateFunc func(ticket entities.OpsTicket_v1) entities.OpsTicket_v1,
+) entities.OpsBooking_v1 {
+	tickets := make(map[string]entities.OpsTicket_v1, len(rm.Tickets)+1)
+	for id, ticket := range rm.Tickets {
+		tickets[id] = ticket
 	}
 
-	return r.unmarshalReadModelFromDB(payload)
-}
-
-func (r OpsBookingReadModel) unmarshalReadModelFromDB(payload []byte) (entities.OpsBooking_v1, error) {
-	var opsReadModel entities.OpsBooking_v1
+	// zero-value of OpsTicket_v1 is used for a new ticket
+	tickets[ticketID] = updateFunc(tickets[ticketID])
 
-	err := json.Unmarshal(payload, &opsReadModel)
-	if err != nil {
-		return entities.OpsBooking_v1{}, err
-	}
-
-	if opsReadModel.Tickets == nil {
-		opsReadModel.Tickets = map[string]entities.OpsTicket_v1{}
-	}
-	return opsReadModel, nil
-}
+	rm.Tickets = tickets
+	rm.LastUpdate = header.PublishedAt
 
-type dbExecutor interface {
-	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
-	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
-	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	return rm
 }
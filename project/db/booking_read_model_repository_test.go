@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/message/outbox"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpsBookingReadModel_eventsOutOfOrder(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewOpsBookingReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	bookingID := uuid.New()
+	ticketID := uuid.NewString()
+
+	err := readModel.Projection().HandleEvent(ctx, &entities.TicketPrinted_v1{
+		Header:   entities.NewEventHeader(),
+		TicketID: ticketID,
+		FileName: ticketID + "-ticket.html",
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.TicketBookingConfirmed_v1{
+		Header:        entities.NewEventHeader(),
+		TicketID:      ticketID,
+		CustomerEmail: "email@example.com",
+		Price:         entities.Money{Amount: "100", Currency: "EUR"},
+		BookingID:     bookingID.String(),
+	})
+	require.NoError(t, err)
+
+	_, err = readModel.GetByID(ctx, bookingID.String())
+	assert.Error(t, err, "read model should not exist before the booking is made")
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		NumberOfTickets: 1,
+		BookingID:       bookingID,
+		CustomerEmail:   "email@example.com",
+		ShowId:          uuid.New(),
+	})
+	require.NoError(t, err)
+
+	booking, err := readModel.GetByID(ctx, bookingID.String())
+	require.NoError(t, err)
+
+	require.Contains(t, booking.Tickets, ticketID)
+	assert.Equal(t, "100", booking.Tickets[ticketID].PriceAmount)
+	assert.Equal(t, ticketID+"-ticket.html", booking.Tickets[ticketID].PrintedFileName)
+}
+
+func TestOpsBookingReadModel_concurrentUpdates(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewOpsBookingReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	bookingID := uuid.New()
+	err := readModel.Projection().HandleEvent(ctx, &entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		NumberOfTickets: 10,
+		BookingID:       bookingID,
+		CustomerEmail:   "email@example.com",
+		ShowId:          uuid.New(),
+	})
+	require.NoError(t, err)
+
+	var ticketIDs []string
+	for i := 0; i < 10; i++ {
+		ticketID := uuid.NewString()
+		ticketIDs = append(ticketIDs, ticketID)
+
+		err := readModel.Projection().HandleEvent(ctx, &entities.TicketBookingConfirmed_v1{
+			Header:    entities.NewEventHeader(),
+			TicketID:  ticketID,
+			Price:     entities.Money{Amount: "100", Currency: "EUR"},
+			BookingID: bookingID.String(),
+		})
+		require.NoError(t, err)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, ticketID := range ticketIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := retryOnConcurrentModification(func() error {
+				return readModel.Projection().HandleEvent(ctx, &entities.TicketPrinted_v1{
+					Header:   entities.NewEventHeader(),
+					TicketID: ticketID,
+					FileName: ticketID + "-ticket.html",
+				})
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	booking, err := readModel.GetByID(ctx, bookingID.String())
+	require.NoError(t, err)
+	for _, ticketID := range ticketIDs {
+		assert.Equal(t, ticketID+"-ticket.html", booking.Tickets[ticketID].PrintedFileName, "update of ticket %s was lost", ticketID)
+	}
+
+	var version int
+	err = dbconn.Get(&version, "SELECT version FROM projection_ops_bookings WHERE id = $1", bookingID.String())
+	require.NoError(t, err)
+	assert.Equal(t, 1+len(ticketIDs)*2, version)
+}
+
+// retryOnConcurrentModification retries like the router does.
+func retryOnConcurrentModification(fn func() error) error {
+	for {
+		err := fn()
+
+		var concurrentModificationErr ErrConcurrentModification
+		if !errors.As(err, &concurrentModificationErr) {
+			return err
+		}
+	}
+}
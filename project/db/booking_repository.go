@@ -3,13 +3,18 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"tickets/booking"
 	"tickets/entities"
-	"tickets/message/event"
 	"tickets/message/outbox"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
 
@@ -18,96 +23,192 @@ var (
 	ErrNoPlacesLeft         = errors.New("no places left")
 )
 
+const (
+	bookingAggregate = "booking"
+	// bookingSnapshotEvery is the number of events appended to a booking stream between its snapshots.
+	bookingSnapshotEvery = 10
+)
+
+// bookingEvents are the events of booking streams, by name.
+var bookingEvents = map[string]func() entities.IEvent{
+	"BookingMade_v1":        func() entities.IEvent { return &entities.BookingMade_v1{} },
+	"BookingCanceled_v1":    func() entities.IEvent { return &entities.BookingCanceled_v1{} },
+	"BookingTransferred_v1": func() entities.IEvent { return &entities.BookingTransferred_v1{} },
+	"BookingRefunded_v1":    func() entities.IEvent { return &entities.BookingRefunded_v1{} },
+}
+
 type IBookingRepository interface {
 	Create(ctx context.Context, booking entities.Booking) (entities.BookingCreateResponse, error)
 }
 
+// BookingRepository stores bookings in the event store.
+// The bookings table is updated in the same transaction, as it's used to count the booked places of shows.
 type BookingRepository struct {
-	db       *DB
-	showRepo ShowRepository
+	db               *DB
+	eventStore       EventStore
+	showRepo         ShowRepository
+	outboxPartitions outbox.Partitions
 }
 
-func NewBookingRespository(db *DB) BookingRepository {
+func NewBookingRespository(db *DB, outboxPartitions outbox.Partitions, newEventBus func(message.Publisher) *cqrs.EventBus) BookingRepository {
 	if db == nil {
 		panic("db is nil")
 	}
 	return BookingRepository{
-		db:       db,
-		showRepo: NewShowRepository(db),
+		db:               db,
+		eventStore:       NewEventStore(db, outboxPartitions, newEventBus),
+		showRepo:         NewShowRepository(db),
+		outboxPartitions: outboxPartitions,
 	}
 }
 
-func (br BookingRepository) Create(ctx context.Context, booking entities.Booking) (entities.BookingCreateResponse, error) {
-	tx, err := br.db.Conn.BeginTxx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelSerializable})
+func (br BookingRepository) Create(ctx context.Context, newBooking entities.Booking) (entities.BookingCreateResponse, error) {
+	b, err := booking.New(newBooking.BookingID, newBooking.ShowID, newBooking.NumberOfTickets, newBooking.CustomerEmail)
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("could not begin transaction: %w", err)
+		return entities.BookingCreateResponse{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	defer func() {
+	err = updateInTx(ctx, br.db.Conn, sql.LevelSerializable, func(ctx context.Context, tx *sqlx.Tx) error {
+		availableSeats := 0
+		err := tx.GetContext(ctx, &availableSeats, `
+			SELECT
+			    number_of_tickets AS available_seats
+			FROM
+			    shows
+			WHERE
+			    show_id = $1
+		`, newBooking.ShowID)
 		if err != nil {
-			rollbackErr := tx.Rollback()
-			err = errors.Join(err, rollbackErr)
-			return
+			return fmt.Errorf("could not get available seats: %w", err)
+		}
+
+		alreadyBookedSeats := 0
+		err = tx.GetContext(ctx, &alreadyBookedSeats, `
+			SELECT
+			    coalesce(SUM(number_of_tickets), 0) AS already_booked_seats
+			FROM
+			    bookings
+			WHERE
+			    show_id = $1 AND canceled_at IS NULL
+		`, newBooking.ShowID)
+		if err != nil {
+			return fmt.Errorf("could not get already booked seats: %w", err)
+		}
+
+		if availableSeats-alreadyBookedSeats < newBooking.NumberOfTickets {
+			return echo.NewHTTPError(http.StatusBadRequest, "not enough seats available")
+		}
+
+		_, err = tx.NamedExecContext(ctx, `
+			INSERT INTO
+			    bookings (booking_id, show_id, number_of_tickets, customer_email)
+			VALUES (:booking_id, :show_id, :number_of_tickets, :customer_email)
+			`, newBooking)
+		if isErrorUniqueViolation(err) {
+			return ErrBookingAlreadyExists
 		}
-		err = tx.Commit()
-	}()
-
-	availableSeats := 0
-	err = tx.GetContext(ctx, &availableSeats, `
-		SELECT
-		    number_of_tickets AS available_seats
-		FROM
-		    shows
-		WHERE
-		    show_id = $1
-	`, booking.ShowID)
+		if err != nil {
+			return fmt.Errorf("could not add booking: %w", err)
+		}
+
+		return br.eventStore.Append(ctx, bookingAggregate, newBooking.BookingID.String(), 0, b.Changes())
+	})
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("could not get available seats: %w", err)
+		return entities.BookingCreateResponse{}, err
 	}
 
-	alreadyBookedSeats := 0
-	err = tx.GetContext(ctx, &alreadyBookedSeats, `
-		SELECT
-		    coalesce(SUM(number_of_tickets), 0) AS already_booked_seats
-		FROM
-		    bookings
-		WHERE
-		    show_id = $1
-	`, booking.ShowID)
+	return entities.BookingCreateResponse{BookingID: newBooking.BookingID}, nil
+}
+
+// Get rebuilds the booking from its latest snapshot and the events appended after it.
+func (br BookingRepository) Get(ctx context.Context, bookingID uuid.UUID) (*booking.Booking, error) {
+	snapshot, err := br.eventStore.Snapshot(ctx, bookingAggregate, bookingID.String())
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("could not get already booked seats: %w", err)
+		return nil, err
 	}
 
-	if availableSeats-alreadyBookedSeats < booking.NumberOfTickets {
-		return entities.BookingCreateResponse{}, echo.NewHTTPError(http.StatusBadRequest, "not enough seats available")
+	var state booking.Snapshot
+	if snapshot.Version > 0 {
+		state.Version = snapshot.Version
+		if err := json.Unmarshal(snapshot.Payload, &state.State); err != nil {
+			return nil, fmt.Errorf("could not unmarshal snapshot of booking %s: %w", bookingID, err)
+		}
 	}
 
-	_, err = tx.NamedExecContext(ctx, `
-		INSERT INTO 
-		    bookings (booking_id, show_id, number_of_tickets, customer_email) 
-		VALUES (:booking_id, :show_id, :number_of_tickets, :customer_email)
-		`, booking)
+	stored, err := br.eventStore.Load(ctx, bookingAggregate, bookingID.String(), state.Version)
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("could not add booking: %w", err)
+		return nil, err
+	}
+	if state.Version == 0 && len(stored) == 0 {
+		return nil, fmt.Errorf("%w: %s", booking.ErrNotFound, bookingID)
+	}
+
+	events := make([]entities.IEvent, 0, len(stored))
+	for _, s := range stored {
+		newEvent, ok := bookingEvents[s.EventName]
+		if !ok {
+			return nil, fmt.Errorf("unknown event %s in booking %s stream", s.EventName, bookingID)
+		}
+
+		e := newEvent()
+		if err := json.Unmarshal(s.Payload, e); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %s of booking %s: %w", s.EventName, bookingID, err)
+		}
+		events = append(events, e)
 	}
 
-	outBoxPuslisher, err := outbox.NewPublisherForDb(ctx, tx)
+	b, err := booking.FromHistory(state, events)
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("error creating event outbox publisher %w", err)
+		return nil, fmt.Errorf("could not load booking %s: %w", bookingID, err)
 	}
-	err = event.NewBus(outBoxPuslisher).Publish(ctx, entities.BookingMade_v1{
-		Header:          entities.NewEventHeader(),
-		BookingID:       booking.BookingID,
-		NumberOfTickets: booking.NumberOfTickets,
-		CustomerEmail:   booking.CustomerEmail,
-		ShowId:          booking.ShowID,
+
+	return b, nil
+}
+
+// Update appends the events recorded by updateFn to the booking stream.
+// It fails with ErrConcurrentModification if the stream was appended to after the booking was loaded.
+func (br BookingRepository) Update(
+	ctx context.Context,
+	bookingID uuid.UUID,
+	updateFn func(b *booking.Booking) error,
+) (*booking.Booking, error) {
+	var b *booking.Booking
+
+	err := updateInTx(ctx, br.db.Conn, sql.LevelReadCommitted, func(ctx context.Context, tx *sqlx.Tx) error {
+		var err error
+		b, err = br.Get(ctx, bookingID)
+		if err != nil {
+			return err
+		}
+
+		if err := updateFn(b); err != nil {
+			return err
+		}
+		if len(b.Changes()) == 0 {
+			return nil
+		}
+
+		if err := br.eventStore.Append(ctx, bookingAggregate, bookingID.String(), b.Version(), b.Changes()); err != nil {
+			return err
+		}
+
+		snapshot := b.Snapshot()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE bookings SET customer_email = $1, canceled_at = $2 WHERE booking_id = $3
+		`, snapshot.State.CustomerEmail, snapshot.State.CanceledAt, bookingID)
+		if err != nil {
+			return fmt.Errorf("could not update booking %s: %w", bookingID, err)
+		}
+
+		if snapshot.Version/bookingSnapshotEvery > b.Version()/bookingSnapshotEvery {
+			return br.eventStore.SaveSnapshot(ctx, bookingAggregate, bookingID.String(), snapshot.Version, snapshot.State)
+		}
+
+		return nil
 	})
 	if err != nil {
-		return entities.BookingCreateResponse{}, fmt.Errorf("could not publish event: %w", err)
+		return nil, fmt.Errorf("could not update booking: %w", err)
 	}
 
-	fmt.Println("Booking ID: %s", booking.BookingID)
-	return entities.BookingCreateResponse{BookingID: booking.BookingID}, nil
-
+	return b, nil
 }
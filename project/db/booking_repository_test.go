@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"tickets/booking"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/message/outbox"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookingRepository_eventSourced(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	repo := NewBookingRespository(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	show, err := NewShowRepository(&db).Create(ctx, entities.Show{
+		DeadNationID:    uuid.New(),
+		NumberOfTickets: 2,
+		Title:           "Event Sourcing Live",
+		Venue:           "Somewhere",
+	})
+	require.NoError(t, err)
+
+	bookingID := uuid.New()
+	_, err = repo.Create(ctx, entities.Booking{
+		BookingID:       bookingID,
+		ShowID:          show.ShowID,
+		NumberOfTickets: 2,
+		CustomerEmail:   "customer-0@example.com",
+	})
+	require.NoError(t, err)
+
+	_, err = repo.Create(ctx, entities.Booking{
+		BookingID:       uuid.New(),
+		ShowID:          show.ShowID,
+		NumberOfTickets: 1,
+		CustomerEmail:   "other@example.com",
+	})
+	require.Error(t, err, "the show is sold out")
+
+	transfers := bookingSnapshotEvery + 2
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= transfers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := retryOnConcurrentModification(func() error {
+				_, err := repo.Update(ctx, bookingID, func(b *booking.Booking) error {
+					return b.Transfer(uuid.NewString() + "@example.com")
+				})
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	snapshot, err := repo.eventStore.Snapshot(ctx, bookingAggregate, bookingID.String())
+	require.NoError(t, err)
+	assert.Equal(t, bookingSnapshotEvery, snapshot.Version)
+
+	b, err := repo.Update(ctx, bookingID, func(b *booking.Booking) error {
+		return b.Cancel("customer request")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1+transfers+1, b.Snapshot().Version, "some appends were lost")
+
+	// canceled places can be booked again
+	_, err = repo.Create(ctx, entities.Booking{
+		BookingID:       uuid.New(),
+		ShowID:          show.ShowID,
+		NumberOfTickets: 2,
+		CustomerEmail:   "other@example.com",
+	})
+	require.NoError(t, err)
+
+	loaded, err := repo.Get(ctx, bookingID)
+	require.NoError(t, err)
+	assert.Equal(t, b.Snapshot().Version, loaded.Version())
+	assert.Equal(t, b.CustomerEmail, loaded.CustomerEmail)
+	assert.ErrorIs(t, loaded.Cancel(""), booking.ErrCanceled)
+
+	_, err = repo.Get(ctx, uuid.New())
+	assert.ErrorIs(t, err, booking.ErrNotFound)
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// DataLakeExportRepository stores the high-water marks of data lake exports.
+type DataLakeExportRepository struct {
+	db *DB
+}
+
+func NewDataLakeExportRepository(db *DB) DataLakeExportRepository {
+	if db == nil {
+		panic("db is nil")
+	}
+
+	return DataLakeExportRepository{db: db}
+}
+
+// LastCursor returns the cursor of the last exported event, or an empty cursor if nothing was exported yet.
+func (r DataLakeExportRepository) LastCursor(ctx context.Context, export string) (string, error) {
+	var cursor string
+	err := r.db.Conn.GetContext(ctx, &cursor, "SELECT last_cursor FROM data_lake_exports WHERE export = $1", export)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get %s export high-water mark: %w", export, err)
+	}
+
+	return cursor, nil
+}
+
+func (r DataLakeExportRepository) SaveCursor(ctx context.Context, export string, cursor string, eventsExported int) error {
+	_, err := r.db.Conn.ExecContext(ctx, `
+		INSERT INTO data_lake_exports (export, last_cursor, events_exported, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (export) DO UPDATE SET
+			last_cursor = excluded.last_cursor,
+			events_exported = data_lake_exports.events_exported + excluded.events_exported,
+			updated_at = excluded.updated_at
+	`, export, cursor, eventsExported)
+	if err != nil {
+		return fmt.Errorf("could not save %s export high-water mark: %w", export, err)
+	}
+
+	return nil
+}
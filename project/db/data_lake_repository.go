@@ -2,22 +2,48 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"tickets/entities"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 )
 
 type IEventRepository interface {
 	Create(ctx context.Context, event entities.Event) error
-	GetAll(ctx context.Context) ([]entities.Event, error)
+	Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error
 }
 
+// ColdStorage holds events archived out of the events table.
+type ColdStorage interface {
+	// Events iterates over archived events matching the event names and time range of the query,
+	// following the position, in the data lake order.
+	Events(ctx context.Context, query entities.EventQuery, after DataLakePosition) (ColdEvents, error)
+	Count() (int, error)
+}
+
+type ColdEvents interface {
+	Next() (entities.Event, bool, error)
+	Close() error
+}
+
+type noColdEvents struct{}
+
+func (noColdEvents) Next() (entities.Event, bool, error) { return entities.Event{}, false, nil }
+func (noColdEvents) Close() error                        { return nil }
+
 type EventRepository struct {
-	db       *DB
-	eventBus *cqrs.EventBus
+	db          *DB
+	eventBus    *cqrs.EventBus
+	coldStorage ColdStorage
 }
 
 func NewEventRepository(db *DB, eventBus *cqrs.EventBus) EventRepository {
@@ -30,6 +56,12 @@ func NewEventRepository(db *DB, eventBus *cqrs.EventBus) EventRepository {
 	}
 }
 
+// WithColdStorage returns the repository reading also archived events.
+func (e EventRepository) WithColdStorage(coldStorage ColdStorage) EventRepository {
+	e.coldStorage = coldStorage
+	return e
+}
+
 func (s EventRepository) Create(
 	ctx context.Context,
 	dataLakeEvent entities.Event,
@@ -55,11 +87,468 @@ func (s EventRepository) Create(
 	return nil
 }
 
-func (e EventRepository) GetAll(ctx context.Context) ([]entities.Event, error) {
+// Query streams events matching the query to fn, in the order they were published.
+// The cursor passed with each event continues the query after it.
+//
+// Archived events are included. Postgres evaluates the JSONPath predicate for them as well, in batches.
+func (e EventRepository) Query(
+	ctx context.Context,
+	query entities.EventQuery,
+	fn func(event entities.Event, cursor string) error,
+) error {
+	var after DataLakePosition
+	if query.After != "" {
+		var err error
+		if after, err = decodeEventCursor(query.After); err != nil {
+			return err
+		}
+	}
+
+	if query.JSONPath != "" {
+		if _, err := e.db.Conn.ExecContext(ctx, "SELECT $1::jsonpath", query.JSONPath); err != nil {
+			return fmt.Errorf("%w: jsonpath: %s", entities.ErrInvalidEventQuery, err)
+		}
+	}
+
+	return e.stream(ctx, query, after, func(event entities.Event) error {
+		return fn(event, encodeEventCursor(positionOf(event)))
+	})
+}
+
+// stream merges events from the events table with archived events.
+func (e EventRepository) stream(
+	ctx context.Context,
+	query entities.EventQuery,
+	after DataLakePosition,
+	fn func(event entities.Event) error,
+) error {
+	hot, err := e.queryHot(ctx, query, after)
+	if err != nil {
+		return err
+	}
+	defer hot.Close()
+
+	var cold ColdEvents = noColdEvents{}
+	if e.coldStorage != nil {
+		cold, err = e.coldStorage.Events(ctx, query, after)
+		if err != nil {
+			return fmt.Errorf("could not read archived events: %w", err)
+		}
+	}
+	defer cold.Close()
+
+	if query.JSONPath != "" {
+		cold = &jsonPathColdEvents{ColdEvents: cold, ctx: ctx, db: e.db, jsonPath: query.JSONPath}
+	}
+
+	nextHot := func() (entities.Event, bool, error) {
+		var event entities.Event
+		if !hot.Next() {
+			return event, false, hot.Err()
+		}
+		err := hot.StructScan(&event)
+		return event, err == nil, err
+	}
+	nextCold := func() (entities.Event, bool, error) {
+		for {
+			event, ok, err := cold.Next()
+			if !ok || err != nil || matchesAggregateKeys(event, query) {
+				return event, ok, err
+			}
+		}
+	}
+
+	hotEvent, hotOK, err := nextHot()
+	if err != nil {
+		return fmt.Errorf("could not query events: %w", err)
+	}
+	coldEvent, coldOK, err := nextCold()
+	if err != nil {
+		return fmt.Errorf("could not read archived events: %w", err)
+	}
+
+	for sent := 0; (hotOK || coldOK) && (query.Limit <= 0 || sent < query.Limit); sent++ {
+		event := coldEvent
+		advanceHot := false
+		advanceCold := false
+
+		switch {
+		case hotOK && coldOK && positionOf(hotEvent).equal(positionOf(coldEvent)):
+			// an archived event stored again, for example by a redelivery
+			event = hotEvent
+			advanceHot, advanceCold = true, true
+		case !coldOK || (hotOK && positionOf(hotEvent).Before(positionOf(coldEvent))):
+			event = hotEvent
+			advanceHot = true
+		default:
+			advanceCold = true
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+
+		if advanceHot {
+			if hotEvent, hotOK, err = nextHot(); err != nil {
+				return fmt.Errorf("could not query events: %w", err)
+			}
+		}
+		if advanceCold {
+			if coldEvent, coldOK, err = nextCold(); err != nil {
+				return fmt.Errorf("could not read archived events: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e EventRepository) queryHot(ctx context.Context, query entities.EventQuery, after DataLakePosition) (*sqlx.Rows, error) {
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(query.EventNames) > 0 {
+		conditions = append(conditions, "event_name = ANY("+arg(pq.StringArray(query.EventNames))+")")
+	}
+	if query.From != nil {
+		conditions = append(conditions, "published_at >= "+arg(query.From.UTC()))
+	}
+	if query.To != nil {
+		conditions = append(conditions, "published_at < "+arg(query.To.UTC()))
+	}
+
+	// the expressions match the indexes on events
+	aggregateKeys := []struct{ expression, value string }{
+		{"event_payload->>'booking_id'", query.BookingID},
+		{"event_payload->>'ticket_id'", query.TicketID},
+		{"event_payload->>'vip_bundle_id'", query.VipBundleID},
+	}
+	for _, key := range aggregateKeys {
+		if key.value != "" {
+			conditions = append(conditions, key.expression+" = "+arg(key.value))
+		}
+	}
+
+	if query.JSONPath != "" {
+		conditions = append(conditions, "event_payload @@ "+arg(query.JSONPath)+"::jsonpath")
+	}
+
+	if !after.equal(DataLakePosition{}) {
+		conditions = append(conditions, "(published_at, event_id) > ("+arg(after.PublishedAt)+", "+arg(after.EventID)+")")
+	}
+
+	sqlQuery := "SELECT " + eventColumns + " FROM events"
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY published_at ASC, event_id ASC"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT " + arg(query.Limit)
+	}
+
+	rows, err := e.db.Conn.QueryxContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query events: %w", err)
+	}
+
+	return rows, nil
+}
+
+// jsonPathColdBatchSize is the number of archived events evaluated by Postgres at once.
+const jsonPathColdBatchSize = 100
+
+// jsonPathColdEvents filters archived events by the JSONPath predicate, which only Postgres evaluates.
+type jsonPathColdEvents struct {
+	ColdEvents
+
+	ctx      context.Context
+	db       *DB
+	jsonPath string
+
+	matching []entities.Event
+	done     bool
+}
+
+func (c *jsonPathColdEvents) Next() (entities.Event, bool, error) {
+	for len(c.matching) == 0 {
+		if c.done {
+			return entities.Event{}, false, nil
+		}
+		if err := c.readBatch(); err != nil {
+			return entities.Event{}, false, err
+		}
+	}
+
+	event := c.matching[0]
+	c.matching = c.matching[1:]
+
+	return event, true, nil
+}
+
+func (c *jsonPathColdEvents) readBatch() error {
+	var batch []entities.Event
+	var payloads []string
+	for len(batch) < jsonPathColdBatchSize {
+		event, ok, err := c.ColdEvents.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.done = true
+			break
+		}
+		batch = append(batch, event)
+		payloads = append(payloads, string(event.EventPayload))
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var matching []int
+	err := c.db.Conn.SelectContext(c.ctx, &matching, `
+		SELECT i - 1 FROM unnest($1::JSONB[]) WITH ORDINALITY AS payloads(payload, i)
+		WHERE payload @@ $2::jsonpath
+		ORDER BY i
+	`, pq.StringArray(payloads), c.jsonPath)
+	if err != nil {
+		return fmt.Errorf("could not evaluate jsonpath for archived events: %w", err)
+	}
+
+	for _, i := range matching {
+		c.matching = append(c.matching, batch[i])
+	}
+
+	return nil
+}
+
+// matchesAggregateKeys filters archived events, which aren't indexed.
+func matchesAggregateKeys(event entities.Event, query entities.EventQuery) bool {
+	if query.BookingID == "" && query.TicketID == "" && query.VipBundleID == "" {
+		return true
+	}
+
+	var keys struct {
+		BookingID   string `json:"booking_id"`
+		TicketID    string `json:"ticket_id"`
+		VipBundleID string `json:"vip_bundle_id"`
+	}
+	if err := json.Unmarshal(event.EventPayload, &keys); err != nil {
+		return false
+	}
+
+	return (query.BookingID == "" || keys.BookingID == query.BookingID) &&
+		(query.TicketID == "" || keys.TicketID == query.TicketID) &&
+		(query.VipBundleID == "" || keys.VipBundleID == query.VipBundleID)
+}
+
+// eventColumns are scanned into entities.Event.
+const eventColumns = "event_id, published_at, event_name, event_payload"
+
+// ingestionSettleTime is how long an export waits for events stored in transactions committed out of order.
+const ingestionSettleTime = time.Minute
+
+// QueryIngested streams events matching the event names and time range of the query in the order
+// they were stored in the data lake, so events stored late (with an earlier published_at) are not skipped.
+// The cursor passed with each event continues the query after it.
+//
+// Events are read from the events table only, so events archived before they were read are not included.
+func (e EventRepository) QueryIngested(
+	ctx context.Context,
+	query entities.EventQuery,
+	fn func(event entities.Event, cursor string) error,
+) error {
+	after, err := e.ingestedAfter(ctx, query.After)
+	if err != nil {
+		return err
+	}
+
+	conditions := []string{"ingested_id > $1", "ingested_at < NOW() - make_interval(secs => $2)"}
+	args := []any{after, ingestionSettleTime.Seconds()}
+	if len(query.EventNames) > 0 {
+		args = append(args, pq.StringArray(query.EventNames))
+		conditions = append(conditions, fmt.Sprintf("event_name = ANY($%d)", len(args)))
+	}
+	if query.From != nil {
+		args = append(args, query.From.UTC())
+		conditions = append(conditions, fmt.Sprintf("published_at >= $%d", len(args)))
+	}
+	if query.To != nil {
+		args = append(args, query.To.UTC())
+		conditions = append(conditions, fmt.Sprintf("published_at < $%d", len(args)))
+	}
+
+	rows, err := e.db.Conn.QueryxContext(
+		ctx,
+		"SELECT ingested_id, "+eventColumns+" FROM events WHERE "+strings.Join(conditions, " AND ")+" ORDER BY ingested_id",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not query events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row struct {
+			IngestedID int64 `db:"ingested_id"`
+			entities.Event
+		}
+		if err := rows.StructScan(&row); err != nil {
+			return fmt.Errorf("could not scan event: %w", err)
+		}
+
+		if err := fn(row.Event, strconv.FormatInt(row.IngestedID, 10)); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not query events: %w", err)
+	}
+
+	return nil
+}
+
+// ingestedAfter returns the ingestion ID of the cursor. Cursors of Query, stored by exports before they
+// followed the ingestion order, continue after the event they point at.
+func (e EventRepository) ingestedAfter(ctx context.Context, cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	if id, err := strconv.ParseInt(cursor, 10, 64); err == nil {
+		return id, nil
+	}
+
+	position, err := decodeEventCursor(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = e.db.Conn.GetContext(ctx, &id, "SELECT ingested_id FROM events WHERE event_id = $1", position.EventID)
+	if err != nil {
+		return 0, fmt.Errorf("could not find the ingestion ID of event %s: %w", position.EventID, err)
+	}
+
+	return id, nil
+}
+
+func encodeEventCursor(position DataLakePosition) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(position.PublishedAt.UTC().Format(time.RFC3339Nano) + "/" + position.EventID),
+	)
+}
+
+func decodeEventCursor(cursor string) (DataLakePosition, error) {
+	invalid := fmt.Errorf("%w: malformed cursor", entities.ErrInvalidEventQuery)
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return DataLakePosition{}, invalid
+	}
+
+	publishedAt, eventID, ok := strings.Cut(string(decoded), "/")
+	if !ok {
+		return DataLakePosition{}, invalid
+	}
+
+	position := DataLakePosition{EventID: eventID}
+	if position.PublishedAt, err = time.Parse(time.RFC3339Nano, publishedAt); err != nil {
+		return DataLakePosition{}, invalid
+	}
+	if _, err := uuid.Parse(eventID); err != nil {
+		return DataLakePosition{}, invalid
+	}
+
+	return position, nil
+}
+
+// DataLakePosition points at an event in the data lake, which is read in (published_at, event_id) order.
+// The zero value points before the first event.
+type DataLakePosition struct {
+	PublishedAt time.Time
+	EventID     string
+}
+
+func positionOf(event entities.Event) DataLakePosition {
+	return DataLakePosition{PublishedAt: event.PublishedAt, EventID: event.EventID}
+}
+
+// Before compares positions like Postgres compares (published_at, event_id), as event IDs are lowercase UUIDs.
+func (p DataLakePosition) Before(other DataLakePosition) bool {
+	if !p.PublishedAt.Equal(other.PublishedAt) {
+		return p.PublishedAt.Before(other.PublishedAt)
+	}
+
+	return p.EventID < other.EventID
+}
+
+func (p DataLakePosition) equal(other DataLakePosition) bool {
+	return p.PublishedAt.Equal(other.PublishedAt) && p.EventID == other.EventID
+}
+
+// GetAfter returns up to limit events following the position.
+func (e EventRepository) GetAfter(ctx context.Context, after DataLakePosition, limit int) ([]entities.Event, error) {
 	var events []entities.Event
-	err := e.db.Conn.SelectContext(ctx, &events, "SELECT * FROM events ORDER BY published_at ASC")
+	err := e.stream(ctx, entities.EventQuery{Limit: limit}, after, func(event entities.Event) error {
+		events = append(events, event)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting all events %w", err)
+		return nil, fmt.Errorf("could not get events after %s: %w", after.EventID, err)
 	}
+
 	return events, nil
 }
+
+func (e EventRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	if err := e.db.Conn.GetContext(ctx, &count, "SELECT COUNT(*) FROM events"); err != nil {
+		return 0, fmt.Errorf("could not count events: %w", err)
+	}
+
+	if e.coldStorage != nil {
+		archived, err := e.coldStorage.Count()
+		if err != nil {
+			return 0, fmt.Errorf("could not count archived events: %w", err)
+		}
+		count += archived
+	}
+
+	return count, nil
+}
+
+// Delete removes archived events from the events table.
+func (e EventRepository) Delete(ctx context.Context, eventIDs []string) error {
+	_, err := e.db.Conn.ExecContext(ctx, "DELETE FROM events WHERE event_id = ANY($1::UUID[])", pq.StringArray(eventIDs))
+	if err != nil {
+		return fmt.Errorf("could not delete events: %w", err)
+	}
+
+	return nil
+}
+
+// Months returns event names with the months (in UTC) having events published before the time.
+func (e EventRepository) Months(ctx context.Context, before time.Time) ([]EventMonth, error) {
+	var months []EventMonth
+	err := e.db.Conn.SelectContext(ctx, &months, `
+		SELECT DISTINCT event_name, date_trunc('month', published_at) AS month
+		FROM events
+		WHERE published_at < $1
+		ORDER BY event_name, month
+	`, before.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not get event months: %w", err)
+	}
+
+	return months, nil
+}
+
+type EventMonth struct {
+	EventName string    `db:"event_name"`
+	Month     time.Time `db:"month"`
+}
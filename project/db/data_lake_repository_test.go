@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventRepository_Query(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	repo := NewEventRepository(&db, nil)
+	ctx := context.Background()
+
+	bookingID := uuid.NewString()
+
+	var eventIDs []string
+	for _, currency := range []string{"EUR", "USD", "EUR"} {
+		event := entities.TicketBookingConfirmed_v1{
+			Header:    entities.NewEventHeader(),
+			TicketID:  uuid.NewString(),
+			Price:     entities.Money{Amount: "100", Currency: currency},
+			BookingID: bookingID,
+		}
+		payload, err := json.Marshal(event)
+		require.NoError(t, err)
+
+		err = repo.Create(ctx, entities.Event{
+			EventID:      event.Header.ID,
+			PublishedAt:  event.Header.PublishedAt,
+			EventName:    cqrs.StructName(event),
+			EventPayload: payload,
+		})
+		require.NoError(t, err)
+
+		eventIDs = append(eventIDs, event.Header.ID)
+	}
+
+	query := func(q entities.EventQuery) ([]string, string) {
+		var ids []string
+		var lastCursor string
+		err := repo.Query(ctx, q, func(event entities.Event, cursor string) error {
+			ids = append(ids, event.EventID)
+			lastCursor = cursor
+			return nil
+		})
+		require.NoError(t, err)
+		return ids, lastCursor
+	}
+
+	ids, cursor := query(entities.EventQuery{BookingID: bookingID, Limit: 2})
+	assert.Equal(t, eventIDs[:2], ids)
+
+	ids, _ = query(entities.EventQuery{BookingID: bookingID, After: cursor, Limit: 2})
+	assert.Equal(t, eventIDs[2:], ids)
+
+	ids, _ = query(entities.EventQuery{BookingID: bookingID, JSONPath: `$.price.currency == "EUR"`})
+	assert.Equal(t, []string{eventIDs[0], eventIDs[2]}, ids)
+
+	err := repo.Query(ctx, entities.EventQuery{JSONPath: "$.price ==="}, func(entities.Event, string) error { return nil })
+	assert.ErrorIs(t, err, entities.ErrInvalidEventQuery)
+
+	err = repo.Query(ctx, entities.EventQuery{After: "not a cursor"}, func(entities.Event, string) error { return nil })
+	assert.ErrorIs(t, err, entities.ErrInvalidEventQuery)
+}
+
+type fakeColdStorage struct {
+	events []entities.Event
+}
+
+func (f fakeColdStorage) Events(ctx context.Context, query entities.EventQuery, after DataLakePosition) (ColdEvents, error) {
+	return &fakeColdEvents{events: f.events}, nil
+}
+
+func (f fakeColdStorage) Count() (int, error) {
+	return len(f.events), nil
+}
+
+type fakeColdEvents struct {
+	events []entities.Event
+}
+
+func (f *fakeColdEvents) Next() (entities.Event, bool, error) {
+	if len(f.events) == 0 {
+		return entities.Event{}, false, nil
+	}
+
+	event := f.events[0]
+	f.events = f.events[1:]
+	return event, true, nil
+}
+
+func (f *fakeColdEvents) Close() error {
+	return nil
+}
+
+func TestEventRepository_Query_archivedWithJSONPath(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	ctx := context.Background()
+
+	bookingID := uuid.NewString()
+
+	var archived []entities.Event
+	for _, currency := range []string{"EUR", "USD", "EUR"} {
+		event := entities.TicketBookingConfirmed_v1{
+			Header:    entities.NewEventHeader(),
+			TicketID:  uuid.NewString(),
+			Price:     entities.Money{Amount: "100", Currency: currency},
+			BookingID: bookingID,
+		}
+		payload, err := json.Marshal(event)
+		require.NoError(t, err)
+
+		archived = append(archived, entities.Event{
+			EventID:      event.Header.ID,
+			PublishedAt:  event.Header.PublishedAt,
+			EventName:    cqrs.StructName(event),
+			EventPayload: payload,
+		})
+	}
+
+	repo := NewEventRepository(&db, nil).WithColdStorage(fakeColdStorage{events: archived})
+
+	var ids []string
+	err := repo.Query(ctx, entities.EventQuery{BookingID: bookingID, JSONPath: `$.price.currency == "EUR"`}, func(event entities.Event, cursor string) error {
+		ids = append(ids, event.EventID)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{archived[0].EventID, archived[2].EventID}, ids)
+}
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/lib/pq"
 )
@@ -14,3 +16,28 @@ func isErrorUniqueViolation(err error) bool {
 	var psqlErr *pq.Error
 	return errors.As(err, &psqlErr) && psqlErr.Code == postgresUniqueValueViolationErrorCode
 }
+
+// ErrConcurrentModification is returned when the row was modified by someone else
+// since it was read. The operation can be safely retried.
+type ErrConcurrentModification struct {
+	Entity          string
+	ID              string
+	ExpectedVersion int
+}
+
+func (e ErrConcurrentModification) Error() string {
+	return fmt.Sprintf("%s %s was modified concurrently (expected version %d)", e.Entity, e.ID, e.ExpectedVersion)
+}
+
+// compareAndSwap checks the result of an UPDATE guarded by the expected version.
+func compareAndSwap(res sql.Result, entity string, id string, expectedVersion int) error {
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check updated rows: %w", err)
+	}
+	if updated == 0 {
+		return ErrConcurrentModification{Entity: entity, ID: id, ExpectedVersion: expectedVersion}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"tickets/entities"
+	"tickets/message/outbox"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jmoiron/sqlx"
+)
+
+// EventStore keeps the events of event-sourced aggregates, in one stream per aggregate.
+//
+// Appends expect the version the stream had when the aggregate was loaded, so concurrent appends
+// fail with ErrConcurrentModification. Appended events are published through the outbox in the same transaction.
+type EventStore struct {
+	db               *sqlx.DB
+	outboxPartitions outbox.Partitions
+	newEventBus      func(message.Publisher) *cqrs.EventBus
+}
+
+func NewEventStore(db *DB, outboxPartitions outbox.Partitions, newEventBus func(message.Publisher) *cqrs.EventBus) EventStore {
+	if db == nil {
+		panic("db is nil")
+	}
+	if newEventBus == nil {
+		panic("newEventBus is nil")
+	}
+
+	return EventStore{db: db.Conn, outboxPartitions: outboxPartitions, newEventBus: newEventBus}
+}
+
+type StoredEvent struct {
+	Version   int             `db:"version"`
+	EventName string          `db:"event_name"`
+	Payload   json.RawMessage `db:"payload"`
+}
+
+type StoredSnapshot struct {
+	Version int             `db:"version"`
+	Payload json.RawMessage `db:"payload"`
+}
+
+func (s EventStore) Append(
+	ctx context.Context,
+	aggregateType string,
+	aggregateID string,
+	expectedVersion int,
+	events []entities.IEvent,
+) error {
+	return updateInTx(ctx, s.db, sql.LevelReadCommitted, func(ctx context.Context, tx *sqlx.Tx) error {
+		for i, e := range events {
+			payload, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("could not marshal %T: %w", e, err)
+			}
+
+			var header struct {
+				Header entities.EventHeader `json:"header"`
+			}
+			if err := json.Unmarshal(payload, &header); err != nil || header.Header.ID == "" {
+				return fmt.Errorf("%T has no header id", e)
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO event_store (aggregate_type, aggregate_id, version, event_id, event_name, payload)
+				VALUES ($1, $2, $3, $4, $5, $6)
+			`, aggregateType, aggregateID, expectedVersion+i+1, header.Header.ID, cqrs.StructName(e), payload)
+			if isErrorUniqueViolation(err) {
+				return ErrConcurrentModification{Entity: aggregateType, ID: aggregateID, ExpectedVersion: expectedVersion}
+			}
+			if err != nil {
+				return fmt.Errorf("could not append %T to %s %s: %w", e, aggregateType, aggregateID, err)
+			}
+		}
+
+		outboxPublisher, err := outbox.NewPublisherForDb(ctx, tx, s.outboxPartitions, aggregateID)
+		if err != nil {
+			return fmt.Errorf("could not create outbox publisher: %w", err)
+		}
+
+		eventBus := s.newEventBus(outboxPublisher)
+		for _, e := range events {
+			if err := eventBus.Publish(ctx, e); err != nil {
+				return fmt.Errorf("could not publish %T: %w", e, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Load returns the events of the stream following the version.
+func (s EventStore) Load(ctx context.Context, aggregateType string, aggregateID string, afterVersion int) ([]StoredEvent, error) {
+	var events []StoredEvent
+	err := sqlx.SelectContext(ctx, executorFromContext(ctx, s.db), &events, `
+		SELECT version, event_name, payload
+		FROM event_store
+		WHERE aggregate_type = $1 AND aggregate_id = $2 AND version > $3
+		ORDER BY version
+	`, aggregateType, aggregateID, afterVersion)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s %s: %w", aggregateType, aggregateID, err)
+	}
+
+	return events, nil
+}
+
+// Snapshot returns the latest snapshot of the aggregate, or the zero value if it has none.
+func (s EventStore) Snapshot(ctx context.Context, aggregateType string, aggregateID string) (StoredSnapshot, error) {
+	var snapshot StoredSnapshot
+	err := sqlx.GetContext(ctx, executorFromContext(ctx, s.db), &snapshot, `
+		SELECT version, payload FROM aggregate_snapshots WHERE aggregate_type = $1 AND aggregate_id = $2
+	`, aggregateType, aggregateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StoredSnapshot{}, nil
+	}
+	if err != nil {
+		return StoredSnapshot{}, fmt.Errorf("could not get snapshot of %s %s: %w", aggregateType, aggregateID, err)
+	}
+
+	return snapshot, nil
+}
+
+// SaveSnapshot replaces the snapshot of the aggregate, unless a newer one was saved.
+func (s EventStore) SaveSnapshot(ctx context.Context, aggregateType string, aggregateID string, version int, state any) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not marshal snapshot of %s %s: %w", aggregateType, aggregateID, err)
+	}
+
+	_, err = executorFromContext(ctx, s.db).ExecContext(ctx, `
+		INSERT INTO aggregate_snapshots (aggregate_type, aggregate_id, version, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (aggregate_type, aggregate_id) DO UPDATE
+		SET version = EXCLUDED.version, payload = EXCLUDED.payload, created_at = NOW()
+		WHERE aggregate_snapshots.version < EXCLUDED.version
+	`, aggregateType, aggregateID, version, payload)
+	if err != nil {
+		return fmt.Errorf("could not save snapshot of %s %s: %w", aggregateType, aggregateID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// Inbox makes handler effects exactly-once. The message is recorded in processed_messages
+// in the same transaction as the handler's DB writes, so a redelivered message is skipped
+// only when the original effects were committed.
+type Inbox struct {
+	db *sqlx.DB
+}
+
+func NewInbox(db *DB) Inbox {
+	if db == nil {
+		panic("db is nil")
+	}
+
+	return Inbox{db: db.Conn}
+}
+
+// ProcessOnce runs fn unless the message was already processed by the handler.
+// Handlers are named <component>.<handler>, for example vip_bundle_process_manager.OnBookingMade.
+func (i Inbox) ProcessOnce(
+	ctx context.Context,
+	handlerName string,
+	messageID string,
+	fn func(ctx context.Context) error,
+) error {
+	if handlerName == "" {
+		return fmt.Errorf("handler name is required")
+	}
+	if messageID == "" {
+		return fmt.Errorf("message id is required")
+	}
+
+	return updateInTx(
+		ctx,
+		i.db,
+		sql.LevelSerializable,
+		func(ctx context.Context, tx *sqlx.Tx) error {
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO 
+				    processed_messages (handler_name, message_id)
+				VALUES 
+				    ($1, $2)
+				ON CONFLICT DO NOTHING
+			`, handlerName, messageID)
+			if err != nil {
+				return fmt.Errorf("could not store processed message: %w", err)
+			}
+
+			inserted, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("could not check processed message: %w", err)
+			}
+			if inserted == 0 {
+				log.FromContext(ctx).WithFields(logrus.Fields{
+					"handler_name": handlerName,
+					"message_id":   messageID,
+				}).Info("Message already processed, skipping")
+				return nil
+			}
+
+			return fn(ctx)
+		},
+	)
+}
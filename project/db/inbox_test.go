@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboxProcessOnce(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	inbox := NewInbox(&db)
+	ctx := context.Background()
+
+	messageID := uuid.NewString()
+	calls := 0
+	handle := func(ctx context.Context) error {
+		calls++
+		return nil
+	}
+
+	err := inbox.ProcessOnce(ctx, "TestHandler", messageID, handle)
+	assert.NoError(t, err)
+
+	err = inbox.ProcessOnce(ctx, "TestHandler", messageID, handle)
+	assert.NoError(t, err)
+
+	err = inbox.ProcessOnce(ctx, "OtherTestHandler", messageID, handle)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestInboxProcessOnce_rollback(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	inbox := NewInbox(&db)
+	ctx := context.Background()
+
+	messageID := uuid.NewString()
+
+	err := inbox.ProcessOnce(ctx, "TestHandler", messageID, func(ctx context.Context) error {
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+
+	called := false
+	err = inbox.ProcessOnce(ctx, "TestHandler", messageID, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, called, "failed message should be processed again")
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// ParkingLot stores events which arrived before their parent aggregate exists.
+// They are replayed by the consumer, in the order they were parked, once the parent is created.
+type ParkingLot struct {
+	consumer string
+}
+
+func NewParkingLot(consumer string) ParkingLot {
+	if consumer == "" {
+		panic("consumer is empty")
+	}
+
+	return ParkingLot{consumer: consumer}
+}
+
+type ParkedEvent struct {
+	ID        int64     `db:"id"`
+	ParentID  string    `db:"parent_id"`
+	EventName string    `db:"event_name"`
+	Payload   []byte    `db:"payload"`
+	ParkedAt  time.Time `db:"parked_at"`
+}
+
+func (p ParkingLot) Park(ctx context.Context, tx *sqlx.Tx, parentID string, event any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not marshal parked event: %w", err)
+	}
+
+	eventName := cqrs.StructName(event)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO 
+			parked_events (consumer, parent_id, event_name, payload)
+		VALUES 
+			($1, $2, $3, $4)
+	`, p.consumer, parentID, eventName, payload)
+	if err != nil {
+		return fmt.Errorf("could not park event: %w", err)
+	}
+
+	log.FromContext(ctx).WithFields(logrus.Fields{
+		"consumer":   p.consumer,
+		"parent_id":  parentID,
+		"event_name": eventName,
+	}).Info("Parent doesn't exist yet, event parked")
+
+	return nil
+}
+
+// Unpark removes and returns the events parked for the parent, in the order they were parked.
+func (p ParkingLot) Unpark(ctx context.Context, tx *sqlx.Tx, parentID string) ([]ParkedEvent, error) {
+	var events []ParkedEvent
+
+	err := tx.SelectContext(ctx, &events, `
+		DELETE FROM 
+			parked_events 
+		WHERE 
+			consumer = $1 AND parent_id = $2
+		RETURNING 
+			id, parent_id, event_name, payload, parked_at
+	`, p.consumer, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("could not unpark events: %w", err)
+	}
+
+	// DELETE ... RETURNING doesn't guarantee the order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, nil
+}
+
+func replayParkedEvent[T any](ctx context.Context, e ParkedEvent, handler func(context.Context, *T) error) error {
+	event := new(T)
+	if err := json.Unmarshal(e.Payload, event); err != nil {
+		return fmt.Errorf("could not unmarshal parked event: %w", err)
+	}
+
+	return handler(ctx, event)
+}
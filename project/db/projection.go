@@ -0,0 +1,697 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"tickets/entities"
+	"tickets/message/outbox"
+	"tickets/message/upcast"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/samber/lo"
+)
+
+// ErrUnknownEvent is returned when a data lake event is not handled by the projection.
+var ErrUnknownEvent = errors.New("event is not handled by the projection")
+
+// Projection builds a read model from events. Events are declared with HandleCreate and Handle,
+// together with pure functions applying them to the state S.
+//
+// The framework stores each state as JSONB in the projection_<name> table, applies each event once
+// (by its header ID), serializes events of one key and parks events arriving before their state exists.
+type Projection[S any] struct {
+	name             string
+	shadow           bool
+	db               *sqlx.DB
+	outboxPartitions outbox.Partitions
+	newEventBus      func(message.Publisher) *cqrs.EventBus
+	inbox            Inbox
+	parkingLot       ParkingLot
+
+	handlers     []projectionHandler[S]
+	handlerNames func(eventName string) string
+	lookupKeys   func(state S) []string
+	fields       map[string]func(state S) []string
+	sortKey      func(state S) string
+	onUpdated    func(id string, state S) any
+}
+
+type projectionHandler[S any] struct {
+	eventName    string
+	newEvent     func() any
+	key          func(event any) string
+	create       func(event any) (S, error)
+	apply        func(state S, event any) (S, error)
+	eventHandler func(name string, handle func(ctx context.Context, event any) error) cqrs.EventHandler
+
+	// owned tells if a state the event belongs to exists or is going to, when the state isn't found.
+	// Events without an owner are skipped instead of parked.
+	owned func(ctx context.Context, tx *sqlx.Tx, event any) (bool, error)
+}
+
+func NewProjection[S any](
+	db *DB,
+	name string,
+	outboxPartitions outbox.Partitions,
+	newEventBus func(message.Publisher) *cqrs.EventBus,
+) *Projection[S] {
+	if db == nil {
+		panic("db is nil")
+	}
+	if name == "" {
+		panic("projection name is empty")
+	}
+	if newEventBus == nil {
+		panic("newEventBus is nil")
+	}
+
+	return &Projection[S]{
+		name:             name,
+		db:               db.Conn,
+		outboxPartitions: outboxPartitions,
+		newEventBus:      newEventBus,
+		inbox:            NewInbox(db),
+		parkingLot:       NewParkingLot("projection." + name),
+		handlerNames: func(eventName string) string {
+			return name + "." + eventName
+		},
+		fields: map[string]func(state S) []string{},
+	}
+}
+
+// HandlerNames overrides the names of the event handlers, by default <projection>.<event name>.
+// The names are consumer groups of the handlers, so a renamed handler would read its events from scratch.
+func (p *Projection[S]) HandlerNames(fn func(eventName string) string) {
+	p.handlerNames = fn
+}
+
+// LookupKeys sets the secondary keys under which events can find the state,
+// for example IDs of tickets in a booking.
+func (p *Projection[S]) LookupKeys(fn func(state S) []string) {
+	p.lookupKeys = fn
+}
+
+// Field declares a field by which states can be found with Find, with the values the state has for it.
+// Values are stored next to the state, so they can be indexed.
+func (p *Projection[S]) Field(name string, values func(state S) []string) {
+	p.fields[name] = values
+}
+
+// SortKey sets the value by which Find orders states, compared as text.
+func (p *Projection[S]) SortKey(fn func(state S) string) {
+	p.sortKey = fn
+}
+
+// OnUpdated sets the event published through the outbox every time the state changes.
+func (p *Projection[S]) OnUpdated(fn func(id string, state S) any) {
+	p.onUpdated = fn
+}
+
+// HandleCreate registers the event creating the state with the ID.
+// A redelivered event doesn't override the existing state.
+func HandleCreate[S, E any](p *Projection[S], id func(event *E) string, create func(event *E) (S, error)) {
+	registerProjectionHandler(p, projectionHandler[S]{
+		key: func(event any) string {
+			return id(event.(*E))
+		},
+		create: func(event any) (S, error) {
+			return create(event.(*E))
+		},
+	}, new(E))
+}
+
+// Handle registers the event applied to the existing state, found by its ID or one of its lookup keys.
+func Handle[S, E any](p *Projection[S], key func(event *E) string, apply func(state S, event *E) (S, error)) {
+	registerProjectionHandler(p, projectionHandler[S]{
+		key: func(event any) string {
+			return key(event.(*E))
+		},
+		apply: func(state S, event any) (S, error) {
+			return apply(state, event.(*E))
+		},
+	}, new(E))
+}
+
+// HandleOwned registers the event applied to the existing state, like Handle, for events shared with
+// other aggregates, for example BookingMade_v1 of bookings outside VIP bundles. When there is no state
+// for the event yet, owned checks in the transaction if the event belongs to the projection: such events
+// are parked until the state is created, others are skipped instead of staying parked forever.
+func HandleOwned[S, E any](
+	p *Projection[S],
+	key func(event *E) string,
+	owned func(ctx context.Context, tx *sqlx.Tx, event *E) (bool, error),
+	apply func(state S, event *E) (S, error),
+) {
+	registerProjectionHandler(p, projectionHandler[S]{
+		key: func(event any) string {
+			return key(event.(*E))
+		},
+		apply: func(state S, event any) (S, error) {
+			return apply(state, event.(*E))
+		},
+		owned: func(ctx context.Context, tx *sqlx.Tx, event any) (bool, error) {
+			return owned(ctx, tx, event.(*E))
+		},
+	}, new(E))
+}
+
+func registerProjectionHandler[S, E any](p *Projection[S], h projectionHandler[S], _ *E) {
+	h.eventName = cqrs.StructName(new(E))
+	h.newEvent = func() any { return new(E) }
+	h.eventHandler = func(name string, handle func(ctx context.Context, event any) error) cqrs.EventHandler {
+		return cqrs.NewEventHandler(name, func(ctx context.Context, event *E) error {
+			return handle(ctx, event)
+		})
+	}
+
+	if _, ok := p.handler(h.eventName); ok {
+		panic(fmt.Sprintf("projection %s already handles %s", p.name, h.eventName))
+	}
+
+	p.handlers = append(p.handlers, h)
+}
+
+func (p *Projection[S]) handler(eventName string) (projectionHandler[S], bool) {
+	for _, h := range p.handlers {
+		if h.eventName == eventName {
+			return h, true
+		}
+	}
+
+	return projectionHandler[S]{}, false
+}
+
+func (p *Projection[S]) Name() string {
+	return p.name
+}
+
+func (p *Projection[S]) table() string {
+	return projectionTable(p.name)
+}
+
+// consumer names the projection in the inbox and the parking lot.
+func (p *Projection[S]) consumer() string {
+	return "projection." + p.name
+}
+
+// EventHandlers returns handlers to be registered in the event processor.
+func (p *Projection[S]) EventHandlers() []cqrs.EventHandler {
+	handlers := make([]cqrs.EventHandler, 0, len(p.handlers))
+	for _, h := range p.handlers {
+		handlers = append(handlers, h.eventHandler(p.handlerNames(h.eventName), p.HandleEvent))
+	}
+
+	return handlers
+}
+
+// HandleEvent applies the event (a pointer to one of the registered events) exactly once.
+func (p *Projection[S]) HandleEvent(ctx context.Context, event any) error {
+	eventName := cqrs.StructName(event)
+
+	h, ok := p.handler(eventName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, eventName)
+	}
+
+	eventID, err := eventHeaderID(event)
+	if err != nil {
+		return err
+	}
+
+	return updateInTx(
+		ctx,
+		p.db,
+		sql.LevelReadCommitted,
+		func(ctx context.Context, tx *sqlx.Tx) error {
+			if p.shadow {
+				return p.applyOnce(ctx, tx, h, event, eventID)
+			}
+
+			// checked before applying, so the event is never applied only to a table that is being replaced
+			rebuilding, err := p.rebuildRunning(ctx, tx)
+			if err != nil {
+				return err
+			}
+
+			if err := p.applyOnce(ctx, tx, h, event, eventID); err != nil {
+				return err
+			}
+
+			if rebuilding {
+				// the rebuilt copy gets live events as well, even if they didn't reach the data lake yet
+				return p.shadowCopy().applyOnce(ctx, tx, h, event, eventID)
+			}
+
+			return nil
+		},
+	)
+}
+
+func (p *Projection[S]) applyOnce(ctx context.Context, tx *sqlx.Tx, h projectionHandler[S], event any, eventID string) error {
+	return p.inbox.ProcessOnce(ctx, p.consumer(), eventID, func(ctx context.Context) error {
+		if err := p.apply(ctx, tx, h, event); err != nil {
+			return err
+		}
+
+		return p.checkpoint(ctx, tx, eventID)
+	})
+}
+
+// HandleDataLakeEvent upcasts the stored event and applies it.
+// It returns ErrUnknownEvent for events not handled by the projection.
+func (p *Projection[S]) HandleDataLakeEvent(ctx context.Context, dataLakeEvent entities.Event) error {
+	eventName, payload, err := upcast.Default.Upcast(dataLakeEvent.EventName, dataLakeEvent.EventPayload)
+	if err != nil {
+		return err
+	}
+
+	h, ok := p.handler(eventName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, dataLakeEvent.EventName)
+	}
+
+	e := h.newEvent()
+	if err := json.Unmarshal(payload, e); err != nil {
+		return fmt.Errorf("could not unmarshal event %s: %w", eventName, err)
+	}
+
+	return p.HandleEvent(ctx, e)
+}
+
+// apply applies the event to the state of its key.
+//
+// Locks are always taken in one order, so transactions of the projection can't deadlock:
+// first the key of the event, then the keys new to the state (its ID when it's created and its new lookup keys),
+// sorted, to replay the events parked under them. A transaction holding a key which is new to a state
+// didn't find the state, so it only parks its event and never waits for a lock of the state.
+func (p *Projection[S]) apply(ctx context.Context, tx *sqlx.Tx, h projectionHandler[S], event any) error {
+	key := h.key(event)
+	if key == "" {
+		return fmt.Errorf("%s has no key for projection %s", h.eventName, p.name)
+	}
+
+	if err := lockKey(ctx, tx, p.name+":"+key); err != nil {
+		return err
+	}
+
+	id, state, version, err := p.find(ctx, tx, key)
+	found := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var oldLookupKeys []string
+	if found {
+		oldLookupKeys = p.stateLookupKeys(state)
+	}
+
+	switch {
+	case h.create != nil && found:
+		// redelivered or duplicated create event
+		return nil
+	case h.create != nil:
+		id = key
+		state, err = h.create(event)
+		if err != nil {
+			return err
+		}
+		if err := p.insert(ctx, tx, id, state); err != nil {
+			return err
+		}
+	case !found:
+		if h.owned != nil {
+			owned, err := h.owned(ctx, tx, event)
+			if err != nil {
+				return err
+			}
+			if !owned {
+				return nil
+			}
+		}
+		// events arrived out of order - the event is applied once the state is created
+		return p.parkingLot.Park(ctx, tx, key, event)
+	default:
+		state, err = h.apply(state, event)
+		if err != nil {
+			return err
+		}
+		if err := p.update(ctx, tx, id, state, version); err != nil {
+			return err
+		}
+	}
+
+	if err := p.publishUpdated(ctx, tx, id, state); err != nil {
+		return err
+	}
+
+	// the state (or its new lookup keys) exists now, so events parked until then can be applied
+	var newKeys []string
+	if !found {
+		newKeys = append(newKeys, id)
+	}
+	for _, k := range p.stateLookupKeys(state) {
+		if !lo.Contains(oldLookupKeys, k) {
+			newKeys = append(newKeys, k)
+		}
+	}
+
+	sort.Strings(newKeys)
+	for _, k := range newKeys {
+		if err := p.replayParked(ctx, tx, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Projection[S]) replayParked(ctx context.Context, tx *sqlx.Tx, key string) error {
+	if err := lockKey(ctx, tx, p.name+":"+key); err != nil {
+		return err
+	}
+
+	events, err := p.parkingLot.Unpark(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	for _, parked := range events {
+		h, ok := p.handler(parked.EventName)
+		if !ok {
+			return fmt.Errorf("parked event %d: %w: %s", parked.ID, ErrUnknownEvent, parked.EventName)
+		}
+
+		e := h.newEvent()
+		if err := json.Unmarshal(parked.Payload, e); err != nil {
+			return fmt.Errorf("could not unmarshal parked event %d: %w", parked.ID, err)
+		}
+
+		if err := p.apply(ctx, tx, h, e); err != nil {
+			return fmt.Errorf("could not replay parked event %d (%s): %w", parked.ID, parked.EventName, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Projection[S]) find(ctx context.Context, tx *sqlx.Tx, key string) (string, S, int, error) {
+	var id string
+	var payload []byte
+	var version int
+	var state S
+
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, payload, version FROM `+p.table()+`
+		WHERE id = $1 OR lookup_keys @> ARRAY[$1]::TEXT[]
+		LIMIT 1
+	`, key).Scan(&id, &payload, &version)
+	if err != nil {
+		return "", state, 0, err
+	}
+
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return "", state, 0, fmt.Errorf("could not unmarshal %s state: %w", p.name, err)
+	}
+
+	return id, state, version, nil
+}
+
+func (p *Projection[S]) insert(ctx context.Context, tx *sqlx.Tx, id string, state S) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s state: %w", p.name, err)
+	}
+
+	fields, err := p.stateFields(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+p.table()+` (id, lookup_keys, fields, sort_key, payload)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, lookupKeysArray(p.stateLookupKeys(state)), fields, p.stateSortKey(state), payload)
+	if err != nil {
+		return fmt.Errorf("could not insert %s state: %w", p.name, err)
+	}
+
+	return nil
+}
+
+func (p *Projection[S]) update(ctx context.Context, tx *sqlx.Tx, id string, state S, expectedVersion int) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s state: %w", p.name, err)
+	}
+
+	fields, err := p.stateFields(state)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE `+p.table()+`
+		SET lookup_keys = $1, fields = $2, sort_key = $3, payload = $4, version = version + 1, updated_at = NOW()
+		WHERE id = $5 AND version = $6
+	`, lookupKeysArray(p.stateLookupKeys(state)), fields, p.stateSortKey(state), payload, id, expectedVersion)
+	if err != nil {
+		return fmt.Errorf("could not update %s state: %w", p.name, err)
+	}
+
+	return compareAndSwap(res, p.name, id, expectedVersion)
+}
+
+func (p *Projection[S]) publishUpdated(ctx context.Context, tx *sqlx.Tx, id string, state S) error {
+	if p.onUpdated == nil {
+		return nil
+	}
+
+	outboxPublisher, err := outbox.NewPublisherForDb(ctx, tx, p.outboxPartitions, id)
+	if err != nil {
+		return fmt.Errorf("could not create outbox publisher: %w", err)
+	}
+
+	return p.newEventBus(outboxPublisher).Publish(ctx, p.onUpdated(id, state))
+}
+
+func (p *Projection[S]) checkpoint(ctx context.Context, tx *sqlx.Tx, eventID string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO projection_checkpoints (projection, last_event_id, events_applied, updated_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (projection) DO UPDATE SET
+			last_event_id = excluded.last_event_id,
+			events_applied = projection_checkpoints.events_applied + 1,
+			updated_at = excluded.updated_at
+	`, p.name, eventID)
+	if err != nil {
+		return fmt.Errorf("could not store %s checkpoint: %w", p.name, err)
+	}
+
+	return nil
+}
+
+func (p *Projection[S]) stateLookupKeys(state S) []string {
+	if p.lookupKeys == nil {
+		return nil
+	}
+
+	return p.lookupKeys(state)
+}
+
+func (p *Projection[S]) stateFields(state S) ([]byte, error) {
+	fields := make(map[string][]string, len(p.fields))
+	for name, values := range p.fields {
+		// nil would be stored as null, which doesn't contain any value
+		fields[name] = lo.Uniq(append([]string{}, values(state)...))
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal %s fields: %w", p.name, err)
+	}
+
+	return b, nil
+}
+
+func (p *Projection[S]) stateSortKey(state S) string {
+	if p.sortKey == nil {
+		return ""
+	}
+	return p.sortKey(state)
+}
+
+func (p *Projection[S]) Get(ctx context.Context, id string) (S, error) {
+	var state S
+	var payload []byte
+
+	err := p.db.QueryRowContext(ctx, "SELECT payload FROM "+p.table()+" WHERE id = $1", id).Scan(&payload)
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return state, fmt.Errorf("could not unmarshal %s state: %w", p.name, err)
+	}
+
+	return state, nil
+}
+
+// ProjectionQuery selects a page of states of a projection, ordered by their sort key and ID.
+type ProjectionQuery struct {
+	// Filter matches states having the value for each of the fields declared with Field.
+	Filter map[string]string
+
+	Descending bool
+	// After is the cursor returned with the previous page.
+	After string
+	// Limit is the size of the page, all states are returned when it's 0.
+	Limit int
+}
+
+// Find returns states matching the query, with the cursor of the next page, empty after the last page.
+func (p *Projection[S]) Find(ctx context.Context, query ProjectionQuery) ([]S, string, error) {
+	filter := make(map[string][]string, len(query.Filter))
+	for name, value := range query.Filter {
+		if _, ok := p.fields[name]; !ok {
+			return nil, "", fmt.Errorf("projection %s has no field %s", p.name, name)
+		}
+		filter[name] = []string{value}
+	}
+
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not marshal %s filter: %w", p.name, err)
+	}
+
+	order, compare := "ASC", ">"
+	if query.Descending {
+		order, compare = "DESC", "<"
+	}
+
+	sqlQuery := "SELECT id, sort_key, payload FROM " + p.table() + " WHERE fields @> $1::JSONB"
+	args := []any{filterJSON}
+
+	if query.After != "" {
+		after, err := decodeProjectionCursor(query.After)
+		if err != nil {
+			return nil, "", err
+		}
+		sqlQuery += " AND (sort_key, id) " + compare + " ($2, $3)"
+		args = append(args, after.SortKey, after.ID)
+	}
+
+	sqlQuery += " ORDER BY sort_key " + order + ", id " + order
+	if query.Limit > 0 {
+		// one more row tells if there is a next page
+		sqlQuery += fmt.Sprintf(" LIMIT %d", query.Limit+1)
+	}
+
+	var rows []struct {
+		ID      string `db:"id"`
+		SortKey string `db:"sort_key"`
+		Payload []byte `db:"payload"`
+	}
+	if err := p.db.SelectContext(ctx, &rows, sqlQuery, args...); err != nil {
+		return nil, "", fmt.Errorf("could not query %s: %w", p.name, err)
+	}
+
+	var next string
+	if query.Limit > 0 && len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+		last := rows[len(rows)-1]
+		next = encodeProjectionCursor(projectionCursor{SortKey: last.SortKey, ID: last.ID})
+	}
+
+	states := make([]S, 0, len(rows))
+	for _, row := range rows {
+		var state S
+		if err := json.Unmarshal(row.Payload, &state); err != nil {
+			return nil, "", fmt.Errorf("could not unmarshal %s state: %w", p.name, err)
+		}
+		states = append(states, state)
+	}
+
+	return states, next, nil
+}
+
+// projectionCursor points at the last state of a page.
+type projectionCursor struct {
+	SortKey string `json:"sort_key"`
+	ID      string `json:"id"`
+}
+
+func encodeProjectionCursor(cursor projectionCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeProjectionCursor(cursor string) (projectionCursor, error) {
+	invalid := fmt.Errorf("%w: malformed cursor", entities.ErrInvalidProjectionQuery)
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return projectionCursor{}, invalid
+	}
+
+	var c projectionCursor
+	if err := json.Unmarshal(decoded, &c); err != nil || c.ID == "" {
+		return projectionCursor{}, invalid
+	}
+
+	return c, nil
+}
+
+func projectionTable(name string) string {
+	return "projection_" + name
+}
+
+// projectionSchema creates the storage of the projection.
+func projectionSchema(name string) string {
+	return projectionTableSchema(projectionTable(name))
+}
+
+func projectionTableSchema(table string) string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+	id VARCHAR(255) PRIMARY KEY,
+	lookup_keys TEXT[] NOT NULL DEFAULT '{}',
+	payload JSONB NOT NULL,
+	version INT NOT NULL DEFAULT 1,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- fields were added later, rows stored before are backfilled by migrations
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS fields JSONB;
+ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS sort_key TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS ` + table + `_lookup_keys_idx ON ` + table + ` USING GIN (lookup_keys);
+CREATE INDEX IF NOT EXISTS ` + table + `_fields_idx ON ` + table + ` USING GIN (fields jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS ` + table + `_sort_key_idx ON ` + table + ` (sort_key, id);
+`
+}
+
+// headerEvent is an event identified by the ID in its header.
+type headerEvent interface {
+	GetHeader() entities.EventHeader
+}
+
+func eventHeaderID(event any) (string, error) {
+	if e, ok := event.(headerEvent); ok && e.GetHeader().ID != "" {
+		return e.GetHeader().ID, nil
+	}
+
+	return "", fmt.Errorf("%s has no header ID", cqrs.StructName(event))
+}
+
+func lookupKeysArray(keys []string) pq.StringArray {
+	// nil would be stored as NULL
+	return append(pq.StringArray{}, keys...)
+}
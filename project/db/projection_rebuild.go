@@ -0,0 +1,533 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUnknownProjection = errors.New("unknown projection")
+	ErrRebuildInProgress = entities.ErrProjectionRebuildInProgress
+)
+
+const rebuildBatchSize = 500
+
+// DataLakeReader reads the data lake in the order events were published.
+type DataLakeReader interface {
+	GetAfter(ctx context.Context, after DataLakePosition, limit int) ([]entities.Event, error)
+	Count(ctx context.Context) (int, error)
+}
+
+// Rebuild builds the projection from the data lake in a shadow table, while the live table keeps serving reads.
+// Events handled live during the rebuild are applied to both tables. Once the data lake is caught up,
+// the shadow table atomically replaces the live one.
+//
+// A rebuild interrupted by a crash or an error resumes from its last stored position.
+// Events not handled by the projection are skipped and counted in the status.
+func (p *Projection[S]) Rebuild(
+	ctx context.Context,
+	dataLake DataLakeReader,
+	progress func(entities.ProjectionRebuildStatus),
+) error {
+	if progress == nil {
+		progress = func(entities.ProjectionRebuildStatus) {}
+	}
+
+	logger := log.FromContext(ctx).WithField("projection", p.name)
+
+	unlock, err := p.lockRebuild(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	status, position, err := p.rebuildStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.Status == entities.ProjectionRebuildRunning {
+		logger.WithField("events_processed", status.EventsProcessed).Info("Resuming projection rebuild")
+	} else {
+		status, position, err = p.startRebuild(ctx, dataLake)
+		if err != nil {
+			return err
+		}
+		logger.WithField("events_total", status.EventsTotal).Info("Rebuilding projection")
+	}
+	status.LastError = ""
+	progress(status)
+
+	err = p.catchUp(ctx, dataLake, &status, &position, progress)
+	if err == nil {
+		err = p.swap(ctx, dataLake, &status, &position, progress)
+	}
+	if err != nil {
+		// the rebuild stays running, so it's resumed by the next Rebuild call
+		if saveErr := p.saveRebuildError(context.WithoutCancel(ctx), err); saveErr != nil {
+			err = errors.Join(err, saveErr)
+		}
+		return fmt.Errorf("could not rebuild projection %s: %w", p.name, err)
+	}
+
+	progress(status)
+
+	logger.WithFields(logrus.Fields{
+		"events_processed": status.EventsProcessed,
+		"events_skipped":   status.EventsSkipped,
+		"unknown_events":   status.UnknownEvents,
+	}).Info("Projection rebuilt")
+
+	return nil
+}
+
+// RebuildStatus returns the progress of the last rebuild.
+func (p *Projection[S]) RebuildStatus(ctx context.Context) (entities.ProjectionRebuildStatus, error) {
+	status, _, err := p.rebuildStatus(ctx)
+	return status, err
+}
+
+// lockRebuild makes sure only one process rebuilds the projection.
+// The session lock is released also when the process dies, so the rebuild can be resumed.
+func (p *Projection[S]) lockRebuild(ctx context.Context) (func(), error) {
+	conn, err := p.db.Connx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get connection: %w", err)
+	}
+
+	lockKey := "rebuild:" + p.name
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtextextended($1, 0))", lockKey).Scan(&locked)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", lockKey, err)
+	}
+	if !locked {
+		_ = conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRebuildInProgress, p.name)
+	}
+
+	return func() {
+		// the connection goes back to the pool, so the session lock must be released explicitly
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtextextended($1, 0))", lockKey)
+		_ = conn.Close()
+	}, nil
+}
+
+// shadowCopy is the projection stored in the shadow table, with its own inbox, parking lot and checkpoint.
+func (p *Projection[S]) shadowCopy() *Projection[S] {
+	shadow := *p
+	shadow.name = p.name + "_shadow"
+	shadow.shadow = true
+	shadow.parkingLot = NewParkingLot(shadow.consumer())
+	// the rebuilt states didn't change from the readers' point of view
+	shadow.onUpdated = nil
+
+	return &shadow
+}
+
+// rebuildRunning locks the rebuild status until the transaction ends, so the swap waits for events
+// being applied to both tables, and events handled after the swap see it finished.
+func (p *Projection[S]) rebuildRunning(ctx context.Context, tx *sqlx.Tx) (bool, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, "SELECT status FROM projection_rebuilds WHERE projection = $1 FOR SHARE", p.name).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check %s rebuild status: %w", p.name, err)
+	}
+
+	return status == entities.ProjectionRebuildRunning, nil
+}
+
+// startRebuild creates an empty shadow table and marks the rebuild as running in one transaction,
+// so live events are applied to the shadow table only once it exists.
+func (p *Projection[S]) startRebuild(ctx context.Context, dataLake DataLakeReader) (entities.ProjectionRebuildStatus, DataLakePosition, error) {
+	total, err := dataLake.Count(ctx)
+	if err != nil {
+		return entities.ProjectionRebuildStatus{}, DataLakePosition{}, err
+	}
+
+	shadow := p.shadowCopy()
+	status := entities.ProjectionRebuildStatus{
+		Projection:    p.name,
+		Status:        entities.ProjectionRebuildRunning,
+		EventsTotal:   total,
+		UnknownEvents: map[string]int{},
+	}
+
+	err = updateInTx(
+		ctx,
+		p.db,
+		sql.LevelReadCommitted,
+		func(ctx context.Context, tx *sqlx.Tx) error {
+			queries := []struct {
+				query string
+				args  []any
+			}{
+				{"DROP TABLE IF EXISTS " + shadow.table(), nil},
+				{projectionTableSchema(shadow.table()), nil},
+				{"DELETE FROM processed_messages WHERE handler_name = $1", []any{shadow.consumer()}},
+				{"DELETE FROM parked_events WHERE consumer = $1", []any{shadow.consumer()}},
+				{"DELETE FROM projection_checkpoints WHERE projection = $1", []any{shadow.name}},
+				{`
+					INSERT INTO projection_rebuilds (projection, status, position_published_at, position_event_id, events_total)
+					VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT (projection) DO UPDATE SET
+						status = excluded.status,
+						position_published_at = excluded.position_published_at,
+						position_event_id = excluded.position_event_id,
+						events_total = excluded.events_total,
+						events_processed = 0,
+						events_skipped = 0,
+						unknown_events = '{}',
+						last_error = '',
+						started_at = NOW(),
+						updated_at = NOW(),
+						finished_at = NULL
+				`, []any{p.name, status.Status, DataLakePosition{}.PublishedAt, uuid.Nil.String(), total}},
+			}
+
+			for _, q := range queries {
+				if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+					return fmt.Errorf("could not start %s rebuild: %w", p.name, err)
+				}
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		return entities.ProjectionRebuildStatus{}, DataLakePosition{}, err
+	}
+
+	return status, DataLakePosition{}, nil
+}
+
+// catchUp applies the data lake events following the position to the shadow table, storing the progress after each batch.
+func (p *Projection[S]) catchUp(
+	ctx context.Context,
+	dataLake DataLakeReader,
+	status *entities.ProjectionRebuildStatus,
+	position *DataLakePosition,
+	progress func(entities.ProjectionRebuildStatus),
+) error {
+	shadow := p.shadowCopy()
+
+	for {
+		events, err := dataLake.GetAfter(ctx, *position, rebuildBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			// events applied before a crash, but after the last stored position, are skipped by the inbox
+			err := shadow.HandleDataLakeEvent(ctx, event)
+			if errors.Is(err, ErrUnknownEvent) {
+				status.EventsSkipped++
+				status.UnknownEvents[event.EventName]++
+			} else if err != nil {
+				return fmt.Errorf("could not apply event %s (%s): %w", event.EventID, event.EventName, err)
+			}
+
+			status.EventsProcessed++
+			*position = DataLakePosition{PublishedAt: event.PublishedAt, EventID: event.EventID}
+		}
+
+		if err := p.saveRebuildStatus(ctx, *status, *position); err != nil {
+			return err
+		}
+		progress(*status)
+	}
+}
+
+// swap replaces the live table with the shadow table, together with the state tracking applied and parked events.
+func (p *Projection[S]) swap(
+	ctx context.Context,
+	dataLake DataLakeReader,
+	status *entities.ProjectionRebuildStatus,
+	position *DataLakePosition,
+	progress func(entities.ProjectionRebuildStatus),
+) error {
+	shadow := p.shadowCopy()
+
+	return updateInTx(
+		ctx,
+		p.db,
+		sql.LevelReadCommitted,
+		func(ctx context.Context, tx *sqlx.Tx) error {
+			// waits for events being applied to both tables and blocks new ones until the swap is committed
+			_, err := tx.ExecContext(ctx, "SELECT 1 FROM projection_rebuilds WHERE projection = $1 FOR UPDATE", p.name)
+			if err != nil {
+				return fmt.Errorf("could not lock %s rebuild: %w", p.name, err)
+			}
+
+			// events stored in the data lake since the last batch
+			if err := p.catchUp(ctx, dataLake, status, position, progress); err != nil {
+				return err
+			}
+
+			queries := []struct {
+				query string
+				args  []any
+			}{
+				{"DROP TABLE " + p.table(), nil},
+				{"ALTER TABLE " + shadow.table() + " RENAME TO " + p.table(), nil},
+				{"ALTER TABLE " + p.table() + " RENAME CONSTRAINT " + shadow.table() + "_pkey TO " + p.table() + "_pkey", nil},
+				{"ALTER INDEX " + shadow.table() + "_lookup_keys_idx RENAME TO " + p.table() + "_lookup_keys_idx", nil},
+				{"ALTER INDEX " + shadow.table() + "_fields_idx RENAME TO " + p.table() + "_fields_idx", nil},
+				{"ALTER INDEX " + shadow.table() + "_sort_key_idx RENAME TO " + p.table() + "_sort_key_idx", nil},
+				{"DELETE FROM processed_messages WHERE handler_name = $1", []any{p.consumer()}},
+				{"UPDATE processed_messages SET handler_name = $1 WHERE handler_name = $2", []any{p.consumer(), shadow.consumer()}},
+				{"DELETE FROM parked_events WHERE consumer = $1", []any{p.consumer()}},
+				{"UPDATE parked_events SET consumer = $1 WHERE consumer = $2", []any{p.consumer(), shadow.consumer()}},
+				{"DELETE FROM projection_checkpoints WHERE projection = $1", []any{p.name}},
+				{"UPDATE projection_checkpoints SET projection = $1 WHERE projection = $2", []any{p.name, shadow.name}},
+			}
+
+			for _, q := range queries {
+				if _, err := tx.ExecContext(ctx, q.query, q.args...); err != nil {
+					return fmt.Errorf("could not swap %s tables: %w", p.name, err)
+				}
+			}
+
+			status.Status = entities.ProjectionRebuildFinished
+
+			return p.saveRebuildStatus(ctx, *status, *position)
+		},
+	)
+}
+
+// saveRebuildStatus stores the progress, within the swap transaction if there is one.
+func (p *Projection[S]) saveRebuildStatus(ctx context.Context, status entities.ProjectionRebuildStatus, position DataLakePosition) error {
+	unknownEvents, err := json.Marshal(status.UnknownEvents)
+	if err != nil {
+		return fmt.Errorf("could not marshal unknown events: %w", err)
+	}
+
+	positionEventID := position.EventID
+	if positionEventID == "" {
+		positionEventID = uuid.Nil.String()
+	}
+
+	_, err = executorFromContext(ctx, p.db).ExecContext(ctx, `
+		UPDATE projection_rebuilds SET
+			status = $2,
+			position_published_at = $3,
+			position_event_id = $4,
+			events_processed = $5,
+			events_skipped = $6,
+			unknown_events = $7,
+			last_error = $8,
+			updated_at = NOW(),
+			finished_at = CASE WHEN $2 = 'finished' THEN NOW() END
+		WHERE projection = $1
+	`,
+		p.name,
+		status.Status,
+		position.PublishedAt,
+		positionEventID,
+		status.EventsProcessed,
+		status.EventsSkipped,
+		unknownEvents,
+		status.LastError,
+	)
+	if err != nil {
+		return fmt.Errorf("could not save %s rebuild status: %w", p.name, err)
+	}
+
+	return nil
+}
+
+// saveRebuildError reports the error, keeping the progress stored by the last committed batch.
+func (p *Projection[S]) saveRebuildError(ctx context.Context, rebuildErr error) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE projection_rebuilds SET last_error = $2, updated_at = NOW()
+		WHERE projection = $1
+	`, p.name, rebuildErr.Error())
+	if err != nil {
+		return fmt.Errorf("could not save %s rebuild error: %w", p.name, err)
+	}
+
+	return nil
+}
+
+func (p *Projection[S]) rebuildStatus(ctx context.Context) (entities.ProjectionRebuildStatus, DataLakePosition, error) {
+	status := entities.ProjectionRebuildStatus{
+		Projection:    p.name,
+		UnknownEvents: map[string]int{},
+	}
+
+	var position DataLakePosition
+	var unknownEvents []byte
+
+	err := p.db.QueryRowContext(ctx, `
+		SELECT status, position_published_at, position_event_id, events_total, events_processed, events_skipped,
+			unknown_events, last_error, started_at, updated_at, finished_at
+		FROM projection_rebuilds
+		WHERE projection = $1
+	`, p.name).Scan(
+		&status.Status,
+		&position.PublishedAt,
+		&position.EventID,
+		&status.EventsTotal,
+		&status.EventsProcessed,
+		&status.EventsSkipped,
+		&unknownEvents,
+		&status.LastError,
+		&status.StartedAt,
+		&status.UpdatedAt,
+		&status.FinishedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		status.Status = entities.ProjectionRebuildIdle
+		return status, DataLakePosition{}, nil
+	}
+	if err != nil {
+		return status, position, fmt.Errorf("could not get %s rebuild status: %w", p.name, err)
+	}
+
+	if err := json.Unmarshal(unknownEvents, &status.UnknownEvents); err != nil {
+		return status, position, fmt.Errorf("could not unmarshal unknown events: %w", err)
+	}
+
+	return status, position, nil
+}
+
+// ProjectionRebuilder rebuilds projections by their names.
+type ProjectionRebuilder struct {
+	dataLake    DataLakeReader
+	projections map[string]RebuildableProjection
+}
+
+type RebuildableProjection interface {
+	Name() string
+	Rebuild(ctx context.Context, dataLake DataLakeReader, progress func(entities.ProjectionRebuildStatus)) error
+	RebuildStatus(ctx context.Context) (entities.ProjectionRebuildStatus, error)
+}
+
+func NewProjectionRebuilder(dataLake DataLakeReader, projections ...RebuildableProjection) ProjectionRebuilder {
+	if dataLake == nil {
+		panic("dataLake is nil")
+	}
+
+	r := ProjectionRebuilder{
+		dataLake:    dataLake,
+		projections: map[string]RebuildableProjection{},
+	}
+	for _, p := range projections {
+		r.projections[p.Name()] = p
+	}
+
+	return r
+}
+
+// Projections returns the names of the projections which can be rebuilt.
+func (r ProjectionRebuilder) Projections() []string {
+	names := make([]string, 0, len(r.projections))
+	for name := range r.projections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (r ProjectionRebuilder) Rebuild(ctx context.Context, name string, progress func(entities.ProjectionRebuildStatus)) error {
+	p, ok := r.projections[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+	}
+
+	return p.Rebuild(ctx, r.dataLake, progress)
+}
+
+func (r ProjectionRebuilder) Status(ctx context.Context, name string) (entities.ProjectionRebuildStatus, error) {
+	p, ok := r.projections[name]
+	if !ok {
+		return entities.ProjectionRebuildStatus{}, fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+	}
+
+	return p.RebuildStatus(ctx)
+}
+
+// RebuildWorker runs rebuilds started with StartRebuild in the background, until its context is canceled.
+// Rebuilds interrupted by a shutdown stay running, so they are resumed when the worker runs again.
+type RebuildWorker struct {
+	ProjectionRebuilder
+
+	requests chan string
+}
+
+func NewRebuildWorker(rebuilder ProjectionRebuilder) *RebuildWorker {
+	return &RebuildWorker{
+		ProjectionRebuilder: rebuilder,
+		requests:            make(chan string, len(rebuilder.projections)),
+	}
+}
+
+// StartRebuild starts (or resumes) the rebuild of the projection without waiting for it.
+func (w *RebuildWorker) StartRebuild(name string) error {
+	if _, ok := w.projections[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownProjection, name)
+	}
+
+	select {
+	case w.requests <- name:
+		return nil
+	default:
+		return ErrRebuildInProgress
+	}
+}
+
+func (w *RebuildWorker) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	rebuild := func(name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := w.Rebuild(ctx, name, nil)
+			if err != nil && ctx.Err() == nil {
+				log.FromContext(ctx).WithError(err).WithField("projection", name).Error("Could not rebuild projection")
+			}
+		}()
+	}
+
+	for _, name := range w.Projections() {
+		status, err := w.Status(ctx, name)
+		if err != nil {
+			return err
+		}
+		if status.Status == entities.ProjectionRebuildRunning {
+			rebuild(name)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case name := <-w.requests:
+			rebuild(name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/message/outbox"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjection_Rebuild(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewOpsBookingReadModel(&db, 1, event.NewBus)
+	dataLake := NewEventRepository(&db, nil)
+	ctx := context.Background()
+
+	bookingID := uuid.New()
+	ticketID := uuid.NewString()
+
+	storeInDataLake := func(event any, header entities.EventHeader) {
+		payload, err := json.Marshal(event)
+		require.NoError(t, err)
+
+		err = dataLake.Create(ctx, entities.Event{
+			EventID:      header.ID,
+			PublishedAt:  header.PublishedAt,
+			EventName:    cqrs.StructName(event),
+			EventPayload: payload,
+		})
+		require.NoError(t, err)
+	}
+
+	bookingMade := &entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		NumberOfTickets: 1,
+		BookingID:       bookingID,
+		CustomerEmail:   "email@example.com",
+		ShowId:          uuid.New(),
+	}
+	storeInDataLake(bookingMade, bookingMade.Header)
+
+	unknown := &entities.TicketBookingCanceled_v1{Header: entities.NewEventHeader(), TicketID: ticketID}
+	storeInDataLake(unknown, unknown.Header)
+
+	confirmed := &entities.TicketBookingConfirmed_v1{
+		Header:        entities.NewEventHeader(),
+		TicketID:      ticketID,
+		CustomerEmail: "email@example.com",
+		Price:         entities.Money{Amount: "100", Currency: "EUR"},
+		BookingID:     bookingID.String(),
+	}
+	storeInDataLake(confirmed, confirmed.Header)
+
+	var reported []entities.ProjectionRebuildStatus
+	err := readModel.Projection().Rebuild(ctx, dataLake, func(status entities.ProjectionRebuildStatus) {
+		reported = append(reported, status)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, reported)
+	assert.Equal(t, entities.ProjectionRebuildFinished, reported[len(reported)-1].Status)
+
+	status, err := readModel.Projection().RebuildStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, entities.ProjectionRebuildFinished, status.Status)
+	assert.NotZero(t, status.UnknownEvents[cqrs.StructName(unknown)])
+
+	booking, err := readModel.GetByID(ctx, bookingID.String())
+	require.NoError(t, err)
+	require.Contains(t, booking.Tickets, ticketID)
+	assert.Equal(t, "100", booking.Tickets[ticketID].PriceAmount)
+
+	// the swapped table handles live events and can be rebuilt again
+	err = readModel.Projection().HandleEvent(ctx, &entities.TicketPrinted_v1{
+		Header:   entities.NewEventHeader(),
+		TicketID: ticketID,
+		FileName: ticketID + "-ticket.html",
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().Rebuild(ctx, dataLake, nil)
+	require.NoError(t, err)
+}
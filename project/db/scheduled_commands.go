@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"tickets/message/outbox"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jmoiron/sqlx"
+)
+
+// ScheduledCommands stores commands sent later by scheduler.Scheduler.
+//
+// Commands are scheduled in the transaction of the caller, and sent through the outbox
+// in the transaction removing them, so they are sent at least once.
+type ScheduledCommands struct {
+	db               *sqlx.DB
+	outboxPartitions outbox.Partitions
+}
+
+func NewScheduledCommands(db *DB, outboxPartitions outbox.Partitions) ScheduledCommands {
+	if db == nil {
+		panic("db is nil")
+	}
+
+	return ScheduledCommands{db: db.Conn, outboxPartitions: outboxPartitions}
+}
+
+func (s ScheduledCommands) Schedule(ctx context.Context, cmd scheduler.ScheduledCommand) error {
+	metadata, err := json.Marshal(cmd.Message.Metadata)
+	if err != nil {
+		return fmt.Errorf("could not marshal metadata: %w", err)
+	}
+
+	var key *string
+	if cmd.Key != "" {
+		key = &cmd.Key
+	}
+
+	_, err = executorFromContext(ctx, s.db).ExecContext(ctx, `
+		INSERT INTO scheduled_commands (command_id, schedule_key, command_name, topic, payload, metadata, partition_key, due_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (schedule_key) DO UPDATE
+		SET command_id = EXCLUDED.command_id,
+			command_name = EXCLUDED.command_name,
+			topic = EXCLUDED.topic,
+			payload = EXCLUDED.payload,
+			metadata = EXCLUDED.metadata,
+			partition_key = EXCLUDED.partition_key,
+			due_at = EXCLUDED.due_at,
+			scheduled_at = NOW()
+	`, cmd.Message.UUID, key, cmd.Name, cmd.Topic, []byte(cmd.Message.Payload), metadata, cmd.PartitionKey, cmd.DueAt)
+	if err != nil {
+		return fmt.Errorf("could not insert scheduled command: %w", err)
+	}
+
+	return nil
+}
+
+func (s ScheduledCommands) Cancel(ctx context.Context, key string) error {
+	_, err := executorFromContext(ctx, s.db).ExecContext(ctx, `
+		DELETE FROM scheduled_commands WHERE schedule_key = $1
+	`, key)
+	if err != nil {
+		return fmt.Errorf("could not delete scheduled command: %w", err)
+	}
+
+	return nil
+}
+
+type scheduledCommand struct {
+	CommandID    string    `db:"command_id"`
+	CommandName  string    `db:"command_name"`
+	Topic        string    `db:"topic"`
+	Payload      []byte    `db:"payload"`
+	Metadata     []byte    `db:"metadata"`
+	PartitionKey string    `db:"partition_key"`
+	DueAt        time.Time `db:"due_at"`
+}
+
+// SendDue sends at most limit commands due at now through the outbox, and removes them.
+// Commands locked by another worker are skipped, so workers can run on many instances.
+func (s ScheduledCommands) SendDue(ctx context.Context, now time.Time, limit int) ([]scheduler.SentCommand, error) {
+	var commands []scheduledCommand
+
+	err := updateInTx(ctx, s.db, sql.LevelReadCommitted, func(ctx context.Context, tx *sqlx.Tx) error {
+		err := tx.SelectContext(ctx, &commands, `
+			DELETE FROM scheduled_commands
+			WHERE command_id IN (
+				SELECT command_id
+				FROM scheduled_commands
+				WHERE due_at <= $1
+				ORDER BY due_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING command_id, command_name, topic, payload, metadata, partition_key, due_at
+		`, now, limit)
+		if err != nil {
+			return fmt.Errorf("could not get due scheduled commands: %w", err)
+		}
+
+		for _, cmd := range commands {
+			msg := message.NewMessage(cmd.CommandID, cmd.Payload)
+			msg.SetContext(ctx)
+			if err := json.Unmarshal(cmd.Metadata, &msg.Metadata); err != nil {
+				return fmt.Errorf("could not unmarshal metadata of scheduled command %s: %w", cmd.CommandID, err)
+			}
+
+			outboxPublisher, err := outbox.NewPublisherForDb(ctx, tx, s.outboxPartitions, cmd.PartitionKey)
+			if err != nil {
+				return fmt.Errorf("could not create outbox publisher: %w", err)
+			}
+
+			if err := outboxPublisher.Publish(cmd.Topic, msg); err != nil {
+				return fmt.Errorf("could not send scheduled command %s: %w", cmd.CommandID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sent := make([]scheduler.SentCommand, len(commands))
+	for i, cmd := range commands {
+		sent[i] = scheduler.SentCommand{Name: cmd.CommandName, DueAt: cmd.DueAt}
+	}
+
+	return sent, nil
+}
+
+func (s ScheduledCommands) OldestDue(ctx context.Context, now time.Time) (*time.Time, error) {
+	var oldest *time.Time
+	err := s.db.GetContext(ctx, &oldest, `
+		SELECT MIN(due_at) FROM scheduled_commands WHERE due_at <= $1
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not get oldest due scheduled command: %w", err)
+	}
+
+	return oldest, nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"tickets/entities"
+	"tickets/message/outbox"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledCommands(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	commands := NewScheduledCommands(&db, 1)
+	ctx := context.Background()
+
+	now := time.Now()
+	scheduled := func(key string, dueAt time.Time) scheduler.ScheduledCommand {
+		msg := message.NewMessage(uuid.NewString(), []byte(`{"taxi_booking_id":"`+uuid.NewString()+`"}`))
+		msg.Metadata.Set("name", "CancelTaxiBooking")
+
+		return scheduler.ScheduledCommand{
+			Key:          key,
+			Name:         "CancelTaxiBooking",
+			Topic:        "commands.CancelTaxiBooking",
+			Message:      msg,
+			PartitionKey: key,
+			DueAt:        dueAt,
+		}
+	}
+
+	key := uuid.NewString()
+	require.NoError(t, commands.Schedule(ctx, scheduled(key, now.Add(time.Hour))))
+	// scheduling with the same key replaces the command
+	require.NoError(t, commands.Schedule(ctx, scheduled(key, now.Add(-time.Minute))))
+	canceledKey := uuid.NewString()
+	require.NoError(t, commands.Schedule(ctx, scheduled(canceledKey, now.Add(-time.Minute))))
+	require.NoError(t, commands.Cancel(ctx, canceledKey))
+	laterKey := uuid.NewString()
+	require.NoError(t, commands.Schedule(ctx, scheduled(laterKey, now.Add(time.Hour))))
+
+	oldest, err := commands.OldestDue(ctx, now)
+	require.NoError(t, err)
+	require.NotNil(t, oldest)
+
+	sent, err := commands.SendDue(ctx, now, 1000)
+	require.NoError(t, err)
+	// commands of other tests may be due as well
+	assert.GreaterOrEqual(t, len(sent), 1)
+
+	var keys []string
+	err = dbconn.SelectContext(ctx, &keys, `
+		SELECT schedule_key FROM scheduled_commands WHERE schedule_key = ANY($1)
+	`, pq.StringArray{key, canceledKey, laterKey})
+	require.NoError(t, err)
+	assert.Equal(t, []string{laterKey}, keys)
+
+	require.NoError(t, commands.Cancel(ctx, laterKey))
+}
+
+func TestScheduledCommands_migratesVipBundleStepTimeouts(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	ctx := context.Background()
+
+	_, err := dbconn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS vip_bundle_step_timeouts (
+			vip_bundle_id UUID NOT NULL,
+			step VARCHAR(64) NOT NULL,
+			attempt INT NOT NULL,
+			due_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (vip_bundle_id, step)
+		)
+	`)
+	require.NoError(t, err)
+
+	vipBundleID := uuid.New()
+	dueAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	_, err = dbconn.ExecContext(ctx, `
+		INSERT INTO vip_bundle_step_timeouts (vip_bundle_id, step, attempt, due_at) VALUES ($1, 'inbound_flight', 1, $2)
+	`, vipBundleID, dueAt)
+	require.NoError(t, err)
+
+	db.MigrateSchema()
+
+	key := "vip_bundle/" + vipBundleID.String() + "/inbound_flight"
+	var migrated scheduledCommand
+	err = dbconn.GetContext(ctx, &migrated, `
+		SELECT command_id, command_name, topic, payload, metadata, partition_key, due_at
+		FROM scheduled_commands WHERE schedule_key = $1
+	`, key)
+	require.NoError(t, err)
+
+	assert.Equal(t, "TimeOutVipBundleStep", migrated.CommandName)
+	assert.Equal(t, "commands.TimeOutVipBundleStep", migrated.Topic)
+	assert.Equal(t, key, migrated.PartitionKey)
+	assert.True(t, dueAt.Equal(migrated.DueAt))
+
+	var metadata message.Metadata
+	require.NoError(t, json.Unmarshal(migrated.Metadata, &metadata))
+	assert.Equal(t, "TimeOutVipBundleStep", metadata.Get("name"))
+
+	var cmd entities.TimeOutVipBundleStep
+	require.NoError(t, json.Unmarshal(migrated.Payload, &cmd))
+	assert.Equal(t, entities.TimeOutVipBundleStep{VipBundleID: vipBundleID, Step: "inbound_flight", Attempt: 1}, cmd)
+
+	var legacyTable *string
+	require.NoError(t, dbconn.GetContext(ctx, &legacyTable, `SELECT to_regclass('vip_bundle_step_timeouts')::TEXT`))
+	assert.Nil(t, legacyTable)
+
+	require.NoError(t, NewScheduledCommands(&db, 1).Cancel(ctx, key))
+}
@@ -26,10 +26,6 @@ CREATE TABLE IF NOT EXISTS bookings (
     customer_email VARCHAR(255) NOT NULL,
     FOREIGN KEY (show_id) REFERENCES shows(show_id)
 );
-CREATE TABLE IF NOT EXISTS read_model_ops_bookings (
-    booking_id UUID PRIMARY KEY,
-    payload JSONB NOT NULL
-);
 
 CREATE TABLE IF NOT EXISTS events (
     event_id UUID PRIMARY KEY,
@@ -38,9 +34,231 @@ CREATE TABLE IF NOT EXISTS events (
     event_payload JSONB NOT NULL
 );
 
+CREATE INDEX IF NOT EXISTS events_position_idx ON events (published_at, event_id);
+CREATE INDEX IF NOT EXISTS events_name_position_idx ON events (event_name, published_at, event_id);
+CREATE INDEX IF NOT EXISTS events_booking_id_idx ON events ((event_payload->>'booking_id'));
+CREATE INDEX IF NOT EXISTS events_ticket_id_idx ON events ((event_payload->>'ticket_id'));
+CREATE INDEX IF NOT EXISTS events_vip_bundle_id_idx ON events ((event_payload->>'vip_bundle_id'));
+CREATE INDEX IF NOT EXISTS events_payload_idx ON events USING GIN (event_payload jsonb_path_ops);
+
+-- the order events were stored in, which exports follow, as late events are stored with an earlier published_at
+ALTER TABLE events ADD COLUMN IF NOT EXISTS ingested_id BIGSERIAL;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS events_ingested_id_idx ON events (ingested_id);
+
+CREATE TABLE IF NOT EXISTS data_lake_exports (
+	export VARCHAR(255) PRIMARY KEY,
+	last_cursor TEXT NOT NULL,
+	events_exported BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 CREATE TABLE IF NOT EXISTS vip_bundles (
 	vip_bundle_id UUID PRIMARY KEY,
 	booking_id UUID NOT NULL UNIQUE,
-	payload JSONB NOT NULL
-); 
+	payload JSONB NOT NULL,
+	version INT NOT NULL DEFAULT 1
+);
+
+ALTER TABLE vip_bundles ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+-- bundles stored before the column was added are considered created when it was added
+ALTER TABLE vip_bundles ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS vip_bundles_state_idx ON vip_bundles ((payload->>'state'), created_at);
+
+-- VIP bundles stored before the state machine get their state from the step timestamps and flags
+UPDATE vip_bundles SET payload = payload || jsonb_build_object(
+	'state',
+	CASE
+		WHEN (payload->>'canceled')::BOOLEAN THEN 'canceled'
+		WHEN (payload->>'failed')::BOOLEAN THEN 'failed'
+		WHEN (payload->>'finalized')::BOOLEAN THEN 'finalized'
+		WHEN payload->>'return_flight_booked_at' IS NOT NULL THEN 'booking_taxi'
+		WHEN payload->>'inbound_flight_booked_at' IS NOT NULL THEN 'booking_return_flight'
+		WHEN payload->>'booking_made_at' IS NOT NULL THEN 'booking_inbound_flight'
+		ELSE 'booking_tickets'
+	END,
+	'visited_states',
+	to_jsonb(array_remove(ARRAY[
+		'booking_tickets',
+		CASE WHEN payload->>'booking_made_at' IS NOT NULL THEN 'booking_inbound_flight' END,
+		CASE WHEN payload->>'inbound_flight_booked_at' IS NOT NULL THEN 'booking_return_flight' END,
+		CASE WHEN payload->>'return_flight_booked_at' IS NOT NULL THEN 'booking_taxi' END,
+		CASE
+			WHEN (payload->>'canceled')::BOOLEAN THEN 'canceled'
+			WHEN (payload->>'failed')::BOOLEAN THEN 'failed'
+			WHEN (payload->>'finalized')::BOOLEAN THEN 'finalized'
+		END
+	], NULL))
+)
+WHERE payload->>'state' IS NULL;
+
+CREATE TABLE IF NOT EXISTS scheduled_commands (
+	command_id UUID PRIMARY KEY,
+	schedule_key VARCHAR(255) UNIQUE,
+	command_name VARCHAR(255) NOT NULL,
+	topic VARCHAR(255) NOT NULL,
+	payload BYTEA NOT NULL,
+	metadata JSONB NOT NULL,
+	partition_key VARCHAR(255) NOT NULL,
+	due_at TIMESTAMPTZ NOT NULL,
+	scheduled_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_commands_due_at_idx ON scheduled_commands (due_at);
+
+-- vip_bundle_step_timeouts was replaced by TimeOutVipBundleStep commands sent by the scheduler,
+-- pending timeouts are marshaled like the command bus does
+DO $$
+BEGIN
+	IF to_regclass('vip_bundle_step_timeouts') IS NOT NULL THEN
+		INSERT INTO scheduled_commands (command_id, schedule_key, command_name, topic, payload, metadata, partition_key, due_at)
+		SELECT
+			gen_random_uuid(),
+			'vip_bundle/' || vip_bundle_id || '/' || step,
+			'TimeOutVipBundleStep',
+			'commands.TimeOutVipBundleStep',
+			convert_to(jsonb_build_object(
+				'vip_bundle_id', vip_bundle_id,
+				'step', step,
+				'attempt', attempt
+			)::TEXT, 'UTF8'),
+			jsonb_build_object(
+				'name', 'TimeOutVipBundleStep',
+				'correlation_id', 'gen_' || gen_random_uuid()
+			),
+			'vip_bundle/' || vip_bundle_id || '/' || step,
+			due_at
+		FROM vip_bundle_step_timeouts
+		ON CONFLICT (schedule_key) DO NOTHING;
+
+		DROP TABLE vip_bundle_step_timeouts;
+	END IF;
+END $$;
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS canceled_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS event_store (
+	aggregate_type VARCHAR(255) NOT NULL,
+	aggregate_id VARCHAR(255) NOT NULL,
+	version INT NOT NULL,
+	event_id UUID NOT NULL UNIQUE,
+	event_name VARCHAR(255) NOT NULL,
+	payload JSONB NOT NULL,
+	recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (aggregate_type, aggregate_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS aggregate_snapshots (
+	aggregate_type VARCHAR(255) NOT NULL,
+	aggregate_id VARCHAR(255) NOT NULL,
+	version INT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (aggregate_type, aggregate_id)
+);
+
+-- bookings made before the event store start their stream with BookingMade_v1
+WITH legacy_bookings AS (
+	SELECT b.*, uuid_generate_v4() AS event_id
+	FROM bookings b
+	WHERE NOT EXISTS (
+		SELECT 1 FROM event_store e WHERE e.aggregate_type = 'booking' AND e.aggregate_id = b.booking_id::TEXT
+	)
+)
+INSERT INTO event_store (aggregate_type, aggregate_id, version, event_id, event_name, payload)
+SELECT
+	'booking',
+	booking_id::TEXT,
+	1,
+	event_id,
+	'BookingMade_v1',
+	jsonb_build_object(
+		'header', jsonb_build_object('id', event_id, 'published_at', NOW(), 'idempotency_key', event_id),
+		'number_of_tickets', number_of_tickets,
+		'booking_id', booking_id,
+		'customer_email', customer_email,
+		'show_id', show_id
+	)
+FROM legacy_bookings;
+
+CREATE TABLE IF NOT EXISTS processed_messages (
+	handler_name VARCHAR(255) NOT NULL,
+	message_id VARCHAR(255) NOT NULL,
+	processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (handler_name, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS parked_events (
+	id BIGSERIAL PRIMARY KEY,
+	consumer VARCHAR(255) NOT NULL,
+	parent_id VARCHAR(255) NOT NULL,
+	event_name VARCHAR(255) NOT NULL,
+	payload JSONB NOT NULL,
+	parked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS parked_events_parent_idx ON parked_events (consumer, parent_id);
+
+CREATE TABLE IF NOT EXISTS projection_checkpoints (
+	projection VARCHAR(255) PRIMARY KEY,
+	last_event_id VARCHAR(255) NOT NULL,
+	events_applied BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS projection_rebuilds (
+	projection VARCHAR(255) PRIMARY KEY,
+	status VARCHAR(32) NOT NULL,
+	position_published_at TIMESTAMP NOT NULL,
+	position_event_id UUID NOT NULL,
+	events_total BIGINT NOT NULL DEFAULT 0,
+	events_processed BIGINT NOT NULL DEFAULT 0,
+	events_skipped BIGINT NOT NULL DEFAULT 0,
+	unknown_events JSONB NOT NULL DEFAULT '{}',
+	last_error TEXT NOT NULL DEFAULT '',
+	started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	finished_at TIMESTAMPTZ
+);
+` + projectionSchema(opsBookingsProjection) + `
+` + projectionSchema(vipBundlesProjection) + `
+-- read_model_ops_bookings was replaced by the ops_bookings projection
+DO $$
+BEGIN
+	IF to_regclass('read_model_ops_bookings') IS NOT NULL THEN
+		INSERT INTO projection_ops_bookings (id, lookup_keys, payload)
+		SELECT
+			booking_id::TEXT,
+			CASE WHEN jsonb_typeof(payload->'tickets') = 'object'
+				THEN ARRAY(SELECT jsonb_object_keys(payload->'tickets'))
+				ELSE '{}'
+			END,
+			payload
+		FROM read_model_ops_bookings
+		ON CONFLICT (id) DO NOTHING;
+
+		DROP TABLE read_model_ops_bookings;
+	END IF;
+END $$;
+
+-- fields of states stored before they were declared, computed like the read models do
+UPDATE projection_ops_bookings SET fields = jsonb_build_object(
+	'receipt_issued_date', COALESCE((
+		SELECT jsonb_agg(DISTINCT to_char((ticket->>'receipt_issued_at')::TIMESTAMPTZ AT TIME ZONE 'UTC', 'YYYY-MM-DD'))
+		FROM jsonb_each(CASE WHEN jsonb_typeof(payload->'tickets') = 'object' THEN payload->'tickets' ELSE '{}' END) AS tickets(id, ticket)
+		WHERE (ticket->>'receipt_issued_at')::TIMESTAMPTZ > '0001-01-01T00:00:00Z'
+	), '[]')
+)
+WHERE fields IS NULL;
+
+UPDATE projection_vip_bundles SET fields = jsonb_build_object(
+	'status', jsonb_build_array(COALESCE(payload->>'status', '')),
+	'customer_email', jsonb_build_array(COALESCE(payload->>'customer_email', '')),
+	'initialized_date', jsonb_build_array(to_char((payload->>'initialized_at')::TIMESTAMPTZ AT TIME ZONE 'UTC', 'YYYY-MM-DD'))
+)
+WHERE fields IS NULL;
+
+-- sort keys of bundles stored before they were sorted in the database, formatted like vipBundleSortKeyLayout
+UPDATE projection_vip_bundles SET sort_key = to_char((payload->>'initialized_at')::TIMESTAMPTZ AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"000Z"')
+WHERE sort_key = '';
 `
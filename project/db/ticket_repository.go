@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"tickets/entities"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type ITicketRepository interface {
@@ -27,8 +29,9 @@ func NewTicketRepo(db *DB) TicketRepository {
 }
 
 func (tr TicketRepository) Create(ctx context.Context, ticket entities.Ticket) error {
-	_, err := tr.db.Conn.NamedExecContext(
+	_, err := sqlx.NamedExecContext(
 		ctx,
+		executorFromContext(ctx, tr.db.Conn),
 		`
 		INSERT INTO 
     		tickets (ticket_id, price_amount, price_currency, customer_email) 
@@ -43,7 +46,8 @@ func (tr TicketRepository) Create(ctx context.Context, ticket entities.Ticket) e
 }
 
 func (tr TicketRepository) Delete(ctx context.Context, ticket entities.Ticket) error {
-	_, err := tr.db.Conn.NamedExecContext(ctx,
+	_, err := sqlx.NamedExecContext(ctx,
+		executorFromContext(ctx, tr.db.Conn),
 		`DELETE FROM tickets WHERE ticket_id = :ticket_id`,
 		ticket)
 	if err != nil {
@@ -53,8 +57,10 @@ func (tr TicketRepository) Delete(ctx context.Context, ticket entities.Ticket) e
 }
 
 func (tr TicketRepository) Update(ctx context.Context, ticket entities.Ticket) error {
+	db := executorFromContext(ctx, tr.db.Conn)
+
 	var exists bool
-	err := tr.db.Conn.GetContext(ctx, &exists, `
+	err := sqlx.GetContext(ctx, db, &exists, `
         SELECT EXISTS (SELECT 1 FROM tickets WHERE ticket_id = $1)`, ticket.TicketID)
 	if err != nil {
 		return fmt.Errorf("could not check if ticket exists: %w", err)
@@ -64,7 +70,7 @@ func (tr TicketRepository) Update(ctx context.Context, ticket entities.Ticket) e
 		return fmt.Errorf("ticket with id %s does not exist", ticket.TicketID)
 	}
 
-	_, err = tr.db.Conn.NamedExecContext(ctx, `
+	_, err = sqlx.NamedExecContext(ctx, db, `
         UPDATE tickets SET deleted_at = :deleted_at WHERE ticket_id = :ticket_id`, ticket)
 	if err != nil {
 		return fmt.Errorf("could not update ticket: %w", err)
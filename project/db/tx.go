@@ -9,12 +9,38 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+type txContextKey struct{}
+
+func contextWithTx(ctx context.Context, tx *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+func txFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx)
+	return tx, ok
+}
+
+// executorFromContext returns the transaction started by the caller (for example the inbox),
+// so repository writes are committed together with it. Without one, db is used.
+func executorFromContext(ctx context.Context, db *sqlx.DB) sqlx.ExtContext {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx
+	}
+
+	return db
+}
+
 func updateInTx(
 	ctx context.Context,
 	db *sqlx.DB,
 	isolation sql.IsolationLevel,
 	fn func(ctx context.Context, tx *sqlx.Tx) error,
 ) (err error) {
+	if tx, ok := txFromContext(ctx); ok {
+		// already running in a transaction - joining it
+		return fn(ctx, tx)
+	}
+
 	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: isolation})
 	if err != nil {
 		return fmt.Errorf("could not begin transaction: %w", err)
@@ -31,5 +57,15 @@ func updateInTx(
 		err = tx.Commit()
 	}()
 
-	return fn(ctx, tx)
+	return fn(contextWithTx(ctx, tx), tx)
+}
+
+// lockKey serializes transactions working on the same key (for example an aggregate ID) until tx ends.
+func lockKey(ctx context.Context, tx *sqlx.Tx, key string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))", key)
+	if err != nil {
+		return fmt.Errorf("could not lock %s: %w", key, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"tickets/message/outbox"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jmoiron/sqlx"
+)
+
+// UnitOfWork commits repository writes and the events and commands they produce atomically.
+//
+// Repositories called with the context passed to fn join the transaction,
+// and the buses passed to fn publish through the outbox in the same transaction.
+type UnitOfWork struct {
+	db               *sqlx.DB
+	outboxPartitions outbox.Partitions
+	newEventBus      func(message.Publisher) *cqrs.EventBus
+	newCommandBus    func(message.Publisher) *cqrs.CommandBus
+}
+
+func NewUnitOfWork(
+	db *DB,
+	outboxPartitions outbox.Partitions,
+	newEventBus func(message.Publisher) *cqrs.EventBus,
+	newCommandBus func(message.Publisher) *cqrs.CommandBus,
+) UnitOfWork {
+	if db == nil {
+		panic("db is nil")
+	}
+	if newEventBus == nil {
+		panic("newEventBus is nil")
+	}
+	if newCommandBus == nil {
+		panic("newCommandBus is nil")
+	}
+
+	return UnitOfWork{
+		db:               db.Conn,
+		outboxPartitions: outboxPartitions,
+		newEventBus:      newEventBus,
+		newCommandBus:    newCommandBus,
+	}
+}
+
+// Do runs fn in a transaction. partitionKey selects the outbox partition,
+// so messages of the same aggregate are forwarded in order.
+func (u UnitOfWork) Do(
+	ctx context.Context,
+	partitionKey string,
+	fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+) error {
+	return updateInTx(
+		ctx,
+		u.db,
+		sql.LevelSerializable,
+		func(ctx context.Context, tx *sqlx.Tx) error {
+			publisher, err := outbox.NewPublisherForDb(ctx, tx, u.outboxPartitions, partitionKey)
+			if err != nil {
+				return fmt.Errorf("could not create outbox publisher: %w", err)
+			}
+
+			return fn(ctx, u.newEventBus(publisher), u.newCommandBus(publisher))
+		},
+	)
+}
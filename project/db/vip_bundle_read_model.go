@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"tickets/entities"
+	"tickets/message/outbox"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	vipBundlesProjection   = "vip_bundles"
+	vipBundleSortKeyLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// VipBundleReadModel is the projection of VIP bundles with the timeline of their steps.
+// Booking events find their bundle by the booking ID, which is a lookup key of the bundle;
+// flight, hotel and taxi events reference the bundle ID.
+type VipBundleReadModel struct {
+	projection *Projection[entities.VipBundle_v1]
+}
+
+func NewVipBundleReadModel(db *DB, outboxPartitions outbox.Partitions, newEventBus func(message.Publisher) *cqrs.EventBus) VipBundleReadModel {
+	p := NewProjection[entities.VipBundle_v1](db, vipBundlesProjection, outboxPartitions, newEventBus)
+
+	p.LookupKeys(func(rm entities.VipBundle_v1) []string {
+		if rm.BookingID == uuid.Nil {
+			return nil
+		}
+		return []string{rm.BookingID.String()}
+	})
+
+	p.Field("status", func(rm entities.VipBundle_v1) []string {
+		return []string{rm.Status}
+	})
+	p.Field("customer_email", func(rm entities.VipBundle_v1) []string {
+		return []string{rm.CustomerEmail}
+	})
+	// bundles are listed by the time they were initialized, the fixed width keeps the text order
+	p.SortKey(func(rm entities.VipBundle_v1) string {
+		return rm.InitializedAt.UTC().Format(vipBundleSortKeyLayout)
+	})
+
+	p.Field("initialized_date", func(rm entities.VipBundle_v1) []string {
+		return []string{rm.InitializedAt.UTC().Format(time.DateOnly)}
+	})
+
+	HandleCreate(p, func(e *entities.VipBundleInitialized_v1) string { return e.VipBundleID.String() }, onVipBundleInitialized)
+	// bookings outside VIP bundles are skipped
+	HandleOwned(p, func(e *entities.BookingMade_v1) string { return e.BookingID.String() }, func(ctx context.Context, tx *sqlx.Tx, e *entities.BookingMade_v1) (bool, error) {
+		return vipBundleBookingExists(ctx, tx, e.BookingID)
+	}, onVipBundleBookingMade)
+	HandleOwned(p, func(e *entities.BookingFailed_v1) string { return e.BookingID.String() }, func(ctx context.Context, tx *sqlx.Tx, e *entities.BookingFailed_v1) (bool, error) {
+		return vipBundleBookingExists(ctx, tx, e.BookingID)
+	}, onVipBundleBookingFailed)
+	Handle(p, func(e *entities.FlightBooked_v1) string { return e.ReferenceID }, onVipBundleFlightBooked)
+	Handle(p, func(e *entities.FlightBookingFailed_v1) string { return e.ReferenceID }, onVipBundleFlightBookingFailed)
+	Handle(p, func(e *entities.HotelBooked_v1) string { return e.ReferenceID }, onVipBundleHotelBooked)
+	Handle(p, func(e *entities.HotelBookingFailed_v1) string { return e.ReferenceID }, onVipBundleHotelBookingFailed)
+	Handle(p, func(e *entities.TaxiBooked_v1) string { return e.ReferenceID }, onVipBundleTaxiBooked)
+	Handle(p, func(e *entities.TaxiBookingFailed_v1) string { return e.ReferenceID }, onVipBundleTaxiBookingFailed)
+	Handle(p, func(e *entities.VipBundleFinalized_v1) string { return e.VipBundleID.String() }, onVipBundleFinalized)
+	Handle(p, func(e *entities.VipBundleCanceled_v1) string { return e.VipBundleID.String() }, onVipBundleCanceled)
+	Handle(p, func(e *entities.VipBundleOperatorActionTaken_v1) string { return e.VipBundleID.String() }, onVipBundleOperatorActionTaken)
+
+	return VipBundleReadModel{projection: p}
+}
+
+func (r VipBundleReadModel) Projection() *Projection[entities.VipBundle_v1] {
+	return r.projection
+}
+
+func (r VipBundleReadModel) EventHandlers() []cqrs.EventHandler {
+	return r.projection.EventHandlers()
+}
+
+func (r VipBundleReadModel) GetByID(ctx context.Context, vipBundleID string) (entities.VipBundle_v1, error) {
+	rm, err := r.projection.Get(ctx, vipBundleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rm, fmt.Errorf("%w: %s", entities.ErrVipBundleNotFound, vipBundleID)
+	}
+
+	return rm, err
+}
+
+// GetAll returns a page of bundles matching the filter, the most recently initialized first.
+func (r VipBundleReadModel) GetAll(ctx context.Context, filter entities.VipBundleFilter) (entities.VipBundlesPage_v1, error) {
+	query := ProjectionQuery{
+		Filter:     map[string]string{},
+		Descending: true,
+		After:      filter.After,
+		Limit:      filter.Limit,
+	}
+	if filter.Status != "" {
+		query.Filter["status"] = filter.Status
+	}
+	if filter.Date != nil {
+		query.Filter["initialized_date"] = filter.Date.Format(time.DateOnly)
+	}
+	if filter.CustomerEmail != "" {
+		query.Filter["customer_email"] = filter.CustomerEmail
+	}
+
+	bundles, next, err := r.projection.Find(ctx, query)
+	if err != nil {
+		return entities.VipBundlesPage_v1{}, err
+	}
+
+	return entities.VipBundlesPage_v1{VipBundles: bundles, NextCursor: next}, nil
+}
+
+// vipBundleBookingExists tells if the booking was made by a VIP bundle. The bundle is stored together
+// with VipBundleInitialized_v1, so its events are parked until the event creates the read model.
+func vipBundleBookingExists(ctx context.Context, tx *sqlx.Tx, bookingID uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM vip_bundles WHERE booking_id = $1)", bookingID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("could not check vip bundle of booking %s: %w", bookingID, err)
+	}
+
+	return exists, nil
+}
+
+func onVipBundleInitialized(e *entities.VipBundleInitialized_v1) (entities.VipBundle_v1, error) {
+	return entities.VipBundle_v1{
+		VipBundleID:     e.VipBundleID,
+		BookingID:       e.BookingID,
+		CustomerEmail:   e.CustomerEmail,
+		NumberOfTickets: e.NumberOfTickets,
+		ShowID:          e.ShowID,
+		InboundFlightID: e.InboundFlightID,
+		ReturnFlightID:  e.ReturnFlightID,
+		Status:          entities.VipBundleStatusInProgress,
+		InitializedAt:   e.Header.PublishedAt,
+		LastUpdate:      e.Header.PublishedAt,
+		Timeline: []entities.VipBundleStep_v1{
+			{Step: entities.VipBundleStepInitialized, At: e.Header.PublishedAt},
+		},
+	}, nil
+}
+
+func onVipBundleBookingMade(rm entities.VipBundle_v1, e *entities.BookingMade_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step: entities.VipBundleStepBookingMade,
+		At:   e.Header.PublishedAt,
+	}), nil
+}
+
+func onVipBundleBookingFailed(rm entities.VipBundle_v1, e *entities.BookingFailed_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:          entities.VipBundleStepBookingFailed,
+		At:            e.Header.PublishedAt,
+		FailureReason: e.FailureReason,
+	}), nil
+}
+
+func onVipBundleFlightBooked(rm entities.VipBundle_v1, e *entities.FlightBooked_v1) (entities.VipBundle_v1, error) {
+	step := entities.VipBundleStepInboundFlightBooked
+	if e.FlightID == rm.ReturnFlightID {
+		step = entities.VipBundleStepReturnFlightBooked
+	}
+
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:     step,
+		At:       e.Header.PublishedAt,
+		FlightID: &e.FlightID,
+	}), nil
+}
+
+func onVipBundleFlightBookingFailed(rm entities.VipBundle_v1, e *entities.FlightBookingFailed_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:          entities.VipBundleStepFlightBookingFailed,
+		At:            e.Header.PublishedAt,
+		FlightID:      &e.FlightID,
+		FailureReason: e.FailureReason,
+	}), nil
+}
+
+func onVipBundleHotelBooked(rm entities.VipBundle_v1, e *entities.HotelBooked_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:           entities.VipBundleStepHotelBooked,
+		At:             e.Header.PublishedAt,
+		HotelBookingID: &e.HotelBookingID,
+	}), nil
+}
+
+func onVipBundleHotelBookingFailed(rm entities.VipBundle_v1, e *entities.HotelBookingFailed_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:          entities.VipBundleStepHotelBookingFailed,
+		At:            e.Header.PublishedAt,
+		FailureReason: e.FailureReason,
+	}), nil
+}
+
+func onVipBundleTaxiBooked(rm entities.VipBundle_v1, e *entities.TaxiBooked_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:          entities.VipBundleStepTaxiBooked,
+		At:            e.Header.PublishedAt,
+		TaxiBookingID: &e.TaxiBookingID,
+	}), nil
+}
+
+func onVipBundleTaxiBookingFailed(rm entities.VipBundle_v1, e *entities.TaxiBookingFailed_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:          entities.VipBundleStepTaxiBookingFailed,
+		At:            e.Header.PublishedAt,
+		FailureReason: e.FailureReason,
+	}), nil
+}
+
+func onVipBundleFinalized(rm entities.VipBundle_v1, e *entities.VipBundleFinalized_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step: entities.VipBundleStepFinalized,
+		At:   e.Header.PublishedAt,
+	}), nil
+}
+
+func onVipBundleCanceled(rm entities.VipBundle_v1, e *entities.VipBundleCanceled_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step: entities.VipBundleStepCanceled,
+		At:   e.Header.PublishedAt,
+	}), nil
+}
+
+func onVipBundleOperatorActionTaken(rm entities.VipBundle_v1, e *entities.VipBundleOperatorActionTaken_v1) (entities.VipBundle_v1, error) {
+	return addVipBundleStep(rm, entities.VipBundleStep_v1{
+		Step:           entities.VipBundleStepOperatorAction,
+		At:             e.Header.PublishedAt,
+		OperatorAction: e.Action,
+		Operator:       e.Operator,
+		Reason:         e.Reason,
+	}), nil
+}
+
+// addVipBundleStep adds the step to the timeline, ordered by time, as events may arrive out of order.
+// The first failure fails the bundle unless it was canceled, and the bundle is finalized only if it's still in progress.
+// Operators rolling back or resolving the bundle have the last word.
+func addVipBundleStep(rm entities.VipBundle_v1, step entities.VipBundleStep_v1) entities.VipBundle_v1 {
+	timeline := make([]entities.VipBundleStep_v1, 0, len(rm.Timeline)+1)
+	timeline = append(timeline, rm.Timeline...)
+	timeline = append(timeline, step)
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].At.Before(timeline[j].At)
+	})
+	rm.Timeline = timeline
+
+	switch step.Step {
+	case entities.VipBundleStepBookingFailed, entities.VipBundleStepFlightBookingFailed,
+		entities.VipBundleStepHotelBookingFailed, entities.VipBundleStepTaxiBookingFailed:
+		if rm.Status == entities.VipBundleStatusInProgress || rm.Status == entities.VipBundleStatusFinalized {
+			rm.Status = entities.VipBundleStatusFailed
+			rm.FailureReason = step.FailureReason
+		}
+	case entities.VipBundleStepFinalized:
+		if rm.Status == entities.VipBundleStatusInProgress {
+			rm.Status = entities.VipBundleStatusFinalized
+		}
+	case entities.VipBundleStepCanceled:
+		// bundles are canceled only before they fail, so steps failing later don't change the status
+		rm.Status = entities.VipBundleStatusCanceled
+		rm.FailureReason = ""
+	case entities.VipBundleStepOperatorAction:
+		switch step.OperatorAction {
+		case entities.VipBundleOperatorActionForceRollback:
+			rm.Status = entities.VipBundleStatusFailed
+			rm.FailureReason = "rolled back by " + step.Operator
+			if step.Reason != "" {
+				rm.FailureReason += ": " + step.Reason
+			}
+		case entities.VipBundleOperatorActionResolve:
+			rm.Status = entities.VipBundleStatusResolved
+		}
+	}
+
+	if step.At.After(rm.LastUpdate) {
+		rm.LastUpdate = step.At
+	}
+
+	return rm
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/message/outbox"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVipBundleReadModel_timeline(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewVipBundleReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	vipBundleID := uuid.New()
+	bookingID := uuid.New()
+	inboundFlightID := uuid.New()
+	returnFlightID := uuid.New()
+	customerEmail := uuid.NewString() + "@example.com"
+
+	// bookings outside VIP bundles are not parked
+	err := readModel.Projection().HandleEvent(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: uuid.New(),
+	})
+	require.NoError(t, err)
+
+	// flight events may arrive before the bundle
+	err = readModel.Projection().HandleEvent(ctx, &entities.FlightBooked_v1{
+		Header:      entities.NewEventHeader(),
+		FlightID:    inboundFlightID,
+		ReferenceID: vipBundleID.String(),
+	})
+	require.NoError(t, err)
+
+	initialized := entities.NewEventHeader()
+	initialized.PublishedAt = initialized.PublishedAt.Add(-time.Minute)
+	err = readModel.Projection().HandleEvent(ctx, &entities.VipBundleInitialized_v1{
+		Header:          initialized,
+		VipBundleID:     vipBundleID,
+		BookingID:       bookingID,
+		CustomerEmail:   customerEmail,
+		NumberOfTickets: 1,
+		ShowID:          uuid.New(),
+		InboundFlightID: inboundFlightID,
+		ReturnFlightID:  returnFlightID,
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: bookingID,
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.FlightBookingFailed_v1{
+		Header:        entities.NewEventHeader(),
+		FlightID:      returnFlightID,
+		FailureReason: "no seats left",
+		ReferenceID:   vipBundleID.String(),
+	})
+	require.NoError(t, err)
+
+	vb, err := readModel.GetByID(ctx, vipBundleID.String())
+	require.NoError(t, err)
+	assert.Equal(t, entities.VipBundleStatusFailed, vb.Status)
+	assert.Equal(t, "no seats left", vb.FailureReason)
+
+	var steps []string
+	for _, step := range vb.Timeline {
+		steps = append(steps, step.Step)
+	}
+	assert.Equal(t, []string{
+		entities.VipBundleStepInitialized,
+		entities.VipBundleStepInboundFlightBooked,
+		entities.VipBundleStepBookingMade,
+		entities.VipBundleStepFlightBookingFailed,
+	}, steps)
+
+	bundles, err := readModel.GetAll(ctx, entities.VipBundleFilter{
+		Status:        entities.VipBundleStatusFailed,
+		Date:          &initialized.PublishedAt,
+		CustomerEmail: customerEmail,
+	})
+	require.NoError(t, err)
+	require.Len(t, bundles.VipBundles, 1)
+	assert.Equal(t, vipBundleID, bundles.VipBundles[0].VipBundleID)
+
+	_, err = readModel.GetByID(ctx, uuid.NewString())
+	assert.ErrorIs(t, err, entities.ErrVipBundleNotFound)
+}
+
+func TestVipBundleReadModel_bookingBeforeBundle(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewVipBundleReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	vipBundleID := uuid.New()
+	bookingID := uuid.New()
+
+	_, err := dbconn.ExecContext(ctx, "INSERT INTO vip_bundles (vip_bundle_id, booking_id, payload) VALUES ($1, $2, '{}')", vipBundleID, bookingID)
+	require.NoError(t, err)
+
+	// the booking of the bundle is parked until the bundle is initialized
+	err = readModel.Projection().HandleEvent(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: bookingID,
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vipBundleID,
+		BookingID:   bookingID,
+	})
+	require.NoError(t, err)
+
+	vb, err := readModel.GetByID(ctx, vipBundleID.String())
+	require.NoError(t, err)
+	require.Len(t, vb.Timeline, 2)
+	assert.Equal(t, entities.VipBundleStepBookingMade, vb.Timeline[1].Step)
+}
+
+func TestVipBundleReadModel_pages(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewVipBundleReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	customerEmail := uuid.NewString() + "@example.com"
+	initializedAt := time.Now().UTC()
+
+	var vipBundleIDs []uuid.UUID
+	for i := 0; i < 3; i++ {
+		header := entities.NewEventHeader()
+		header.PublishedAt = initializedAt.Add(time.Duration(i) * time.Second)
+		vipBundleID := uuid.New()
+		vipBundleIDs = append(vipBundleIDs, vipBundleID)
+
+		err := readModel.Projection().HandleEvent(ctx, &entities.VipBundleInitialized_v1{
+			Header:        header,
+			VipBundleID:   vipBundleID,
+			BookingID:     uuid.New(),
+			CustomerEmail: customerEmail,
+		})
+		require.NoError(t, err)
+	}
+
+	filter := entities.VipBundleFilter{CustomerEmail: customerEmail, Limit: 2}
+
+	page, err := readModel.GetAll(ctx, filter)
+	require.NoError(t, err)
+	require.Len(t, page.VipBundles, 2)
+	assert.Equal(t, vipBundleIDs[2], page.VipBundles[0].VipBundleID)
+	assert.Equal(t, vipBundleIDs[1], page.VipBundles[1].VipBundleID)
+	require.NotEmpty(t, page.NextCursor)
+
+	filter.After = page.NextCursor
+	page, err = readModel.GetAll(ctx, filter)
+	require.NoError(t, err)
+	require.Len(t, page.VipBundles, 1)
+	assert.Equal(t, vipBundleIDs[0], page.VipBundles[0].VipBundleID)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = readModel.GetAll(ctx, entities.VipBundleFilter{After: "not a cursor"})
+	assert.ErrorIs(t, err, entities.ErrInvalidProjectionQuery)
+}
+
+func TestVipBundleReadModel_canceled(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewVipBundleReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	vipBundleID := uuid.New()
+
+	err := readModel.Projection().HandleEvent(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vipBundleID,
+		BookingID:   uuid.New(),
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.VipBundleCanceled_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vipBundleID,
+	})
+	require.NoError(t, err)
+
+	// the flight booking in progress failed after the cancellation
+	err = readModel.Projection().HandleEvent(ctx, &entities.FlightBookingFailed_v1{
+		Header:        entities.NewEventHeader(),
+		FlightID:      uuid.New(),
+		FailureReason: "no seats left",
+		ReferenceID:   vipBundleID.String(),
+	})
+	require.NoError(t, err)
+
+	vb, err := readModel.GetByID(ctx, vipBundleID.String())
+	require.NoError(t, err)
+	assert.Equal(t, entities.VipBundleStatusCanceled, vb.Status)
+	assert.Empty(t, vb.FailureReason)
+	assert.Len(t, vb.Timeline, 3)
+}
+
+func TestVipBundleReadModel_resolvedByOperator(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	readModel := NewVipBundleReadModel(&db, 1, event.NewBus)
+	ctx := context.Background()
+
+	vipBundleID := uuid.New()
+
+	err := readModel.Projection().HandleEvent(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vipBundleID,
+		BookingID:   uuid.New(),
+	})
+	require.NoError(t, err)
+
+	err = readModel.Projection().HandleEvent(ctx, &entities.VipBundleOperatorActionTaken_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vipBundleID,
+		Action:      entities.VipBundleOperatorActionResolve,
+		Operator:    "jane",
+		Reason:      "flight booked by phone",
+		FromState:   "booking_inbound_flight",
+		ToState:     "resolved",
+	})
+	require.NoError(t, err)
+
+	// the flight booking in progress failed after the bundle was resolved
+	err = readModel.Projection().HandleEvent(ctx, &entities.FlightBookingFailed_v1{
+		Header:        entities.NewEventHeader(),
+		FlightID:      uuid.New(),
+		FailureReason: "no seats left",
+		ReferenceID:   vipBundleID.String(),
+	})
+	require.NoError(t, err)
+
+	vb, err := readModel.GetByID(ctx, vipBundleID.String())
+	require.NoError(t, err)
+	assert.Equal(t, entities.VipBundleStatusResolved, vb.Status)
+	require.Len(t, vb.Timeline, 3)
+	assert.Equal(t, entities.VipBundleStepOperatorAction, vb.Timeline[1].Step)
+	assert.Equal(t, "jane", vb.Timeline[1].Operator)
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"tickets/message/event"
+	"tickets/message/outbox"
+	"tickets/message/sagas"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVipBundleRepository_concurrentUpdates(t *testing.T) {
+	dbconn := getDb()
+	db := DB{Conn: dbconn}
+	db.MigrateSchema()
+	outbox.SubscribeForPGMessages(dbconn, watermill.NopLogger{}, 1)
+	repo := NewVipBundleRepository(dbconn, 1, event.NewBus)
+	ctx := context.Background()
+
+	vb, err := sagas.NewVipBundle(
+		uuid.New(),
+		uuid.New(),
+		"email@example.com",
+		1,
+		uuid.New(),
+		[]string{"Passenger 0"},
+		uuid.New(),
+		uuid.New(),
+	)
+	require.NoError(t, err)
+	require.NoError(t, repo.Add(ctx, *vb))
+
+	updates := 10
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= updates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := retryOnConcurrentModification(func() error {
+				_, err := repo.UpdateByID(ctx, vb.VipBundleID, func(vipBundle sagas.VipBundle) (sagas.VipBundle, error) {
+					vipBundle.Passengers = append(vipBundle.Passengers, fmt.Sprintf("Passenger %d", i))
+					return vipBundle, nil
+				})
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	updated, err := repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Len(t, updated.Passengers, 1+updates, "some updates were lost")
+
+	var version int
+	err = dbconn.Get(&version, "SELECT version FROM vip_bundles WHERE vip_bundle_id = $1", vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, 1+updates, version)
+}
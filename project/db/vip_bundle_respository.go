@@ -4,26 +4,39 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"tickets/entities"
-	"tickets/message/event"
 	"tickets/message/outbox"
 	"tickets/message/sagas"
+	"time"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type VipBundleRepository struct {
-	db *sqlx.DB
+	db               *sqlx.DB
+	outboxPartitions outbox.Partitions
+	newEventBus      func(message.Publisher) *cqrs.EventBus
 }
 
-func NewVipBundleRepository(db *sqlx.DB) *VipBundleRepository {
+func NewVipBundleRepository(
+	db *sqlx.DB,
+	outboxPartitions outbox.Partitions,
+	newEventBus func(message.Publisher) *cqrs.EventBus,
+) *VipBundleRepository {
 	if db == nil {
 		panic("db must be set")
 	}
+	if newEventBus == nil {
+		panic("newEventBus must be set")
+	}
 
-	return &VipBundleRepository{db: db}
+	return &VipBundleRepository{db: db, outboxPartitions: outboxPartitions, newEventBus: newEventBus}
 }
 
 type Executor interface {
@@ -42,7 +55,7 @@ func (v VipBundleRepository) Add(ctx context.Context, vipBundle sagas.VipBundle)
 		v.db,
 		sql.LevelRepeatableRead,
 		func(ctx context.Context, tx *sqlx.Tx) error {
-			_, err = v.db.ExecContext(ctx, `
+			_, err = tx.ExecContext(ctx, `
 				INSERT INTO vip_bundles (vip_bundle_id, booking_id, payload)
 				VALUES ($1, $2, $3)
 			`, vipBundle.VipBundleID, vipBundle.BookingID, payload)
@@ -51,14 +64,20 @@ func (v VipBundleRepository) Add(ctx context.Context, vipBundle sagas.VipBundle)
 				return fmt.Errorf("could not insert vip bundle: %w", err)
 			}
 
-			outboxPublisher, err := outbox.NewPublisherForDb(ctx, tx)
+			outboxPublisher, err := outbox.NewPublisherForDb(ctx, tx, v.outboxPartitions, vipBundle.VipBundleID.String())
 			if err != nil {
 				return fmt.Errorf("could not create event bus: %w", err)
 			}
 
-			err = event.NewBus(outboxPublisher).Publish(ctx, entities.VipBundleInitialized_v1{
-				Header:      entities.NewEventHeader(),
-				VipBundleID: vipBundle.VipBundleID,
+			err = v.newEventBus(outboxPublisher).Publish(ctx, entities.VipBundleInitialized_v1{
+				Header:          entities.NewEventHeader(),
+				VipBundleID:     vipBundle.VipBundleID,
+				BookingID:       vipBundle.BookingID,
+				CustomerEmail:   vipBundle.CustomerEmail,
+				NumberOfTickets: vipBundle.NumberOfTickets,
+				ShowID:          vipBundle.ShowId,
+				InboundFlightID: vipBundle.InboundFlightID,
+				ReturnFlightID:  vipBundle.ReturnFlightID,
 			})
 			if err != nil {
 				return fmt.Errorf("could not publish event: %w", err)
@@ -70,94 +89,88 @@ func (v VipBundleRepository) Add(ctx context.Context, vipBundle sagas.VipBundle)
 }
 
 func (v VipBundleRepository) Get(ctx context.Context, vipBundleID uuid.UUID) (sagas.VipBundle, error) {
-	return v.vipBundleByID(ctx, vipBundleID, v.db)
+	vb, _, err := v.getBy(ctx, "vip_bundle_id", vipBundleID, v.db)
+	return vb, err
 }
 
-func (v VipBundleRepository) vipBundleByID(ctx context.Context, vipBundleID uuid.UUID, db Executor) (sagas.VipBundle, error) {
-	var payload []byte
-	err := v.db.QueryRowContext(ctx, `
-		SELECT payload FROM vip_bundles WHERE vip_bundle_id = $1
-	`, vipBundleID).Scan(&payload)
+func (v VipBundleRepository) GetByBookingID(ctx context.Context, bookingID uuid.UUID) (sagas.VipBundle, error) {
+	vb, _, err := v.getBy(ctx, "booking_id", bookingID, v.db)
+	return vb, err
+}
 
-	if err != nil {
-		return sagas.VipBundle{}, fmt.Errorf("could not get vip bundle: %w", err)
+func (v VipBundleRepository) FindInStates(ctx context.Context, states []sagas.State, createdBefore time.Time) ([]sagas.StuckVipBundle, error) {
+	stateNames := make([]string, len(states))
+	for i, state := range states {
+		stateNames[i] = string(state)
 	}
 
-	var vipBundle sagas.VipBundle
-	err = json.Unmarshal(payload, &vipBundle)
+	var rows []struct {
+		Payload   []byte    `db:"payload"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	err := v.db.SelectContext(ctx, &rows, `
+		SELECT payload, created_at FROM vip_bundles
+		WHERE payload->>'state' = ANY($1) AND created_at < $2
+		ORDER BY created_at
+	`, pq.StringArray(stateNames), createdBefore)
 	if err != nil {
-		return sagas.VipBundle{}, fmt.Errorf("could not unmarshal vip bundle: %w", err)
+		return nil, fmt.Errorf("could not select vip bundles: %w", err)
 	}
 
-	return vipBundle, nil
-}
+	vbs := make([]sagas.StuckVipBundle, 0, len(rows))
+	for _, row := range rows {
+		var vb sagas.VipBundle
+		if err := json.Unmarshal(row.Payload, &vb); err != nil {
+			return nil, fmt.Errorf("could not unmarshal vip bundle: %w", err)
+		}
+		vbs = append(vbs, sagas.StuckVipBundle{VipBundle: vb, CreatedAt: row.CreatedAt})
+	}
 
-func (v VipBundleRepository) GetByBookingID(ctx context.Context, bookingID uuid.UUID) (sagas.VipBundle, error) {
-	return v.getByBookingID(ctx, bookingID, v.db)
+	return vbs, nil
 }
 
-func (v VipBundleRepository) getByBookingID(ctx context.Context, bookingID uuid.UUID, db Executor) (sagas.VipBundle, error) {
+// getBy returns the vip bundle with its version, column must be vip_bundle_id or booking_id.
+func (v VipBundleRepository) getBy(ctx context.Context, column string, id uuid.UUID, db Executor) (sagas.VipBundle, int, error) {
 	var payload []byte
+	var version int
 	err := db.QueryRowContext(ctx, `
-		SELECT payload FROM vip_bundles WHERE booking_id = $1
-	`, bookingID).Scan(&payload)
+		SELECT payload, version FROM vip_bundles WHERE `+column+` = $1
+	`, id).Scan(&payload, &version)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		return sagas.VipBundle{}, 0, fmt.Errorf("%w: %s", entities.ErrVipBundleNotFound, id)
+	}
 	if err != nil {
-		return sagas.VipBundle{}, fmt.Errorf("could not get vip bundle: %w", err)
+		return sagas.VipBundle{}, 0, fmt.Errorf("could not get vip bundle: %w", err)
 	}
 
 	var vipBundle sagas.VipBundle
 	err = json.Unmarshal(payload, &vipBundle)
 	if err != nil {
-		return sagas.VipBundle{}, fmt.Errorf("could not unmarshal vip bundle: %w", err)
+		return sagas.VipBundle{}, 0, fmt.Errorf("could not unmarshal vip bundle: %w", err)
 	}
 
-	return vipBundle, nil
+	return vipBundle, version, nil
 }
 
-func (v VipBundleRepository) UpdateByID(ctx context.Context, bookingID uuid.UUID, updateFn func(vipBundle sagas.VipBundle) (sagas.VipBundle, error)) (sagas.VipBundle, error) {
-	var vb sagas.VipBundle
-
-	err := updateInTx(ctx, v.db, sql.LevelSerializable, func(ctx context.Context, tx *sqlx.Tx) error {
-		var err error
-		vb, err = v.vipBundleByID(ctx, bookingID, tx)
-		if err != nil {
-			return err
-		}
-
-		vb, err = updateFn(vb)
-		if err != nil {
-			return err
-		}
-
-		payload, err := json.Marshal(vb)
-		if err != nil {
-			return fmt.Errorf("could not marshal vip bundle: %w", err)
-		}
-
-		_, err = tx.ExecContext(ctx, `
-			UPDATE vip_bundles SET payload = $1 WHERE vip_bundle_id = $2
-		`, payload, vb.VipBundleID)
-
-		if err != nil {
-			return fmt.Errorf("could not update vip bundle: %w", err)
-		}
-
-		return nil
-	})
-	if err != nil {
-		return sagas.VipBundle{}, fmt.Errorf("could not update vip bundle: %w", err)
-	}
-
-	return vb, nil
+func (v VipBundleRepository) UpdateByID(ctx context.Context, vipBundleID uuid.UUID, updateFn func(vipBundle sagas.VipBundle) (sagas.VipBundle, error)) (sagas.VipBundle, error) {
+	return v.update(ctx, "vip_bundle_id", vipBundleID, updateFn)
 }
 
-func (v VipBundleRepository) UpdateByBookingID(ctx context.Context, bookingID uuid.UUID, updateFn func(vipBundle sagas.VipBundle) (sagas.VipBundle, error)) (sagas.VipBundle, error) {
+// update is compare-and-swap: it fails with ErrConcurrentModification if the vip bundle
+// was modified after it was read.
+func (v VipBundleRepository) update(
+	ctx context.Context,
+	column string,
+	id uuid.UUID,
+	updateFn func(vipBundle sagas.VipBundle) (sagas.VipBundle, error),
+) (sagas.VipBundle, error) {
 	var vb sagas.VipBundle
 
-	err := updateInTx(ctx, v.db, sql.LevelSerializable, func(ctx context.Context, tx *sqlx.Tx) error {
+	err := updateInTx(ctx, v.db, sql.LevelReadCommitted, func(ctx context.Context, tx *sqlx.Tx) error {
 		var err error
-		vb, err = v.getByBookingID(ctx, bookingID, tx)
+		var version int
+		vb, version, err = v.getBy(ctx, column, id, tx)
 		if err != nil {
 			return err
 		}
@@ -172,15 +185,14 @@ func (v VipBundleRepository) UpdateByBookingID(ctx context.Context, bookingID uu
 			return fmt.Errorf("could not marshal vip bundle: %w", err)
 		}
 
-		_, err = tx.ExecContext(ctx, `
-			UPDATE vip_bundles SET payload = $1 WHERE booking_id = $2
-		`, payload, vb.BookingID)
-
+		res, err := tx.ExecContext(ctx, `
+			UPDATE vip_bundles SET payload = $1, version = version + 1 WHERE vip_bundle_id = $2 AND version = $3
+		`, payload, vb.VipBundleID, version)
 		if err != nil {
 			return fmt.Errorf("could not update vip bundle: %w", err)
 		}
 
-		return nil
+		return compareAndSwap(res, "vip bundle", vb.VipBundleID.String(), version)
 	})
 	if err != nil {
 		return sagas.VipBundle{}, fmt.Errorf("could not update vip bundle: %w", err)
@@ -28,6 +28,23 @@ type BookShowTickets struct {
 	ShowId          uuid.UUID `json:"show_id"`
 }
 
+// CancelBooking cancels the booking, its places are released for other bookings.
+type CancelBooking struct {
+	BookingID uuid.UUID `json:"booking_id"`
+	Reason    string    `json:"reason"`
+}
+
+// TransferBooking moves the booking to another customer.
+type TransferBooking struct {
+	BookingID     uuid.UUID `json:"booking_id"`
+	CustomerEmail string    `json:"customer_email"`
+}
+
+// RefundBooking refunds the booking, it must be canceled first.
+type RefundBooking struct {
+	BookingID uuid.UUID `json:"booking_id"`
+}
+
 type BookFlight struct {
 	CustomerEmail  string    `json:"customer_email"`
 	FlightID       uuid.UUID `json:"to_flight_id"`
@@ -46,4 +63,35 @@ type BookTaxi struct {
 
 type CancelFlightTickets struct {
 	FlightTicketIDs []uuid.UUID `json:"flight_ticket_id"`
+	IdempotencyKey  string      `json:"idempotency_key,omitempty"`
+}
+
+// BookHotel books a hotel night for the guests, for the night of the show.
+type BookHotel struct {
+	CustomerEmail  string    `json:"customer_email"`
+	GuestNames     []string  `json:"guest_names"`
+	ShowID         uuid.UUID `json:"show_id"`
+	ReferenceID    string    `json:"reference_id"`
+	IdempotencyKey string    `json:"idempotency_key"`
+}
+
+type CancelHotelBooking struct {
+	HotelBookingID uuid.UUID `json:"hotel_booking_id"`
+}
+
+type CancelTaxiBooking struct {
+	TaxiBookingID uuid.UUID `json:"taxi_booking_id"`
+}
+
+type CancelVipBundle struct {
+	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+}
+
+// TimeOutVipBundleStep is scheduled when a step of the VIP bundle is started, and sent
+// if the step didn't complete before its deadline.
+type TimeOutVipBundleStep struct {
+	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+	Step        string    `json:"step"`
+	// Attempt is the number of times the step was retried before it timed out.
+	Attempt int `json:"attempt"`
 }
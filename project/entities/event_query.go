@@ -0,0 +1,28 @@
+package entities
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidEventQuery = errors.New("invalid event query")
+
+// EventQuery filters events stored in the data lake. Empty fields don't filter.
+type EventQuery struct {
+	EventNames []string
+	// From is inclusive, To is exclusive.
+	From *time.Time
+	To   *time.Time
+
+	// aggregate keys, matched against the payload fields with the same JSON names
+	BookingID   string
+	TicketID    string
+	VipBundleID string
+
+	// JSONPath is a jsonpath predicate over the payload, for example `$.price.currency == "EUR"`.
+	JSONPath string
+
+	// After is the cursor of the last event from the previous page.
+	After string
+	Limit int
+}
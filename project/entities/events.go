@@ -114,24 +114,48 @@ func (i Event) IsInternal() bool {
 	return false
 }
 
+func (i Event) GetHeader() EventHeader {
+	return i.Header
+}
+
 func (i TicketBookingCanceled_v1) IsInternal() bool {
 	return false
 }
 
+func (i TicketBookingCanceled_v1) GetHeader() EventHeader {
+	return i.Header
+}
+
 func (i TicketBookingConfirmed_v1) IsInternal() bool {
 	return false
 }
 
+func (i TicketBookingConfirmed_v1) GetHeader() EventHeader {
+	return i.Header
+}
+
 func (i TicketPrinted_v1) IsInternal() bool {
 	return false
 }
+
+func (i TicketPrinted_v1) GetHeader() EventHeader {
+	return i.Header
+}
 func (i TicketReceiptIssued_v1) IsInternal() bool {
 	return false
 }
 
+func (i TicketReceiptIssued_v1) GetHeader() EventHeader {
+	return i.Header
+}
+
 func (i TicketRefunded_v1) IsInternal() bool {
 	return false
 }
+
+func (i TicketRefunded_v1) GetHeader() EventHeader {
+	return i.Header
+}
 func (i OpsTicket_v1) IsInternal() bool {
 	return false
 }
@@ -140,16 +164,32 @@ func (i BookingMade_v1) IsInternal() bool {
 	return false
 }
 
+func (i BookingMade_v1) GetHeader() EventHeader {
+	return i.Header
+}
+
 type VipBundleInitialized_v1 struct {
 	Header EventHeader `json:"header"`
 
 	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+
+	// the details were added later, so they are missing in older events
+	BookingID       uuid.UUID `json:"booking_id,omitempty"`
+	CustomerEmail   string    `json:"customer_email,omitempty"`
+	NumberOfTickets int       `json:"number_of_tickets,omitempty"`
+	ShowID          uuid.UUID `json:"show_id,omitempty"`
+	InboundFlightID uuid.UUID `json:"inbound_flight_id,omitempty"`
+	ReturnFlightID  uuid.UUID `json:"return_flight_id,omitempty"`
 }
 
 func (v VipBundleInitialized_v1) IsInternal() bool {
 	return false
 }
 
+func (v VipBundleInitialized_v1) GetHeader() EventHeader {
+	return v.Header
+}
+
 type BookingFailed_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -161,6 +201,58 @@ func (b BookingFailed_v1) IsInternal() bool {
 	return false
 }
 
+func (b BookingFailed_v1) GetHeader() EventHeader {
+	return b.Header
+}
+
+type BookingCanceled_v1 struct {
+	Header EventHeader `json:"header"`
+
+	BookingID       uuid.UUID `json:"booking_id"`
+	NumberOfTickets int       `json:"number_of_tickets"`
+	Reason          string    `json:"reason"`
+}
+
+func (b BookingCanceled_v1) IsInternal() bool {
+	return false
+}
+
+func (b BookingCanceled_v1) GetHeader() EventHeader {
+	return b.Header
+}
+
+type BookingTransferred_v1 struct {
+	Header EventHeader `json:"header"`
+
+	BookingID             uuid.UUID `json:"booking_id"`
+	PreviousCustomerEmail string    `json:"previous_customer_email"`
+	CustomerEmail         string    `json:"customer_email"`
+}
+
+func (b BookingTransferred_v1) IsInternal() bool {
+	return false
+}
+
+func (b BookingTransferred_v1) GetHeader() EventHeader {
+	return b.Header
+}
+
+type BookingRefunded_v1 struct {
+	Header EventHeader `json:"header"`
+
+	BookingID       uuid.UUID `json:"booking_id"`
+	NumberOfTickets int       `json:"number_of_tickets"`
+	CustomerEmail   string    `json:"customer_email"`
+}
+
+func (b BookingRefunded_v1) IsInternal() bool {
+	return false
+}
+
+func (b BookingRefunded_v1) GetHeader() EventHeader {
+	return b.Header
+}
+
 type FlightBooked_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -174,6 +266,10 @@ func (f FlightBooked_v1) IsInternal() bool {
 	return false
 }
 
+func (f FlightBooked_v1) GetHeader() EventHeader {
+	return f.Header
+}
+
 type FlightBookingFailed_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -187,6 +283,42 @@ func (f FlightBookingFailed_v1) IsInternal() bool {
 	return false
 }
 
+func (f FlightBookingFailed_v1) GetHeader() EventHeader {
+	return f.Header
+}
+
+type HotelBooked_v1 struct {
+	Header EventHeader `json:"header"`
+
+	HotelBookingID uuid.UUID `json:"hotel_booking_id"`
+
+	ReferenceID string `json:"reference_id"`
+}
+
+func (h HotelBooked_v1) IsInternal() bool {
+	return false
+}
+
+func (h HotelBooked_v1) GetHeader() EventHeader {
+	return h.Header
+}
+
+type HotelBookingFailed_v1 struct {
+	Header EventHeader `json:"header"`
+
+	FailureReason string `json:"failure_reason"`
+
+	ReferenceID string `json:"reference_id"`
+}
+
+func (h HotelBookingFailed_v1) IsInternal() bool {
+	return false
+}
+
+func (h HotelBookingFailed_v1) GetHeader() EventHeader {
+	return h.Header
+}
+
 type TaxiBooked_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -199,6 +331,10 @@ func (t TaxiBooked_v1) IsInternal() bool {
 	return false
 }
 
+func (t TaxiBooked_v1) GetHeader() EventHeader {
+	return t.Header
+}
+
 type VipBundleFinalized_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -209,6 +345,63 @@ func (v VipBundleFinalized_v1) IsInternal() bool {
 	return false
 }
 
+func (v VipBundleFinalized_v1) GetHeader() EventHeader {
+	return v.Header
+}
+
+// VipBundleCanceled_v1 is published when the customer canceled the VIP bundle and its steps were compensated.
+type VipBundleCanceled_v1 struct {
+	Header EventHeader `json:"header"`
+
+	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+}
+
+func (v VipBundleCanceled_v1) IsInternal() bool {
+	return false
+}
+
+func (v VipBundleCanceled_v1) GetHeader() EventHeader {
+	return v.Header
+}
+
+// VipBundleOperatorActionTaken_v1 records an action an operator took on a stuck VIP bundle.
+type VipBundleOperatorActionTaken_v1 struct {
+	Header EventHeader `json:"header"`
+
+	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+	Action      string    `json:"action"`
+	Operator    string    `json:"operator"`
+	Reason      string    `json:"reason,omitempty"`
+	FromState   string    `json:"from_state"`
+	ToState     string    `json:"to_state"`
+}
+
+func (v VipBundleOperatorActionTaken_v1) IsInternal() bool {
+	return false
+}
+
+func (v VipBundleOperatorActionTaken_v1) GetHeader() EventHeader {
+	return v.Header
+}
+
+// VipBundleStepTimedOut_v1 is published when a step of the VIP bundle didn't complete before its deadline.
+type VipBundleStepTimedOut_v1 struct {
+	Header EventHeader `json:"header"`
+
+	VipBundleID uuid.UUID `json:"vip_bundle_id"`
+	Step        string    `json:"step"`
+	// Attempt is the number of times the step was retried before it timed out.
+	Attempt int `json:"attempt"`
+}
+
+func (v VipBundleStepTimedOut_v1) IsInternal() bool {
+	return false
+}
+
+func (v VipBundleStepTimedOut_v1) GetHeader() EventHeader {
+	return v.Header
+}
+
 type TaxiBookingFailed_v1 struct {
 	Header EventHeader `json:"header"`
 
@@ -220,3 +413,7 @@ type TaxiBookingFailed_v1 struct {
 func (t TaxiBookingFailed_v1) IsInternal() bool {
 	return false
 }
+
+func (t TaxiBookingFailed_v1) GetHeader() EventHeader {
+	return t.Header
+}
@@ -0,0 +1,17 @@
+package entities
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoHotelRoomsAvailable = errors.New("no hotel rooms available")
+
+type BookHotelRequest struct {
+	CustomerEmail  string
+	GuestNames     []string
+	ShowID         uuid.UUID
+	ReferenceID    string
+	IdempotencyKey string
+}
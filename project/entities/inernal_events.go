@@ -11,3 +11,7 @@ type InternalOpsReadModelUpdated struct {
 func (i InternalOpsReadModelUpdated) IsInternal() bool {
 	return true
 }
+
+func (i InternalOpsReadModelUpdated) GetHeader() EventHeader {
+	return i.Header
+}
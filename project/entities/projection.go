@@ -0,0 +1,31 @@
+package entities
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrProjectionRebuildInProgress = errors.New("projection rebuild is already in progress")
+	ErrInvalidProjectionQuery      = errors.New("invalid projection query")
+)
+
+const (
+	ProjectionRebuildIdle     = "idle"
+	ProjectionRebuildRunning  = "running"
+	ProjectionRebuildFinished = "finished"
+)
+
+// ProjectionRebuildStatus is the progress of the last rebuild of a projection.
+type ProjectionRebuildStatus struct {
+	Projection      string         `json:"projection"`
+	Status          string         `json:"status"`
+	EventsTotal     int            `json:"events_total"`
+	EventsProcessed int            `json:"events_processed"`
+	EventsSkipped   int            `json:"events_skipped"`
+	UnknownEvents   map[string]int `json:"unknown_events"`
+	LastError       string         `json:"last_error,omitempty"`
+	StartedAt       *time.Time     `json:"started_at,omitempty"`
+	UpdatedAt       *time.Time     `json:"updated_at,omitempty"`
+	FinishedAt      *time.Time     `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,91 @@
+package entities
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrVipBundleNotFound = errors.New("vip bundle not found")
+
+const (
+	VipBundleStatusInProgress = "in_progress"
+	VipBundleStatusFinalized  = "finalized"
+	VipBundleStatusFailed     = "failed"
+	VipBundleStatusCanceled   = "canceled"
+	VipBundleStatusResolved   = "resolved"
+)
+
+// Actions operators take on stuck VIP bundles.
+const (
+	VipBundleOperatorActionResendStep    = "resend_step"
+	VipBundleOperatorActionForceRollback = "force_rollback"
+	VipBundleOperatorActionResolve       = "resolve"
+)
+
+// Steps of the VIP bundle timeline.
+const (
+	VipBundleStepInitialized         = "initialized"
+	VipBundleStepBookingMade         = "booking_made"
+	VipBundleStepBookingFailed       = "booking_failed"
+	VipBundleStepInboundFlightBooked = "inbound_flight_booked"
+	VipBundleStepReturnFlightBooked  = "return_flight_booked"
+	VipBundleStepFlightBookingFailed = "flight_booking_failed"
+	VipBundleStepHotelBooked         = "hotel_booked"
+	VipBundleStepHotelBookingFailed  = "hotel_booking_failed"
+	VipBundleStepTaxiBooked          = "taxi_booked"
+	VipBundleStepTaxiBookingFailed   = "taxi_booking_failed"
+	VipBundleStepFinalized           = "finalized"
+	VipBundleStepCanceled            = "canceled"
+	VipBundleStepOperatorAction      = "operator_action"
+)
+
+// VipBundle_v1 is the read model of a VIP bundle with the steps it went through.
+type VipBundle_v1 struct {
+	VipBundleID     uuid.UUID `json:"vip_bundle_id"`
+	BookingID       uuid.UUID `json:"booking_id"`
+	CustomerEmail   string    `json:"customer_email"`
+	NumberOfTickets int       `json:"number_of_tickets"`
+	ShowID          uuid.UUID `json:"show_id"`
+	InboundFlightID uuid.UUID `json:"inbound_flight_id"`
+	ReturnFlightID  uuid.UUID `json:"return_flight_id"`
+
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+
+	InitializedAt time.Time `json:"initialized_at"`
+	LastUpdate    time.Time `json:"last_update"`
+
+	Timeline []VipBundleStep_v1 `json:"timeline"`
+}
+
+type VipBundleStep_v1 struct {
+	Step string    `json:"step"`
+	At   time.Time `json:"at"`
+
+	FlightID       *uuid.UUID `json:"flight_id,omitempty"`
+	HotelBookingID *uuid.UUID `json:"hotel_booking_id,omitempty"`
+	TaxiBookingID  *uuid.UUID `json:"taxi_booking_id,omitempty"`
+	FailureReason  string     `json:"failure_reason,omitempty"`
+
+	OperatorAction string `json:"operator_action,omitempty"`
+	Operator       string `json:"operator,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type VipBundleFilter struct {
+	Status        string
+	Date          *time.Time
+	CustomerEmail string
+
+	// After is the cursor of the previous page.
+	After string
+	Limit int
+}
+
+type VipBundlesPage_v1 struct {
+	VipBundles []VipBundle_v1 `json:"vip_bundles"`
+	// NextCursor passed as the after parameter returns the next page, it's empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
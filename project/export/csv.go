@@ -0,0 +1,135 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"tickets/entities"
+	"tickets/schema"
+	"time"
+)
+
+var csvEventColumns = []string{"event_id", "published_at", "event_name"}
+
+// csvWriter writes events with the payload flattened to columns named by the JSON path, for example price.amount.
+// Columns are taken from the registered schema of the event, so all files of the event have the same header.
+// Arrays and maps are kept as JSON; payload fields missing in the schema are not exported.
+type csvWriter struct {
+	file           *partFile
+	out            *bufio.Writer
+	csv            *csv.Writer
+	payloadColumns []string
+}
+
+func newCSVWriter(path string, payloadColumns []string) (*csvWriter, error) {
+	file, err := createPartFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bufio.NewWriter(file)
+	w := &csvWriter{
+		file:           file,
+		out:            out,
+		csv:            csv.NewWriter(out),
+		payloadColumns: payloadColumns,
+	}
+
+	if err := w.csv.Write(append(append([]string{}, csvEventColumns...), payloadColumns...)); err != nil {
+		_ = file.Abort()
+		return nil, fmt.Errorf("could not write %s: %w", path, err)
+	}
+
+	return w, nil
+}
+
+func (w *csvWriter) Write(event entities.Event) error {
+	decoder := json.NewDecoder(bytes.NewReader(event.EventPayload))
+	decoder.UseNumber()
+
+	var payload any
+	if err := decoder.Decode(&payload); err != nil {
+		return fmt.Errorf("could not decode event %s: %w", event.EventID, err)
+	}
+
+	record := []string{event.EventID, event.PublishedAt.UTC().Format(time.RFC3339Nano), event.EventName}
+	for _, column := range w.payloadColumns {
+		value, err := csvValue(payload, strings.Split(column, "."))
+		if err != nil {
+			return fmt.Errorf("could not write %s of event %s: %w", column, event.EventID, err)
+		}
+		record = append(record, value)
+	}
+
+	if err := w.csv.Write(record); err != nil {
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+
+	return nil
+}
+
+func (w *csvWriter) Close() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		_ = w.file.Abort()
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+	if err := w.out.Flush(); err != nil {
+		_ = w.file.Abort()
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+
+	return w.file.Commit()
+}
+
+func (w *csvWriter) Abort() error {
+	return w.file.Abort()
+}
+
+// csvColumns are the JSON paths of the schema's properties, sorted. Objects without properties are one column.
+func csvColumns(s *schema.Schema) []string {
+	var columns []string
+
+	var walk func(prefix string, s *schema.Schema)
+	walk = func(prefix string, s *schema.Schema) {
+		if len(s.Properties) == 0 {
+			columns = append(columns, prefix)
+			return
+		}
+
+		for name, property := range s.Properties {
+			walk(strings.TrimPrefix(prefix+"."+name, "."), property)
+		}
+	}
+	walk("", s)
+
+	sort.Strings(columns)
+	return columns
+}
+
+func csvValue(payload any, path []string) (string, error) {
+	for _, key := range path {
+		object, ok := payload.(map[string]any)
+		if !ok {
+			return "", nil
+		}
+		payload = object[key]
+	}
+
+	switch v := payload.(type) {
+	case map[string]any, []any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
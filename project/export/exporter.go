@@ -0,0 +1,190 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"tickets/entities"
+	"tickets/schema"
+	"time"
+)
+
+type Format string
+
+const (
+	FormatParquet Format = "parquet"
+	FormatCSV     Format = "csv"
+)
+
+// Events are read in the order they were stored, so events stored late are exported by the next run.
+type Events interface {
+	QueryIngested(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error
+}
+
+// Schemas give the columns of CSV files.
+type Schemas interface {
+	Get(messageName string) (*schema.Schema, bool)
+}
+
+// HighWaterMarks stores the cursor of the last exported event of each export.
+type HighWaterMarks interface {
+	LastCursor(ctx context.Context, export string) (string, error)
+	SaveCursor(ctx context.Context, export string, cursor string, eventsExported int) error
+}
+
+type Config struct {
+	// Name identifies the export's high-water mark.
+	Name   string
+	Format Format
+	Dir    string
+
+	EventNames []string
+	From       *time.Time
+	To         *time.Time
+}
+
+type Result struct {
+	EventsExported int
+	FilesWritten   int
+}
+
+// Exporter streams data lake events to files partitioned by event name and day:
+// <dir>/event_name=<name>/date=<YYYY-MM-DD>/part-<first event ID>.<format>.
+//
+// Each run exports events stored after the stored high-water mark. Part files are named after their first event,
+// so a run repeated after a crash overwrites the files of the failed run instead of duplicating events.
+type Exporter struct {
+	events         Events
+	highWaterMarks HighWaterMarks
+	schemas        Schemas
+}
+
+func NewExporter(events Events, highWaterMarks HighWaterMarks, schemas Schemas) Exporter {
+	if events == nil {
+		panic("events is nil")
+	}
+	if highWaterMarks == nil {
+		panic("highWaterMarks is nil")
+	}
+	if schemas == nil {
+		panic("schemas is nil")
+	}
+
+	return Exporter{
+		events:         events,
+		highWaterMarks: highWaterMarks,
+		schemas:        schemas,
+	}
+}
+
+// partWriter writes events of one partition to a file.
+type partWriter interface {
+	Write(event entities.Event) error
+	// Close completes the file.
+	Close() error
+	// Abort removes the incomplete file.
+	Abort() error
+}
+
+func (e Exporter) Export(ctx context.Context, config Config) (Result, error) {
+	if config.Format != FormatParquet && config.Format != FormatCSV {
+		return Result{}, fmt.Errorf("unknown export format %q", config.Format)
+	}
+
+	after, err := e.highWaterMarks.LastCursor(ctx, config.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	var lastCursor string
+
+	// events come roughly ordered by time, so parts are closed once a later day begins;
+	// an event stored late (with an earlier day) starts a new part of its day
+	parts := map[string]partWriter{}
+	day := ""
+
+	closeParts := func() error {
+		var errs []error
+		for dir, part := range parts {
+			errs = append(errs, part.Close())
+			delete(parts, dir)
+		}
+		return errors.Join(errs...)
+	}
+
+	err = e.events.QueryIngested(
+		ctx,
+		entities.EventQuery{
+			EventNames: config.EventNames,
+			From:       config.From,
+			To:         config.To,
+			After:      after,
+		},
+		func(event entities.Event, cursor string) error {
+			eventDay := event.PublishedAt.UTC().Format(time.DateOnly)
+			if eventDay > day {
+				if err := closeParts(); err != nil {
+					return err
+				}
+				day = eventDay
+			}
+
+			dir := filepath.Join(config.Dir, "event_name="+event.EventName, "date="+eventDay)
+
+			part, ok := parts[dir]
+			if !ok {
+				var err error
+				part, err = e.newPartWriter(config.Format, dir, event)
+				if err != nil {
+					return err
+				}
+				parts[dir] = part
+				result.FilesWritten++
+			}
+
+			if err := part.Write(event); err != nil {
+				return err
+			}
+
+			lastCursor = cursor
+			result.EventsExported++
+			return nil
+		},
+	)
+	if err != nil {
+		for _, part := range parts {
+			_ = part.Abort()
+		}
+		return result, fmt.Errorf("could not export events: %w", err)
+	}
+
+	if err := closeParts(); err != nil {
+		return result, err
+	}
+
+	if result.EventsExported == 0 {
+		return result, nil
+	}
+
+	if err := e.highWaterMarks.SaveCursor(ctx, config.Name, lastCursor, result.EventsExported); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (e Exporter) newPartWriter(format Format, dir string, first entities.Event) (partWriter, error) {
+	path := filepath.Join(dir, "part-"+first.EventID+"."+string(format))
+
+	if format == FormatCSV {
+		s, ok := e.schemas.Get(first.EventName)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for %s", first.EventName)
+		}
+		return newCSVWriter(path, csvColumns(s))
+	}
+
+	return newParquetWriter(path)
+}
@@ -0,0 +1,227 @@
+package export_test
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"tickets/entities"
+	"tickets/export"
+	"tickets/schema"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// fakeEvents returns events in the order they were appended, which is the order they were stored in.
+type fakeEvents struct {
+	events []entities.Event
+}
+
+func (f *fakeEvents) QueryIngested(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error {
+	after := -1
+	if query.After != "" {
+		var err error
+		if after, err = strconv.Atoi(query.After); err != nil {
+			return err
+		}
+	}
+
+	for i, event := range f.events {
+		if i <= after {
+			continue
+		}
+		if len(query.EventNames) > 0 && !slices.Contains(query.EventNames, event.EventName) {
+			continue
+		}
+		if query.From != nil && event.PublishedAt.Before(*query.From) {
+			continue
+		}
+		if query.To != nil && !event.PublishedAt.Before(*query.To) {
+			continue
+		}
+		if err := fn(event, strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fakeHighWaterMarks map[string]string
+
+func (f fakeHighWaterMarks) LastCursor(ctx context.Context, export string) (string, error) {
+	return f[export], nil
+}
+
+func (f fakeHighWaterMarks) SaveCursor(ctx context.Context, export string, cursor string, eventsExported int) error {
+	f[export] = cursor
+	return nil
+}
+
+func newEvent(name string, publishedAt time.Time, payload string) entities.Event {
+	return entities.Event{
+		EventID:      uuid.NewString(),
+		PublishedAt:  publishedAt,
+		EventName:    name,
+		EventPayload: []byte(payload),
+	}
+}
+
+func newExporter(t *testing.T, events *fakeEvents, hwm fakeHighWaterMarks) export.Exporter {
+	t.Helper()
+
+	registry, err := schema.NewRegistry()
+	require.NoError(t, err)
+
+	return export.NewExporter(events, hwm, registry)
+}
+
+func TestExporter_csv(t *testing.T) {
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	events := &fakeEvents{events: []entities.Event{
+		newEvent("TicketBookingConfirmed_v1", day, `{"ticket_id": "1", "price": {"amount": "100", "currency": "EUR"}}`),
+		newEvent("TicketPrinted_v1", day, `{"ticket_id": "1", "file_name": "1.html"}`),
+		newEvent("TicketBookingConfirmed_v1", day.Add(time.Hour), `{"ticket_id": "2", "booking_id": "b", "price": {"amount": "50", "currency": "USD"}}`),
+	}}
+	hwm := fakeHighWaterMarks{}
+	dir := t.TempDir()
+
+	exporter := newExporter(t, events, hwm)
+	config := export.Config{Name: "csv", Format: export.FormatCSV, Dir: dir}
+
+	result, err := exporter.Export(context.Background(), config)
+	require.NoError(t, err)
+	// the payload with more fields continues the same file
+	assert.Equal(t, export.Result{EventsExported: 3, FilesWritten: 2}, result)
+
+	records := readCSV(t, filepath.Join(
+		dir, "event_name=TicketBookingConfirmed_v1", "date=2024-05-01", "part-"+events.events[0].EventID+".csv",
+	))
+	assert.Equal(t, []string{
+		"event_id", "published_at", "event_name",
+		"booking_id", "customer_email", "header.id", "header.idempotency_key", "header.published_at",
+		"price.amount", "price.currency", "ticket_id",
+	}, records[0])
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{"", "", "", "", "", "100", "EUR", "1"}, records[1][3:])
+	assert.Equal(t, []string{"b", "", "", "", "", "50", "USD", "2"}, records[2][3:])
+
+	// only new events are exported by the next run, including events stored late with an earlier time
+	events.events = append(events.events,
+		newEvent("TicketPrinted_v1", day.AddDate(0, 0, 1), `{"ticket_id": "2", "file_name": "2.html"}`),
+		newEvent("TicketPrinted_v1", day.Add(-time.Hour), `{"ticket_id": "0", "file_name": "0.html"}`),
+	)
+
+	result, err = exporter.Export(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, export.Result{EventsExported: 2, FilesWritten: 2}, result)
+	assert.DirExists(t, filepath.Join(dir, "event_name=TicketPrinted_v1", "date=2024-05-02"))
+
+	records = readCSV(t, filepath.Join(
+		dir, "event_name=TicketPrinted_v1", "date=2024-05-01", "part-"+events.events[4].EventID+".csv",
+	))
+	require.Len(t, records, 2)
+	assert.Equal(t, events.events[4].EventID, records[1][0])
+}
+
+func TestExporter_filters(t *testing.T) {
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	events := &fakeEvents{events: []entities.Event{
+		newEvent("TicketPrinted_v1", day.AddDate(0, 0, -1), `{"ticket_id": "0", "file_name": "0.html"}`),
+		newEvent("TicketPrinted_v1", day, `{"ticket_id": "1", "file_name": "1.html"}`),
+		newEvent("TicketBookingConfirmed_v1", day, `{"ticket_id": "1"}`),
+		newEvent("TicketPrinted_v1", day.AddDate(0, 0, 1), `{"ticket_id": "2", "file_name": "2.html"}`),
+	}}
+	dir := t.TempDir()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	result, err := newExporter(t, events, fakeHighWaterMarks{}).Export(context.Background(), export.Config{
+		Name:       "filtered",
+		Format:     export.FormatCSV,
+		Dir:        dir,
+		EventNames: []string{"TicketPrinted_v1"},
+		From:       &from,
+		To:         &to,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, export.Result{EventsExported: 1, FilesWritten: 1}, result)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "event_name=TicketPrinted_v1", "date=2024-05-01", "part-"+events.events[1].EventID+".csv"),
+	}, files)
+}
+
+// parquetEvent is read with the column names of the exported file.
+type parquetEvent struct {
+	EventID     string `parquet:"name=event_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PublishedAt int64  `parquet:"name=published_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	EventName   string `parquet:"name=event_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Payload     string `parquet:"name=payload, type=BYTE_ARRAY, convertedtype=JSON"`
+}
+
+func TestExporter_parquet(t *testing.T) {
+	publishedAt := time.Date(2024, 5, 1, 10, 0, 0, 123456000, time.UTC)
+	events := &fakeEvents{events: []entities.Event{
+		newEvent("TicketPrinted_v1", publishedAt, `{"ticket_id": "1", "file_name": "1.html"}`),
+		newEvent("TicketPrinted_v1", publishedAt.Add(time.Second), `{"ticket_id": "2", "file_name": "2.html"}`),
+	}}
+	dir := t.TempDir()
+
+	_, err := newExporter(t, events, fakeHighWaterMarks{}).Export(context.Background(), export.Config{
+		Name:   "parquet",
+		Format: export.FormatParquet,
+		Dir:    dir,
+	})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "event_name=TicketPrinted_v1", "*", "*.parquet"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	file, err := local.NewLocalFileReader(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(file, new(parquetEvent), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+
+	rows := make([]parquetEvent, pr.GetNumRows())
+	require.NoError(t, pr.Read(&rows))
+
+	expected := make([]parquetEvent, 0, len(events.events))
+	for _, event := range events.events {
+		expected = append(expected, parquetEvent{
+			EventID:     event.EventID,
+			PublishedAt: event.PublishedAt.UnixMicro(),
+			EventName:   event.EventName,
+			Payload:     string(event.EventPayload),
+		})
+	}
+	assert.Equal(t, expected, rows)
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	require.NoError(t, err)
+
+	return records
+}
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// partFile is written under a temporary name and renamed once complete,
+// so readers (and exports resumed after a crash) never see partial files.
+type partFile struct {
+	*os.File
+	path string
+}
+
+func createPartFile(path string) (*partFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create directory for %s: %w", path, err)
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s: %w", path, err)
+	}
+
+	return &partFile{File: f, path: path}, nil
+}
+
+func (f *partFile) Commit() error {
+	if err := f.File.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %w", f.path, err)
+	}
+
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return fmt.Errorf("could not rename %s: %w", f.path, err)
+	}
+
+	return nil
+}
+
+func (f *partFile) Abort() error {
+	closeErr := f.File.Close()
+	if errors.Is(closeErr, os.ErrClosed) {
+		closeErr = nil
+	}
+
+	return errors.Join(closeErr, os.Remove(f.Name()))
+}
@@ -0,0 +1,81 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"tickets/entities"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize bounds the size of events kept in memory, in bytes.
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+// parquetEvent is the row of the Parquet file. The payload is kept as a JSON column.
+type parquetEvent struct {
+	EventID     string `parquet:"name=event_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PublishedAt int64  `parquet:"name=published_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	EventName   string `parquet:"name=event_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Payload     string `parquet:"name=payload, type=BYTE_ARRAY, convertedtype=JSON"`
+}
+
+// parquetWriter writes events as Snappy compressed Parquet.
+type parquetWriter struct {
+	file    *partFile
+	out     *bufio.Writer
+	parquet *writer.ParquetWriter
+}
+
+func newParquetWriter(path string) (*parquetWriter, error) {
+	file, err := createPartFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bufio.NewWriter(file)
+	pw, err := writer.NewParquetWriterFromWriter(out, new(parquetEvent), 1)
+	if err != nil {
+		_ = file.Abort()
+		return nil, fmt.Errorf("could not create parquet writer for %s: %w", path, err)
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetWriter{
+		file:    file,
+		out:     out,
+		parquet: pw,
+	}, nil
+}
+
+func (w *parquetWriter) Write(event entities.Event) error {
+	err := w.parquet.Write(parquetEvent{
+		EventID:     event.EventID,
+		PublishedAt: event.PublishedAt.UnixMicro(),
+		EventName:   event.EventName,
+		Payload:     string(event.EventPayload),
+	})
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+
+	return nil
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.parquet.WriteStop(); err != nil {
+		_ = w.file.Abort()
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+	if err := w.out.Flush(); err != nil {
+		_ = w.file.Abort()
+		return fmt.Errorf("could not write %s: %w", w.file.path, err)
+	}
+
+	return w.file.Commit()
+}
+
+func (w *parquetWriter) Abort() error {
+	return w.file.Abort()
+}
@@ -3,21 +3,36 @@ package http
 import (
 	"context"
 	"tickets/entities"
+	"tickets/message/outbox"
 	"tickets/message/sagas"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/google/uuid"
 )
 
 type Handler struct {
-	eventBus              *cqrs.EventBus
-	cmdBus                *cqrs.CommandBus
+	unitOfWork            UnitOfWork
 	spreadsheetsAPIClient SpreadsheetsAPI
 	ticketRepo            TicketRepository
 	showRepo              ShowRepository
 	bookingRepo           BookingRespository
 	opsBookingRepo        OpsBookingRepository
 	vipBundleRepo         VipBundleRepository
+	outboxInspector       OutboxInspector
+	projectionRebuilder   ProjectionRebuilder
+	eventRepo             EventRepository
+	opsVipBundleRepo      OpsVipBundleRepository
+	vipBundleOperator     VipBundleOperator
+}
+
+// UnitOfWork publishes events and sends commands through the outbox, atomically with the DB writes done in fn.
+type UnitOfWork interface {
+	Do(
+		ctx context.Context,
+		partitionKey string,
+		fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+	) error
 }
 
 type SpreadsheetsAPI interface {
@@ -39,9 +54,38 @@ type BookingRespository interface {
 
 type VipBundleRepository interface {
 	Add(ctx context.Context, vipBundle sagas.VipBundle) error
+	Get(ctx context.Context, vipBundleID uuid.UUID) (sagas.VipBundle, error)
 }
 
 type OpsBookingRepository interface {
 	GetAll(ctx context.Context, query *string) ([]entities.OpsBooking_v1, error)
 	GetByID(ctx context.Context, bookingID string) (entities.OpsBooking_v1, error)
 }
+
+type OpsVipBundleRepository interface {
+	GetAll(ctx context.Context, filter entities.VipBundleFilter) (entities.VipBundlesPage_v1, error)
+	GetByID(ctx context.Context, vipBundleID string) (entities.VipBundle_v1, error)
+}
+
+// VipBundleOperator runs operator actions on stuck VIP bundles, each recorded as an audit event.
+type VipBundleOperator interface {
+	Stuck(ctx context.Context, olderThan time.Duration) ([]sagas.StuckVipBundle, error)
+	ResendStep(ctx context.Context, cmd sagas.ResendVipBundleStep) error
+	ForceRollback(ctx context.Context, cmd sagas.ForceVipBundleRollback) error
+	Resolve(ctx context.Context, cmd sagas.ResolveVipBundle) error
+}
+
+type OutboxInspector interface {
+	Stats(ctx context.Context) (outbox.Stats, error)
+	Pending(ctx context.Context, limit int) ([]outbox.PendingMessage, error)
+}
+
+type EventRepository interface {
+	Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error
+}
+
+type ProjectionRebuilder interface {
+	Projections() []string
+	StartRebuild(name string) error
+	Status(ctx context.Context, name string) (entities.ProjectionRebuildStatus, error)
+}
@@ -1,9 +1,12 @@
 package http
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"tickets/entities"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -29,3 +32,62 @@ func (h *Handler) PostBookTickets(c echo.Context) error {
 
 	return c.JSON(http.StatusCreated, bookResp)
 }
+
+type bookingCancelRequest struct {
+	Reason string `json:"reason"`
+}
+
+type bookingTransferRequest struct {
+	CustomerEmail string `json:"customer_email"`
+}
+
+// PostBookingCancel cancels the booking asynchronously, the decision is made by replaying the booking stream.
+func (h *Handler) PostBookingCancel(c echo.Context) error {
+	var request bookingCancelRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	return h.sendBookingCommand(c, func(bookingID uuid.UUID) any {
+		return entities.CancelBooking{BookingID: bookingID, Reason: request.Reason}
+	})
+}
+
+func (h *Handler) PostBookingTransfer(c echo.Context) error {
+	var request bookingTransferRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if request.CustomerEmail == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "customer_email is required")
+	}
+
+	return h.sendBookingCommand(c, func(bookingID uuid.UUID) any {
+		return entities.TransferBooking{BookingID: bookingID, CustomerEmail: request.CustomerEmail}
+	})
+}
+
+func (h *Handler) PostBookingRefund(c echo.Context) error {
+	return h.sendBookingCommand(c, func(bookingID uuid.UUID) any {
+		return entities.RefundBooking{BookingID: bookingID}
+	})
+}
+
+// sendBookingCommand sends the command for the booking through the outbox, partitioned by the booking ID,
+// so decisions on one booking are made in the order they were requested.
+func (h *Handler) sendBookingCommand(c echo.Context, command func(bookingID uuid.UUID) any) error {
+	bookingID, err := uuid.Parse(c.Param("booking_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid booking id")
+	}
+
+	cmd := command(bookingID)
+	err = h.unitOfWork.Do(c.Request().Context(), bookingID.String(), func(ctx context.Context, _ *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+		return commandBus.Send(ctx, cmd)
+	})
+	if err != nil {
+		return fmt.Errorf("failed sending %s command: %w", cqrs.StructName(cmd), err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
@@ -1,10 +1,12 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"tickets/entities"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/labstack/echo/v4"
 )
 
@@ -41,8 +43,10 @@ func (h Handler) PostTicketsStatus(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key header is required")
 	}
 	for _, ticket := range request.Tickets {
-		if ticket.Status == "confirmed" {
-			event := entities.TicketBookingConfirmed_v1{
+		var event any
+		switch ticket.Status {
+		case "confirmed":
+			event = entities.TicketBookingConfirmed_v1{
 				Header: entities.NewEventHeaderWithIdempotencyKey(idempotencyKey + ticket.TicketID),
 
 				TicketID:      ticket.TicketID,
@@ -51,24 +55,23 @@ func (h Handler) PostTicketsStatus(c echo.Context) error {
 
 				BookingID: ticket.BookingID,
 			}
-
-			if err := h.eventBus.Publish(c.Request().Context(), event); err != nil {
-				return fmt.Errorf("failed to publish TicketBookingConfirmed event: %w", err)
-			}
-		} else if ticket.Status == "canceled" {
-			event := entities.TicketBookingCanceled_v1{
+		case "canceled":
+			event = entities.TicketBookingCanceled_v1{
 				Header:        entities.NewEventHeaderWithIdempotencyKey(idempotencyKey + ticket.TicketID),
 				TicketID:      ticket.TicketID,
 				CustomerEmail: ticket.CustomerEmail,
 				Price:         ticket.Price,
 			}
-
-			if err := h.eventBus.Publish(c.Request().Context(), event); err != nil {
-				return fmt.Errorf("failed to publish TicketBookingCanceled event: %w", err)
-			}
-		} else {
+		default:
 			return fmt.Errorf("unknown ticket status: %s", ticket.Status)
 		}
+
+		err := h.unitOfWork.Do(c.Request().Context(), ticket.TicketID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+			return eventBus.Publish(ctx, event)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to publish %T event: %w", event, err)
+		}
 	}
 
 	return c.NoContent(http.StatusOK)
@@ -85,7 +88,10 @@ func (h *Handler) PutTicketRefund(c echo.Context) error {
 		TicketID: ticketId,
 	}
 
-	if err := h.cmdBus.Send(c.Request().Context(), cmd); err != nil {
+	err := h.unitOfWork.Do(c.Request().Context(), ticketId, func(ctx context.Context, _ *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+		return commandBus.Send(ctx, cmd)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to send ticket refunded command: %w", err)
 	}
 	return c.NoContent(http.StatusAccepted)
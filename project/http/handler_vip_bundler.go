@@ -1,9 +1,14 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"tickets/entities"
 	"tickets/message/sagas"
 
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -42,8 +47,7 @@ func (h *Handler) PostVipBundler(c echo.Context) error {
 		Passengers:      request.Passengers,
 		InboundFlightID: request.InboundFlightId,
 		ReturnFlightID:  request.ReturnFlightId,
-		IsFinalized:     false,
-		Failed:          false,
+		Process:         sagas.VipBundleStateMachine.Start(),
 	}
 
 	if err := h.vipBundleRepo.Add(c.Request().Context(), vb); err != nil {
@@ -55,3 +59,58 @@ func (h *Handler) PostVipBundler(c echo.Context) error {
 		VipBundleId: vb.VipBundleID,
 	})
 }
+
+func (h *Handler) GetVipBundle(c echo.Context) error {
+	vipBundleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid vip bundle id")
+	}
+
+	vb, err := h.opsVipBundleRepo.GetByID(c.Request().Context(), vipBundleID.String())
+	if errors.Is(err, entities.ErrVipBundleNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "vip bundle not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed getting vip bundle %s: %w", vipBundleID, err)
+	}
+
+	return c.JSON(http.StatusOK, vb)
+}
+
+// PostVipBundleCancel cancels the VIP bundle asynchronously: steps completed so far are compensated,
+// and steps in progress are compensated when they complete.
+func (h *Handler) PostVipBundleCancel(c echo.Context) error {
+	vipBundleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid vip bundle id")
+	}
+
+	vb, err := h.vipBundleRepo.Get(c.Request().Context(), vipBundleID)
+	if errors.Is(err, entities.ErrVipBundleNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "vip bundle not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed getting vip bundle %s: %w", vipBundleID, err)
+	}
+
+	if vb.State == sagas.VipBundleCanceled {
+		return c.NoContent(http.StatusAccepted)
+	}
+	if vb.State == sagas.VipBundleFailed {
+		return echo.NewHTTPError(http.StatusConflict, "vip bundle already failed and was rolled back")
+	}
+	if vb.State == sagas.VipBundleResolved {
+		return echo.NewHTTPError(http.StatusConflict, "vip bundle was resolved by an operator")
+	}
+
+	err = h.unitOfWork.Do(c.Request().Context(), vipBundleID.String(), func(ctx context.Context, _ *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+		return commandBus.Send(ctx, entities.CancelVipBundle{
+			VipBundleID: vipBundleID,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed sending CancelVipBundle command: %w", err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
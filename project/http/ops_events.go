@@ -0,0 +1,129 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tickets/entities"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+type eventResponse struct {
+	// Cursor passed as the after parameter returns events following this one.
+	Cursor      string          `json:"cursor"`
+	EventID     string          `json:"event_id"`
+	PublishedAt time.Time       `json:"published_at"`
+	EventName   string          `json:"event_name"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// GetEvents streams data lake events as newline delimited JSON.
+// A page shorter than the limit is the last one.
+func (h *Handler) GetEvents(c echo.Context) error {
+	query, err := eventQueryFromRequest(c)
+	if err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	encoder := json.NewEncoder(resp)
+
+	// the status is sent with the first event, so errors of an invalid query are still returned as errors
+	streaming := false
+	startStreaming := func() {
+		resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		resp.WriteHeader(http.StatusOK)
+		streaming = true
+	}
+
+	err = h.eventRepo.Query(c.Request().Context(), query, func(event entities.Event, cursor string) error {
+		if !streaming {
+			startStreaming()
+		}
+
+		err := encoder.Encode(eventResponse{
+			Cursor:      cursor,
+			EventID:     event.EventID,
+			PublishedAt: event.PublishedAt,
+			EventName:   event.EventName,
+			Payload:     event.EventPayload,
+		})
+		if err != nil {
+			return fmt.Errorf("could not write event %s: %w", event.EventID, err)
+		}
+		resp.Flush()
+
+		return nil
+	})
+	if errors.Is(err, entities.ErrInvalidEventQuery) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil && streaming {
+		// the response was partially sent already, the client sees it cut short
+		log.FromContext(c.Request().Context()).WithError(err).Error("Could not stream events")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed querying events: %w", err)
+	}
+
+	if !streaming {
+		startStreaming()
+	}
+
+	return nil
+}
+
+func eventQueryFromRequest(c echo.Context) (entities.EventQuery, error) {
+	query := entities.EventQuery{
+		EventNames:  c.QueryParams()["event_name"],
+		BookingID:   c.QueryParam("booking_id"),
+		TicketID:    c.QueryParam("ticket_id"),
+		VipBundleID: c.QueryParam("vip_bundle_id"),
+		JSONPath:    c.QueryParam("jsonpath"),
+		After:       c.QueryParam("after"),
+		Limit:       defaultEventsLimit,
+	}
+
+	var err error
+	if query.From, err = timeQueryParam(c, "from"); err != nil {
+		return query, err
+	}
+	if query.To, err = timeQueryParam(c, "to"); err != nil {
+		return query, err
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxEventsLimit {
+			return query, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", maxEventsLimit))
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+func timeQueryParam(c echo.Context, name string) (*time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, name+" must be a RFC3339 time")
+	}
+
+	return &t, nil
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"tickets/message/outbox"
+
+	"github.com/labstack/echo/v4"
+)
+
+type outboxResponse struct {
+	Stats   outbox.Stats            `json:"stats"`
+	Pending []outbox.PendingMessage `json:"pending"`
+}
+
+func (h *Handler) GetOutbox(c echo.Context) error {
+	limit := 100
+	if l := c.QueryParam("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+
+	stats, err := h.outboxInspector.Stats(c.Request().Context())
+	if err != nil {
+		return fmt.Errorf("failed getting outbox stats: %w", err)
+	}
+
+	pending, err := h.outboxInspector.Pending(c.Request().Context(), limit)
+	if err != nil {
+		return fmt.Errorf("failed getting pending outbox messages: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, outboxResponse{
+		Stats:   stats,
+		Pending: pending,
+	})
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"tickets/entities"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) GetProjections(c echo.Context) error {
+	statuses := make([]entities.ProjectionRebuildStatus, 0)
+	for _, name := range h.projectionRebuilder.Projections() {
+		status, err := h.projectionRebuilder.Status(c.Request().Context(), name)
+		if err != nil {
+			return fmt.Errorf("failed getting projection %s rebuild status: %w", name, err)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return c.JSON(http.StatusOK, statuses)
+}
+
+func (h *Handler) GetProjection(c echo.Context) error {
+	name := c.Param("name")
+	if !slices.Contains(h.projectionRebuilder.Projections(), name) {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown projection")
+	}
+
+	status, err := h.projectionRebuilder.Status(c.Request().Context(), name)
+	if err != nil {
+		return fmt.Errorf("failed getting projection %s rebuild status: %w", name, err)
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// PostProjectionRebuild starts (or resumes) the rebuild in the background. Its progress is returned by GetProjection.
+func (h *Handler) PostProjectionRebuild(c echo.Context) error {
+	name := c.Param("name")
+	if !slices.Contains(h.projectionRebuilder.Projections(), name) {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown projection")
+	}
+
+	err := h.projectionRebuilder.StartRebuild(name)
+	if errors.Is(err, entities.ErrProjectionRebuildInProgress) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed starting projection %s rebuild: %w", name, err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
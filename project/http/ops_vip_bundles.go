@@ -0,0 +1,156 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"tickets/entities"
+	"tickets/message/sagas"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultVipBundlesLimit = 100
+	maxVipBundlesLimit     = 1000
+)
+
+var vipBundleStatuses = []string{
+	entities.VipBundleStatusInProgress,
+	entities.VipBundleStatusFinalized,
+	entities.VipBundleStatusFailed,
+	entities.VipBundleStatusCanceled,
+	entities.VipBundleStatusResolved,
+}
+
+// GetOpsVipBundles lists a page of VIP bundles, optionally filtered by status, the date they were initialized
+// and customer email. The next page is returned for the next_cursor of the page passed as after.
+func (h *Handler) GetOpsVipBundles(c echo.Context) error {
+	filter := entities.VipBundleFilter{
+		Status:        c.QueryParam("status"),
+		CustomerEmail: c.QueryParam("customer_email"),
+		After:         c.QueryParam("after"),
+		Limit:         defaultVipBundlesLimit,
+	}
+
+	if filter.Status != "" && !slices.Contains(vipBundleStatuses, filter.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid status, expected one of %v", vipBundleStatuses))
+	}
+
+	if date := c.QueryParam("date"); date != "" {
+		d, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid date format, expected YYYY-MM-DD")
+		}
+		filter.Date = &d
+	}
+
+	if l := c.QueryParam("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxVipBundlesLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", maxVipBundlesLimit))
+		}
+		filter.Limit = limit
+	}
+
+	vipBundles, err := h.opsVipBundleRepo.GetAll(c.Request().Context(), filter)
+	if errors.Is(err, entities.ErrInvalidProjectionQuery) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed getting vip bundles: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, vipBundles)
+}
+
+// GetOpsStuckVipBundles lists VIP bundles not in a final state older_than_minutes after they were created (30 by default).
+func (h *Handler) GetOpsStuckVipBundles(c echo.Context) error {
+	olderThan := 30 * time.Minute
+	if minutes := c.QueryParam("older_than_minutes"); minutes != "" {
+		m, err := strconv.Atoi(minutes)
+		if err != nil || m < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid older_than_minutes, expected a non-negative number")
+		}
+		olderThan = time.Duration(m) * time.Minute
+	}
+
+	vipBundles, err := h.vipBundleOperator.Stuck(c.Request().Context(), olderThan)
+	if err != nil {
+		return fmt.Errorf("failed getting stuck vip bundles: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, vipBundles)
+}
+
+type vipBundleOperatorActionRequest struct {
+	Operator string `json:"operator"`
+	Reason   string `json:"reason"`
+}
+
+// PostOpsVipBundleResendStep sends the command of the step the VIP bundle waits for again.
+func (h *Handler) PostOpsVipBundleResendStep(c echo.Context) error {
+	return h.vipBundleOperatorAction(c, func(id uuid.UUID, request vipBundleOperatorActionRequest) error {
+		return h.vipBundleOperator.ResendStep(c.Request().Context(), sagas.ResendVipBundleStep{
+			VipBundleID: id,
+			Operator:    request.Operator,
+			Reason:      request.Reason,
+		})
+	})
+}
+
+// PostOpsVipBundleForceRollback fails the VIP bundle and compensates its steps.
+func (h *Handler) PostOpsVipBundleForceRollback(c echo.Context) error {
+	return h.vipBundleOperatorAction(c, func(id uuid.UUID, request vipBundleOperatorActionRequest) error {
+		return h.vipBundleOperator.ForceRollback(c.Request().Context(), sagas.ForceVipBundleRollback{
+			VipBundleID: id,
+			Operator:    request.Operator,
+			Reason:      request.Reason,
+		})
+	})
+}
+
+// PostOpsVipBundleResolve marks the VIP bundle as resolved by hand, nothing is compensated.
+func (h *Handler) PostOpsVipBundleResolve(c echo.Context) error {
+	return h.vipBundleOperatorAction(c, func(id uuid.UUID, request vipBundleOperatorActionRequest) error {
+		return h.vipBundleOperator.Resolve(c.Request().Context(), sagas.ResolveVipBundle{
+			VipBundleID: id,
+			Operator:    request.Operator,
+			Reason:      request.Reason,
+		})
+	})
+}
+
+func (h *Handler) vipBundleOperatorAction(c echo.Context, action func(id uuid.UUID, request vipBundleOperatorActionRequest) error) error {
+	vipBundleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid vip bundle id")
+	}
+
+	var request vipBundleOperatorActionRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if request.Operator == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "operator is required")
+	}
+
+	err = action(vipBundleID, request)
+	if errors.Is(err, entities.ErrVipBundleNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "vip bundle not found")
+	}
+	var illegal sagas.IllegalTransitionError
+	if errors.As(err, &illegal) {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("action not allowed in state %s", illegal.State))
+	}
+	if err != nil {
+		return fmt.Errorf("failed running operator action on vip bundle %s: %w", vipBundleID, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
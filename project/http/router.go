@@ -4,21 +4,24 @@ import (
 	"net/http"
 
 	libHttp "github.com/ThreeDotsLabs/go-event-driven/common/http"
-	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
 func NewHttpRouter(
-	eventBus *cqrs.EventBus,
-	cmdBus *cqrs.CommandBus,
+	unitOfWork UnitOfWork,
 	spreadsheetsAPIClient SpreadsheetsAPI,
 	ticketRepo TicketRepository,
 	showRepo ShowRepository,
 	bookingRepo BookingRespository,
 	opsBookingRepo OpsBookingRepository,
 	vipBundleRepo VipBundleRepository,
+	outboxInspector OutboxInspector,
+	projectionRebuilder ProjectionRebuilder,
+	eventRepo EventRepository,
+	opsVipBundleRepo OpsVipBundleRepository,
+	vipBundleOperator VipBundleOperator,
 ) *echo.Echo {
 	e := libHttp.NewEcho()
 	e.Use(otelecho.Middleware("tickets"))
@@ -29,24 +32,43 @@ func NewHttpRouter(
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 
 	handler := Handler{
-		eventBus:              eventBus,
-		cmdBus:                cmdBus,
+		unitOfWork:            unitOfWork,
 		spreadsheetsAPIClient: spreadsheetsAPIClient,
 		ticketRepo:            ticketRepo,
 		showRepo:              showRepo,
 		bookingRepo:           bookingRepo,
 		opsBookingRepo:        opsBookingRepo,
 		vipBundleRepo:         vipBundleRepo,
+		outboxInspector:       outboxInspector,
+		projectionRebuilder:   projectionRebuilder,
+		eventRepo:             eventRepo,
+		opsVipBundleRepo:      opsVipBundleRepo,
+		vipBundleOperator:     vipBundleOperator,
 	}
 
 	e.POST("/tickets-status", handler.PostTicketsStatus)
 	e.POST("/book-vip-bundle", handler.PostVipBundler)
+	e.GET("/vip-bundles/:id", handler.GetVipBundle)
+	e.POST("/vip-bundles/:id/cancel", handler.PostVipBundleCancel)
 	e.POST("/book-tickets", handler.PostBookTickets)
+	e.POST("/bookings/:booking_id/cancel", handler.PostBookingCancel)
+	e.POST("/bookings/:booking_id/transfer", handler.PostBookingTransfer)
+	e.POST("/bookings/:booking_id/refund", handler.PostBookingRefund)
 	e.PUT("/ticket-refund/:ticket_id", handler.PutTicketRefund)
 	e.POST("/shows", handler.PostShows)
 	e.GET("/tickets", handler.GetTickets)
 	e.GET("/ops/bookings", handler.GetBookings)
 	e.GET("/ops/bookings/:id", handler.GetBookingsByID)
+	e.GET("/ops/vip-bundles", handler.GetOpsVipBundles)
+	e.GET("/ops/vip-bundles/stuck", handler.GetOpsStuckVipBundles)
+	e.POST("/ops/vip-bundles/:id/resend-step", handler.PostOpsVipBundleResendStep)
+	e.POST("/ops/vip-bundles/:id/force-rollback", handler.PostOpsVipBundleForceRollback)
+	e.POST("/ops/vip-bundles/:id/resolve", handler.PostOpsVipBundleResolve)
+	e.GET("/ops/outbox", handler.GetOutbox)
+	e.GET("/ops/events", handler.GetEvents)
+	e.GET("/ops/projections", handler.GetProjections)
+	e.GET("/ops/projections/:name", handler.GetProjection)
+	e.POST("/ops/projections/:name/rebuild", handler.PostProjectionRebuild)
 
 	return e
 }
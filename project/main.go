@@ -6,13 +6,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"tickets/api"
+	"tickets/archive"
 	"tickets/db"
 	"tickets/message"
+	"tickets/message/command"
+	"tickets/message/outbox"
+	"tickets/message/sagas"
+	"tickets/message/scheduler"
 	"tickets/service"
+	"time"
 
 	"github.com/ThreeDotsLabs/go-event-driven/common/clients"
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
@@ -20,9 +30,30 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	app := &cli.App{
+		Name:   "tickets",
+		Usage:  "Run the tickets service",
+		Action: runService,
+		Commands: []*cli.Command{
+			outboxCommand,
+			schemasCommand,
+			projectionsCommand,
+			dataLakeCommand,
+			sagasCommand,
+		},
+	}
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		panic(err)
+	}
+}
+
+func runService(c *cli.Context) error {
+	ctx := c.Context
+
 	database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	database.MigrateSchema()
 	defer database.Close()
@@ -42,12 +73,17 @@ func main() {
 		traceHttpClient,
 	)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	redisClient := message.NewRedisClient(os.Getenv("REDIS_ADDR"))
 	defer redisClient.Close()
 
+	transport, err := newTransport(ctx, redisClient)
+	if err != nil {
+		return err
+	}
+
 	spreadsheetsService := api.NewSpreadsheetsAPIClient(apiClients)
 	receiptsService := api.NewReceiptsServiceClient(apiClients)
 	fileService := api.NewFileServiceClient(apiClients)
@@ -55,8 +91,49 @@ func main() {
 	transportationService := api.NewTransportationClient(apiClients)
 	paymentsService := api.NewPaymentsServiceClient(apiClients)
 
-	err = service.New(
-		redisClient,
+	outboxRetention, err := durationFromEnv("OUTBOX_RETENTION", 24*time.Hour)
+	if err != nil {
+		return err
+	}
+	outboxPartitions, err := intFromEnv("OUTBOX_PARTITIONS", 1)
+	if err != nil {
+		return err
+	}
+
+	vipBundleStepTimeout, err := durationFromEnv("VIP_BUNDLE_STEP_TIMEOUT", 10*time.Minute)
+	if err != nil {
+		return err
+	}
+	vipBundleStepMaxRetries, err := intFromEnv("VIP_BUNDLE_STEP_MAX_RETRIES", 2)
+	if err != nil {
+		return err
+	}
+	schedulerPollInterval, err := durationFromEnv("SCHEDULER_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return err
+	}
+
+	return service.New(
+		service.Config{
+			Transport: transport,
+			Outbox: outbox.Config{
+				Partitions: outbox.Partitions(outboxPartitions),
+				Retention: outbox.RetentionConfig{
+					Retention: outboxRetention,
+					Interval:  time.Minute,
+				},
+			},
+			// payloads are validated against registered schemas everywhere except production
+			ValidateSchemas: os.Getenv("ENVIRONMENT") != "production",
+			// comma separated topics to which messages are published as protobuf, for example "events,commands.BookFlight"
+			ProtobufTopics: listFromEnv("PROTOBUF_TOPICS"),
+			ColdStorage:    coldStorageFromEnv(),
+			VipBundleStepTimeouts: sagas.StepTimeoutsConfig{
+				Timeout:    vipBundleStepTimeout,
+				MaxRetries: vipBundleStepMaxRetries,
+			},
+			Scheduler: scheduler.Config{PollInterval: schedulerPollInterval},
+		},
 		spreadsheetsService,
 		receiptsService,
 		fileService,
@@ -64,8 +141,93 @@ func main() {
 		deadNotionService,
 		transportationService,
 		paymentsService,
+		hotelServiceFromEnv(),
 	).Run(ctx)
+}
+
+// hotelBookingEnabled is false in production, where hotels aren't booked until there's a hotel partner API.
+func hotelBookingEnabled() bool {
+	return os.Getenv("ENVIRONMENT") != "production"
+}
+
+// hotelServiceFromEnv returns the local stand-in for the hotel partner outside production, nil in production.
+func hotelServiceFromEnv() command.HotelService {
+	if !hotelBookingEnabled() {
+		return nil
+	}
+
+	return api.NewLocalHotelService()
+}
+
+// coldStorageFromEnv returns the data lake archive, if the service reads it.
+func coldStorageFromEnv() db.ColdStorage {
+	dir := os.Getenv("DATA_LAKE_ARCHIVE_DIR")
+	if dir == "" {
+		return nil
+	}
+
+	return archive.NewArchive(dir)
+}
+
+// newDataLake returns the data lake including archived events.
+func newDataLake(database *db.DB) db.EventRepository {
+	dataLake := db.NewEventRepository(database, nil)
+	if coldStorage := coldStorageFromEnv(); coldStorage != nil {
+		dataLake = dataLake.WithColdStorage(coldStorage)
+	}
+
+	return dataLake
+}
+
+func newTransport(ctx context.Context, redisClient *redis.Client) (message.Transport, error) {
+	transportKind := message.TransportRedis
+	if kind := os.Getenv("MESSAGE_TRANSPORT"); kind != "" {
+		transportKind = message.TransportKind(kind)
+	}
+
+	return message.NewTransport(
+		message.TransportConfig{
+			Kind:         transportKind,
+			RedisClient:  redisClient,
+			KafkaBrokers: strings.Split(os.Getenv("KAFKA_ADDR"), ","),
+		},
+		log.NewWatermill(log.FromContext(ctx)),
+	)
+}
+
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return d, nil
+}
+
+func listFromEnv(name string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
 	}
+
+	return strings.Split(value, ",")
+}
+
+func intFromEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return i, nil
 }
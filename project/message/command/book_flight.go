@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) BookFlight(ctx context.Context, command *entities.BookFlight) error {
@@ -16,11 +18,14 @@ func (h Handler) BookFlight(ctx context.Context, command *entities.BookFlight) e
 		IdempotencyKey: command.IdempotencyKey,
 	})
 	if errors.Is(err, entities.ErrNoFlightTicketsAvailable) {
-		err = h.eventBus.Publish(ctx, entities.FlightBookingFailed_v1{
-			Header:        entities.NewEventHeader(),
-			FailureReason: err.Error(),
-			FlightID:      command.FlightID,
-			ReferenceID:   command.ReferenceID,
+		failureReason := err.Error()
+		err = h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+			return eventBus.Publish(ctx, entities.FlightBookingFailed_v1{
+				Header:        entities.NewEventHeader(),
+				FailureReason: failureReason,
+				FlightID:      command.FlightID,
+				ReferenceID:   command.ReferenceID,
+			})
 		})
 		if err != nil {
 			return fmt.Errorf("failed to publish FlightBookingFailed_v1 event: %w", err)
@@ -32,11 +37,13 @@ func (h Handler) BookFlight(ctx context.Context, command *entities.BookFlight) e
 		return fmt.Errorf("failed to void receipt: %w", err)
 	}
 
-	err = h.eventBus.Publish(ctx, entities.FlightBooked_v1{
-		Header:      entities.NewEventHeader(),
-		FlightID:    command.FlightID,
-		TicketIDs:   resp.TicketIds,
-		ReferenceID: command.ReferenceID,
+	err = h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.FlightBooked_v1{
+			Header:      entities.NewEventHeader(),
+			FlightID:    command.FlightID,
+			TicketIDs:   resp.TicketIds,
+			ReferenceID: command.ReferenceID,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to publish FlightBooked_v1 event: %w", err)
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+)
+
+func (h Handler) BookHotel(ctx context.Context, command *entities.BookHotel) error {
+	if h.hotelService == nil {
+		// the command was sent before hotel booking was turned off, failing it compensates the bundle
+		return h.publishHotelBookingFailed(ctx, command, "hotel booking is not available")
+	}
+
+	hotelBookingID, err := h.hotelService.BookHotel(ctx, entities.BookHotelRequest{
+		CustomerEmail:  command.CustomerEmail,
+		GuestNames:     command.GuestNames,
+		ShowID:         command.ShowID,
+		ReferenceID:    command.ReferenceID,
+		IdempotencyKey: command.IdempotencyKey,
+	})
+	if errors.Is(err, entities.ErrNoHotelRoomsAvailable) {
+		return h.publishHotelBookingFailed(ctx, command, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to book hotel: %w", err)
+	}
+
+	err = h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.HotelBooked_v1{
+			Header:         entities.NewEventHeader(),
+			HotelBookingID: hotelBookingID,
+			ReferenceID:    command.ReferenceID,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish HotelBooked_v1 event: %w", err)
+	}
+
+	return nil
+}
+
+func (h Handler) publishHotelBookingFailed(ctx context.Context, command *entities.BookHotel, failureReason string) error {
+	err := h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.HotelBookingFailed_v1{
+			Header:        entities.NewEventHeader(),
+			FailureReason: failureReason,
+			ReferenceID:   command.ReferenceID,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish HotelBookingFailed_v1 event: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"tickets/entities"
+	"tickets/message/command"
+	"tickets/message/event"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_BookHotel_noRoomsAvailable(t *testing.T) {
+	testCases := []struct {
+		name          string
+		hotelService  command.HotelService
+		failureReason string
+	}{
+		{
+			name:          "no_rooms_available",
+			hotelService:  noRoomsHotelService{},
+			failureReason: "hotel 1: " + entities.ErrNoHotelRoomsAvailable.Error(),
+		},
+		{
+			name:          "no_hotel_service",
+			hotelService:  nil,
+			failureReason: "hotel booking is not available",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			uow := &recordingUnitOfWork{}
+			handler := command.NewHandler(uow, noopReceipts{}, nil, nil, nil, tc.hotelService)
+
+			referenceID := uuid.NewString()
+			err := handler.BookHotel(context.Background(), &entities.BookHotel{
+				CustomerEmail:  "email@example.com",
+				GuestNames:     []string{"Guest"},
+				ShowID:         uuid.New(),
+				ReferenceID:    referenceID,
+				IdempotencyKey: uuid.NewString(),
+			})
+			require.NoError(t, err)
+
+			require.Len(t, uow.messages, 1)
+			assert.Equal(t, referenceID, uow.partitionKey)
+			assert.Equal(t, "HotelBookingFailed_v1", uow.messages[0].Metadata.Get("name"))
+
+			var failed entities.HotelBookingFailed_v1
+			require.NoError(t, json.Unmarshal(uow.messages[0].Payload, &failed))
+			assert.Equal(t, referenceID, failed.ReferenceID)
+			assert.Equal(t, tc.failureReason, failed.FailureReason)
+		})
+	}
+}
+
+type noRoomsHotelService struct{}
+
+func (noRoomsHotelService) BookHotel(context.Context, entities.BookHotelRequest) (uuid.UUID, error) {
+	return uuid.Nil, fmt.Errorf("hotel 1: %w", entities.ErrNoHotelRoomsAvailable)
+}
+
+func (noRoomsHotelService) CancelHotelBooking(context.Context, uuid.UUID) error {
+	return nil
+}
+
+type noopReceipts struct{}
+
+func (noopReceipts) VoidReceipt(context.Context, entities.VoidReceipt) error {
+	return nil
+}
+
+// recordingUnitOfWork records the messages published in the unit of work instead of storing them in the outbox.
+type recordingUnitOfWork struct {
+	partitionKey string
+	messages     []*message.Message
+}
+
+func (u *recordingUnitOfWork) Do(
+	ctx context.Context,
+	partitionKey string,
+	fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+) error {
+	u.partitionKey = partitionKey
+	return fn(ctx, event.NewBus(u), command.NewCommandBus(u))
+}
+
+func (u *recordingUnitOfWork) Publish(_ string, messages ...*message.Message) error {
+	u.messages = append(u.messages, messages...)
+	return nil
+}
+
+func (u *recordingUnitOfWork) Close() error {
+	return nil
+}
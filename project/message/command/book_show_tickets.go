@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"tickets/db"
 	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) BookShowTickets(ctx context.Context, command *entities.BookShowTickets) error {
@@ -20,10 +22,13 @@ func (h Handler) BookShowTickets(ctx context.Context, command *entities.BookShow
 	}
 
 	if errors.Is(err, db.ErrNoPlacesLeft) {
-		publishErr := h.eventBus.Publish(ctx, entities.BookingFailed_v1{
-			Header:        entities.NewEventHeader(),
-			BookingID:     command.BookingID,
-			FailureReason: err.Error(),
+		failureReason := err.Error()
+		publishErr := h.unitOfWork.Do(ctx, command.BookingID.String(), func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+			return eventBus.Publish(ctx, entities.BookingFailed_v1{
+				Header:        entities.NewEventHeader(),
+				BookingID:     command.BookingID,
+				FailureReason: failureReason,
+			})
 		})
 		if publishErr != nil {
 			return fmt.Errorf("failed to publish BookingFailed_v1 event: %w", publishErr)
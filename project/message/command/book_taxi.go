@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) BookTaxi(ctx context.Context, command *entities.BookTaxi) error {
@@ -16,19 +18,27 @@ func (h Handler) BookTaxi(ctx context.Context, command *entities.BookTaxi) error
 		ReferenceID:        command.ReferenceID,
 	})
 	if err != nil {
-		h.eventBus.Publish(ctx, entities.TaxiBookingFailed_v1{
-			Header:        entities.NewEventHeader(),
-			FailureReason: err.Error(),
-			ReferenceID:   command.ReferenceID,
+		failureReason := err.Error()
+		publishErr := h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+			return eventBus.Publish(ctx, entities.TaxiBookingFailed_v1{
+				Header:        entities.NewEventHeader(),
+				FailureReason: failureReason,
+				ReferenceID:   command.ReferenceID,
+			})
 		})
+		if publishErr != nil {
+			return fmt.Errorf("failed to publish TaxiBookingFailed_v1 event: %w", publishErr)
+		}
 
 		return fmt.Errorf("failed to book taxi: %w", err)
 	}
 
-	err = h.eventBus.Publish(ctx, entities.TaxiBooked_v1{
-		Header:        entities.NewEventHeader(),
-		TaxiBookingID: resp,
-		ReferenceID:   command.ReferenceID,
+	err = h.unitOfWork.Do(ctx, command.ReferenceID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.TaxiBooked_v1{
+			Header:        entities.NewEventHeader(),
+			TaxiBookingID: resp,
+			ReferenceID:   command.ReferenceID,
+		})
 	})
 
 	if err != nil {
@@ -0,0 +1,37 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tickets/booking"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/google/uuid"
+)
+
+func (h Handler) CancelBooking(ctx context.Context, command *entities.CancelBooking) error {
+	return h.decideBooking(ctx, command.BookingID, func(b *booking.Booking) error {
+		return b.Cancel(command.Reason)
+	})
+}
+
+// decideBooking applies the decision to the booking loaded from its stream, and appends the recorded events.
+// Decisions the booking rejects are logged and acked, as redelivering the command can't help.
+// A redelivered command is rejected too, for example canceling a booking which is already canceled.
+func (h Handler) decideBooking(ctx context.Context, bookingID uuid.UUID, decide func(b *booking.Booking) error) error {
+	_, err := h.bookingsRepo.Update(ctx, bookingID, decide)
+	if errors.Is(err, booking.ErrNotFound) ||
+		errors.Is(err, booking.ErrCanceled) ||
+		errors.Is(err, booking.ErrNotCanceled) ||
+		errors.Is(err, booking.ErrRefunded) {
+		log.FromContext(ctx).WithError(err).WithField("booking_id", bookingID).Warn("Booking decision rejected, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update booking %s: %w", bookingID, err)
+	}
+
+	return nil
+}
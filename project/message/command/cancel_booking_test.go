@@ -0,0 +1,80 @@
+package command_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"tickets/booking"
+	"tickets/entities"
+	"tickets/message/command"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_bookingDecisions(t *testing.T) {
+	ctx := context.Background()
+	bookingID := uuid.New()
+
+	made, err := booking.New(bookingID, uuid.New(), 2, "old@example.com")
+	require.NoError(t, err)
+	repo := &memoryBookingsRepository{streams: map[uuid.UUID][]entities.IEvent{bookingID: made.Changes()}}
+
+	handler := command.NewHandler(&recordingUnitOfWork{}, noopReceipts{}, repo, nil, nil, nil)
+
+	// rejected decisions are acked, redelivered commands are rejected
+	require.NoError(t, handler.RefundBooking(ctx, &entities.RefundBooking{BookingID: bookingID}))
+	require.NoError(t, handler.TransferBooking(ctx, &entities.TransferBooking{BookingID: bookingID, CustomerEmail: "new@example.com"}))
+	require.NoError(t, handler.CancelBooking(ctx, &entities.CancelBooking{BookingID: bookingID, Reason: "customer request"}))
+	require.NoError(t, handler.CancelBooking(ctx, &entities.CancelBooking{BookingID: bookingID, Reason: "customer request"}))
+	require.NoError(t, handler.RefundBooking(ctx, &entities.RefundBooking{BookingID: bookingID}))
+	require.NoError(t, handler.RefundBooking(ctx, &entities.RefundBooking{BookingID: bookingID}))
+	require.NoError(t, handler.CancelBooking(ctx, &entities.CancelBooking{BookingID: uuid.New()}))
+
+	var names []string
+	for _, event := range repo.streams[bookingID] {
+		names = append(names, fmt.Sprintf("%T", event))
+	}
+	assert.Equal(t, []string{
+		"*entities.BookingMade_v1",
+		"*entities.BookingTransferred_v1",
+		"*entities.BookingCanceled_v1",
+		"*entities.BookingRefunded_v1",
+	}, names)
+
+	refunded := repo.streams[bookingID][3].(*entities.BookingRefunded_v1)
+	assert.Equal(t, "new@example.com", refunded.CustomerEmail)
+}
+
+// memoryBookingsRepository keeps the booking streams, bookings are replayed from them on every update.
+type memoryBookingsRepository struct {
+	streams map[uuid.UUID][]entities.IEvent
+}
+
+func (r *memoryBookingsRepository) Create(context.Context, entities.Booking) (entities.BookingCreateResponse, error) {
+	return entities.BookingCreateResponse{}, fmt.Errorf("not implemented")
+}
+
+func (r *memoryBookingsRepository) Update(
+	_ context.Context,
+	bookingID uuid.UUID,
+	updateFn func(b *booking.Booking) error,
+) (*booking.Booking, error) {
+	stream, ok := r.streams[bookingID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", booking.ErrNotFound, bookingID)
+	}
+
+	b, err := booking.FromHistory(booking.Snapshot{}, stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := updateFn(b); err != nil {
+		return nil, fmt.Errorf("could not update booking: %w", err)
+	}
+
+	r.streams[bookingID] = append(stream, b.Changes()...)
+
+	return b, nil
+}
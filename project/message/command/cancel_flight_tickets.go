@@ -2,15 +2,23 @@ package command
 
 import (
 	"context"
+	"fmt"
 	"tickets/entities"
 )
 
 func (h Handler) CancelFlightTickets(ctx context.Context, command *entities.CancelFlightTickets) error {
 	for _, ticketID := range command.FlightTicketIDs {
-		err := h.transportaionService.DeleteFlightTickets(ctx, ticketID)
+		idempotencyKey := ""
+		if command.IdempotencyKey != "" {
+			// each ticket is canceled with its own request
+			idempotencyKey = command.IdempotencyKey + "/" + ticketID.String()
+		}
+
+		err := h.transportaionService.DeleteFlightTickets(ctx, ticketID, idempotencyKey)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to cancel flight ticket %s: %w", ticketID, err)
 		}
 	}
+
 	return nil
 }
@@ -0,0 +1,23 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+)
+
+func (h Handler) CancelHotelBooking(ctx context.Context, command *entities.CancelHotelBooking) error {
+	if h.hotelService == nil {
+		// hotels are booked only with a hotel service, so there's nothing to cancel
+		log.FromContext(ctx).WithField("hotel_booking_id", command.HotelBookingID).Warn("No hotel service, skipping hotel booking cancellation")
+		return nil
+	}
+
+	if err := h.hotelService.CancelHotelBooking(ctx, command.HotelBookingID); err != nil {
+		return fmt.Errorf("failed to cancel hotel booking %s: %w", command.HotelBookingID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"tickets/entities"
+)
+
+func (h Handler) CancelTaxiBooking(ctx context.Context, command *entities.CancelTaxiBooking) error {
+	if err := h.transportaionService.CancelTaxiBooking(ctx, command.TaxiBookingID); err != nil {
+		return fmt.Errorf("failed to cancel taxi booking %s: %w", command.TaxiBookingID, err)
+	}
+
+	return nil
+}
@@ -2,35 +2,37 @@ package command
 
 import (
 	"fmt"
+	"tickets/message/protobuf"
+	"tickets/message/upcast"
 
 	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
-	"github.com/redis/go-redis/v9"
 )
 
-var marshaler = cqrs.JSONMarshaler{
-	GenerateName: cqrs.StructName,
+// marshaler accepts both JSON and protobuf payloads and upcasts older versions of messages,
+// so handlers receive only the newest ones.
+var marshaler = protobuf.Marshaler{
+	CommandEventMarshaler: upcast.Marshaler{
+		CommandEventMarshaler: cqrs.JSONMarshaler{
+			GenerateName: cqrs.StructName,
+		},
+		Registry: upcast.Default,
+	},
 }
 
-func NewCommandProcessorConfig(redisClient *redis.Client, watermillLogger watermill.LoggerAdapter) cqrs.CommandProcessorConfig {
+// SubscriberConstructor creates a subscriber of the configured transport for the consumer group.
+type SubscriberConstructor func(consumerGroup string) (message.Subscriber, error)
+
+func NewCommandProcessorConfig(newSubscriber SubscriberConstructor, watermillLogger watermill.LoggerAdapter) cqrs.CommandProcessorConfig {
 	return cqrs.CommandProcessorConfig{
 		GenerateSubscribeTopic: func(params cqrs.CommandProcessorGenerateSubscribeTopicParams) (string, error) {
 			return fmt.Sprintf("commands.%s", params.CommandName), nil
 		},
 		SubscriberConstructor: func(params cqrs.CommandProcessorSubscriberConstructorParams) (message.Subscriber, error) {
-			return redisstream.NewSubscriber(
-				redisstream.SubscriberConfig{
-					Client:        redisClient,
-					ConsumerGroup: "svc-tickets.commands." + params.HandlerName,
-				},
-				watermillLogger,
-			)
-		},
-		Marshaler: cqrs.JSONMarshaler{
-			GenerateName: cqrs.StructName,
+			return newSubscriber("svc-tickets.commands." + params.HandlerName)
 		},
-		Logger: watermillLogger,
+		Marshaler: marshaler,
+		Logger:    watermillLogger,
 	}
 }
@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"tickets/booking"
 	"tickets/entities"
 
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
@@ -19,41 +20,60 @@ type PaymentsService interface {
 type TransportationService interface {
 	BookFlight(ctx context.Context, bookFlight entities.BookFlightTicketRequest) (entities.BookFlightTicketResponse, error)
 	BookTaxi(ctx context.Context, bookTaxi entities.BookTaxi) (uuid.UUID, error)
-	DeleteFlightTickets(ctx context.Context, ticketID uuid.UUID) error
+	DeleteFlightTickets(ctx context.Context, ticketID uuid.UUID, idempotencyKey string) error
+	CancelTaxiBooking(ctx context.Context, taxiBookingID uuid.UUID) error
+}
+
+type HotelService interface {
+	BookHotel(ctx context.Context, request entities.BookHotelRequest) (uuid.UUID, error)
+	CancelHotelBooking(ctx context.Context, hotelBookingID uuid.UUID) error
 }
 
 type Handler struct {
 	receiptsService       ReceiptsService
 	bookingsRepo          BookingsRepository
 	transportaionService  TransportationService
-	eventBus              *cqrs.EventBus
-	commandBus            *cqrs.CommandBus
+	unitOfWork            UnitOfWork
 	paymentsServiceClient PaymentsService
+	hotelService          HotelService
 }
 type BookingsRepository interface {
 	Create(ctx context.Context, booking entities.Booking) (entities.BookingCreateResponse, error)
+	// Update loads the booking by replaying its stream, and appends the events recorded by updateFn
+	// in one transaction, with their publication through the outbox.
+	Update(ctx context.Context, bookingID uuid.UUID, updateFn func(b *booking.Booking) error) (*booking.Booking, error)
+}
+
+// UnitOfWork publishes events through the outbox, atomically with the DB writes done in fn.
+type UnitOfWork interface {
+	Do(
+		ctx context.Context,
+		partitionKey string,
+		fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+	) error
 }
 
-func NewHandler(eventBus *cqrs.EventBus,
+func NewHandler(unitOfWork UnitOfWork,
 	receiptsServiceClient ReceiptsService,
 	bookingsRepo BookingsRepository,
 	transportaionService TransportationService,
-	commandBus *cqrs.CommandBus,
-	paymentsService PaymentsService) Handler {
-	if eventBus == nil {
-		panic("eventBus is required")
+	paymentsService PaymentsService,
+	// hotelService is nil where there's no hotel partner
+	hotelService HotelService) Handler {
+	if unitOfWork == nil {
+		panic("unitOfWork is required")
 	}
 	if receiptsServiceClient == nil {
 		panic("receiptsServiceClient is required")
 	}
 
 	handler := Handler{
-		eventBus:              eventBus,
+		unitOfWork:            unitOfWork,
 		receiptsService:       receiptsServiceClient,
 		bookingsRepo:          bookingsRepo,
 		transportaionService:  transportaionService,
-		commandBus:            commandBus,
 		paymentsServiceClient: paymentsService,
+		hotelService:          hotelService,
 	}
 
 	return handler
@@ -0,0 +1,208 @@
+package command_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"tickets/entities"
+	"tickets/message/command"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHandler_redeliveredCommands delivers every command twice, as the command processor may,
+// and checks that the APIs make one booking, cancellation or refund per idempotency key.
+func TestHandler_redeliveredCommands(t *testing.T) {
+	ctx := context.Background()
+	referenceID := uuid.NewString()
+
+	testCases := []struct {
+		name    string
+		deliver func(h command.Handler) error
+		// calls returns the calls of the API which changed something, by idempotency key
+		calls    func(apis *countingAPIs) map[string]int
+		wantKeys []string
+	}{
+		{
+			name: "BookFlight",
+			deliver: func(h command.Handler) error {
+				return h.BookFlight(ctx, &entities.BookFlight{
+					CustomerEmail:  "email@example.com",
+					FlightID:       uuid.New(),
+					Passengers:     []string{"Passenger"},
+					ReferenceID:    referenceID,
+					IdempotencyKey: "inbound-flight-key",
+				})
+			},
+			calls:    func(apis *countingAPIs) map[string]int { return apis.flightsBooked },
+			wantKeys: []string{"inbound-flight-key"},
+		},
+		{
+			name: "BookTaxi",
+			deliver: func(h command.Handler) error {
+				return h.BookTaxi(ctx, &entities.BookTaxi{
+					CustomerEmail:      "email@example.com",
+					CustomerName:       "Passenger",
+					NumberOfPassengers: 1,
+					ReferenceID:        referenceID,
+					IdempotencyKey:     "taxi-key",
+				})
+			},
+			calls:    func(apis *countingAPIs) map[string]int { return apis.taxisBooked },
+			wantKeys: []string{"taxi-key"},
+		},
+		{
+			name: "CancelFlightTickets",
+			deliver: func(h command.Handler) error {
+				return h.CancelFlightTickets(ctx, &entities.CancelFlightTickets{
+					FlightTicketIDs: []uuid.UUID{uuid.MustParse("6f1c8c6e-7d5b-4b0e-9a55-4f3b1d9a2c01")},
+					IdempotencyKey:  "cancel-inbound-flight-key",
+				})
+			},
+			calls:    func(apis *countingAPIs) map[string]int { return apis.flightTicketsCanceled },
+			wantKeys: []string{"cancel-inbound-flight-key/6f1c8c6e-7d5b-4b0e-9a55-4f3b1d9a2c01"},
+		},
+		{
+			name: "RefundTicket",
+			deliver: func(h command.Handler) error {
+				return h.RefundTicket(ctx, &entities.RefundTicket{
+					Header:   entities.NewEventHeaderWithIdempotencyKey("refund-ticket-key"),
+					TicketID: uuid.NewString(),
+				})
+			},
+			calls:    func(apis *countingAPIs) map[string]int { return apis.paymentsRefunded },
+			wantKeys: []string{"refund-ticket-key"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			apis := newCountingAPIs()
+			handler := command.NewHandler(&recordingUnitOfWork{}, apis, nil, apis, apis, nil)
+
+			require.NoError(t, tc.deliver(handler))
+			require.NoError(t, tc.deliver(handler))
+
+			calls := tc.calls(apis)
+			for _, key := range tc.wantKeys {
+				assert.Equal(t, 1, calls[key], "one call must reach the API for key %s", key)
+			}
+			assert.Len(t, calls, len(tc.wantKeys))
+		})
+	}
+}
+
+// countingAPIs count the calls which changed something, by their idempotency key.
+// Like the real APIs, a call repeated with the same idempotency key changes nothing.
+type countingAPIs struct {
+	lock sync.Mutex
+
+	flightsBooked         map[string]int
+	flightTickets         map[string][]uuid.UUID
+	taxisBooked           map[string]int
+	taxiBookings          map[string]uuid.UUID
+	flightTicketsCanceled map[string]int
+	receiptsVoided        map[string]int
+	paymentsRefunded      map[string]int
+}
+
+func newCountingAPIs() *countingAPIs {
+	return &countingAPIs{
+		flightsBooked:         map[string]int{},
+		flightTickets:         map[string][]uuid.UUID{},
+		taxisBooked:           map[string]int{},
+		taxiBookings:          map[string]uuid.UUID{},
+		flightTicketsCanceled: map[string]int{},
+		receiptsVoided:        map[string]int{},
+		paymentsRefunded:      map[string]int{},
+	}
+}
+
+// once counts the call with the key, unless it was already made.
+func once(calls map[string]int, key string) bool {
+	if key == "" || calls[key] > 0 {
+		return false
+	}
+	calls[key]++
+
+	return true
+}
+
+func (a *countingAPIs) BookFlight(_ context.Context, request entities.BookFlightTicketRequest) (entities.BookFlightTicketResponse, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if once(a.flightsBooked, request.IdempotencyKey) {
+		a.flightTickets[request.IdempotencyKey] = []uuid.UUID{uuid.New()}
+	}
+
+	return entities.BookFlightTicketResponse{TicketIds: a.flightTickets[request.IdempotencyKey]}, nil
+}
+
+func (a *countingAPIs) BookTaxi(_ context.Context, request entities.BookTaxi) (uuid.UUID, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if once(a.taxisBooked, request.IdempotencyKey) {
+		a.taxiBookings[request.IdempotencyKey] = uuid.New()
+	}
+
+	return a.taxiBookings[request.IdempotencyKey], nil
+}
+
+func (a *countingAPIs) DeleteFlightTickets(_ context.Context, _ uuid.UUID, idempotencyKey string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	once(a.flightTicketsCanceled, idempotencyKey)
+
+	return nil
+}
+
+func (a *countingAPIs) CancelTaxiBooking(context.Context, uuid.UUID) error {
+	return nil
+}
+
+func (a *countingAPIs) VoidReceipt(_ context.Context, request entities.VoidReceipt) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	once(a.receiptsVoided, request.IdempotencyKey)
+
+	return nil
+}
+
+func (a *countingAPIs) RefundPayment(_ context.Context, request entities.PaymentRefund) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	once(a.paymentsRefunded, request.IdempotencyKey)
+
+	return nil
+}
+
+func TestHandler_redeliveredBookFlightPublishesTheSameTickets(t *testing.T) {
+	ctx := context.Background()
+	apis := newCountingAPIs()
+	uow := &recordingUnitOfWork{}
+	handler := command.NewHandler(uow, apis, nil, apis, apis, nil)
+
+	cmd := &entities.BookFlight{
+		CustomerEmail:  "email@example.com",
+		FlightID:       uuid.New(),
+		Passengers:     []string{"Passenger"},
+		ReferenceID:    uuid.NewString(),
+		IdempotencyKey: "inbound-flight-key",
+	}
+	require.NoError(t, handler.BookFlight(ctx, cmd))
+	require.NoError(t, handler.BookFlight(ctx, cmd))
+
+	require.Len(t, uow.messages, 2)
+	var first, second entities.FlightBooked_v1
+	require.NoError(t, json.Unmarshal(uow.messages[0].Payload, &first))
+	require.NoError(t, json.Unmarshal(uow.messages[1].Payload, &second))
+	assert.Equal(t, first.TicketIDs, second.TicketIDs, "redelivery must not book other tickets")
+}
@@ -0,0 +1,13 @@
+package command
+
+import (
+	"context"
+	"tickets/booking"
+	"tickets/entities"
+)
+
+func (h Handler) RefundBooking(ctx context.Context, command *entities.RefundBooking) error {
+	return h.decideBooking(ctx, command.BookingID, func(b *booking.Booking) error {
+		return b.Refund()
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) RefundTicket(ctx context.Context, ticketRefund *entities.RefundTicket) error {
@@ -30,9 +32,11 @@ func (h Handler) RefundTicket(ctx context.Context, ticketRefund *entities.Refund
 		return fmt.Errorf("failed to refund payment: %w", err)
 	}
 
-	err = h.eventBus.Publish(ctx, entities.TicketRefunded_v1{
-		Header:   entities.NewEventHeader(),
-		TicketID: ticketRefund.TicketID,
+	err = h.unitOfWork.Do(ctx, ticketRefund.TicketID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.TicketRefunded_v1{
+			Header:   entities.NewEventHeader(),
+			TicketID: ticketRefund.TicketID,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to publish TicketRefunded event: %w", err)
@@ -3,19 +3,29 @@ package event
 import (
 	"fmt"
 	"tickets/entities"
+	"tickets/message/protobuf"
+	"tickets/message/upcast"
 
 	"github.com/ThreeDotsLabs/watermill"
-	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
-	"github.com/redis/go-redis/v9"
 )
 
-var marshaler = cqrs.JSONMarshaler{
-	GenerateName: cqrs.StructName,
+// marshaler accepts both JSON and protobuf payloads and upcasts older versions of messages,
+// so handlers receive only the newest ones.
+var marshaler = protobuf.Marshaler{
+	CommandEventMarshaler: upcast.Marshaler{
+		CommandEventMarshaler: cqrs.JSONMarshaler{
+			GenerateName: cqrs.StructName,
+		},
+		Registry: upcast.Default,
+	},
 }
 
-func NewProcessorConfig(redisClient *redis.Client, watermillLogger watermill.LoggerAdapter) cqrs.EventProcessorConfig {
+// SubscriberConstructor creates a subscriber of the configured transport for the consumer group.
+type SubscriberConstructor func(consumerGroup string) (message.Subscriber, error)
+
+func NewProcessorConfig(newSubscriber SubscriberConstructor, watermillLogger watermill.LoggerAdapter) cqrs.EventProcessorConfig {
 	return cqrs.EventProcessorConfig{
 		GenerateSubscribeTopic: func(params cqrs.EventProcessorGenerateSubscribeTopicParams) (string, error) {
 			handlerEvent := params.EventHandler.NewEvent()
@@ -34,10 +44,7 @@ func NewProcessorConfig(redisClient *redis.Client, watermillLogger watermill.Log
 			return fmt.Sprintf(prefix + params.EventName), nil
 		},
 		SubscriberConstructor: func(params cqrs.EventProcessorSubscriberConstructorParams) (message.Subscriber, error) {
-			return redisstream.NewSubscriber(redisstream.SubscriberConfig{
-				Client:        redisClient,
-				ConsumerGroup: "svc-tickets.events." + params.HandlerName,
-			}, watermillLogger)
+			return newSubscriber("svc-tickets.events." + params.HandlerName)
 		},
 		Marshaler: marshaler,
 		Logger:    watermillLogger,
@@ -24,6 +24,19 @@ type TicketRepository interface {
 	Update(ctx context.Context, ticket entities.Ticket) error
 }
 
+type Inbox interface {
+	ProcessOnce(ctx context.Context, handlerName string, messageID string, fn func(ctx context.Context) error) error
+}
+
+// UnitOfWork publishes events through the outbox, atomically with the DB writes done in fn.
+type UnitOfWork interface {
+	Do(
+		ctx context.Context,
+		partitionKey string,
+		fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+	) error
+}
+
 type FileService interface {
 	StoreFile(ctx context.Context, ticketFile string, ticketHTML string) error
 }
@@ -36,27 +49,35 @@ type Handler struct {
 	spreadsheetsService SpreadsheetsAPI
 	receiptsService     ReceiptsService
 	fileService         FileService
-	eventBus            *cqrs.EventBus
+	unitOfWork          UnitOfWork
 	ticketRepo          TicketRepository
 	showRepo            ShowRepository
 	deadNationSvc       DeadNationService
+	inbox               Inbox
 }
 
 func NewHandler(spreedsheetsService SpreadsheetsAPI, receiptsService ReceiptsService, ticketRepo TicketRepository, fileService FileService,
-	eventBus *cqrs.EventBus, deadNationService DeadNationService, showRepo ShowRepository) Handler {
+	unitOfWork UnitOfWork, deadNationService DeadNationService, showRepo ShowRepository, inbox Inbox) Handler {
 	if spreedsheetsService == nil {
 		panic("missin spreedsheetsService")
 	}
 	if spreedsheetsService == nil {
 		panic("missing receiptsService")
 	}
+	if inbox == nil {
+		panic("missing inbox")
+	}
+	if unitOfWork == nil {
+		panic("missing unitOfWork")
+	}
 	return Handler{
 		spreadsheetsService: spreedsheetsService,
 		receiptsService:     receiptsService,
 		ticketRepo:          ticketRepo,
 		fileService:         fileService,
-		eventBus:            eventBus,
+		unitOfWork:          unitOfWork,
 		deadNationSvc:       deadNationService,
 		showRepo:            showRepo,
+		inbox:               inbox,
 	}
 }
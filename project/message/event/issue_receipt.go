@@ -6,6 +6,7 @@ import (
 	"tickets/entities"
 
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) IssueReceipt(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error {
@@ -22,10 +23,12 @@ func (h Handler) IssueReceipt(ctx context.Context, event *entities.TicketBooking
 		return fmt.Errorf("failed to issue receipt: %w", err)
 	}
 
-	return h.eventBus.Publish(ctx, entities.TicketReceiptIssued_v1{
-		Header:        entities.NewEventHeaderWithIdempotencyKey(event.Header.IdempotencyKey),
-		TicketID:      event.TicketID,
-		ReceiptNumber: resp.ReceiptNumber,
-		IssuedAt:      resp.IssuedAt,
+	return h.unitOfWork.Do(ctx, event.TicketID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, entities.TicketReceiptIssued_v1{
+			Header:        entities.NewEventHeaderWithIdempotencyKey(event.Header.IdempotencyKey),
+			TicketID:      event.TicketID,
+			ReceiptNumber: resp.ReceiptNumber,
+			IssuedAt:      resp.IssuedAt,
+		})
 	})
 }
@@ -10,10 +10,12 @@ import (
 func (h Handler) DeleteTicketCancel(ctx context.Context, event *entities.TicketBookingCanceled_v1) error {
 	log.FromContext(ctx).Info("Removing cancel ticket")
 
-	return h.ticketRepo.Delete(ctx, entities.Ticket{
-		TicketID:      event.TicketID,
-		Price:         event.Price,
-		CustomerEmail: event.CustomerEmail,
-		DeleteAt:      &event.Header.PublishedAt,
+	return h.inbox.ProcessOnce(ctx, "event_handler.DeleteTicketCancel", event.Header.ID, func(ctx context.Context) error {
+		return h.ticketRepo.Delete(ctx, entities.Ticket{
+			TicketID:      event.TicketID,
+			Price:         event.Price,
+			CustomerEmail: event.CustomerEmail,
+			DeleteAt:      &event.Header.PublishedAt,
+		})
 	})
 }
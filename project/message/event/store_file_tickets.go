@@ -6,6 +6,7 @@ import (
 	"tickets/entities"
 
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 )
 
 func (h Handler) StoreTicketsInFile(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error {
@@ -36,5 +37,7 @@ func (h Handler) StoreTicketsInFile(ctx context.Context, event *entities.TicketB
 		FileName: ticketFile,
 	}
 
-	return h.eventBus.Publish(ctx, ticketPrintedEvent)
+	return h.unitOfWork.Do(ctx, event.TicketID, func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		return eventBus.Publish(ctx, ticketPrintedEvent)
+	})
 }
@@ -10,9 +10,11 @@ import (
 func (h Handler) StoreTickets(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error {
 	log.FromContext(ctx).Info("Storing ticket")
 
-	return h.ticketRepo.Create(ctx, entities.Ticket{
-		TicketID:      event.TicketID,
-		Price:         event.Price,
-		CustomerEmail: event.CustomerEmail,
+	return h.inbox.ProcessOnce(ctx, "event_handler.StoreTickets", event.Header.ID, func(ctx context.Context) error {
+		return h.ticketRepo.Create(ctx, entities.Ticket{
+			TicketID:      event.TicketID,
+			Price:         event.Price,
+			CustomerEmail: event.CustomerEmail,
+		})
 	})
 }
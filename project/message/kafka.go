@@ -0,0 +1,45 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+func NewKafkaPublisher(brokers []string, watermillLogger watermill.LoggerAdapter) (message.Publisher, error) {
+	pub, err := kafka.NewPublisher(kafka.PublisherConfig{
+		Brokers:   brokers,
+		Marshaler: kafka.DefaultMarshaler{},
+	}, watermillLogger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kafka publisher: %w", err)
+	}
+
+	return pub, nil
+}
+
+func NewKafkaSubscriber(brokers []string, consumerGroup string, watermillLogger watermill.LoggerAdapter) (message.Subscriber, error) {
+	saramaConfig := kafka.DefaultSaramaSubscriberConfig()
+	// the same as Redis streams: a new consumer group starts from the beginning of the topic,
+	// and a subscriber without consumer group receives only new messages
+	if consumerGroup == "" {
+		saramaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	} else {
+		saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	sub, err := kafka.NewSubscriber(kafka.SubscriberConfig{
+		Brokers:               brokers,
+		Unmarshaler:           kafka.DefaultMarshaler{},
+		ConsumerGroup:         consumerGroup,
+		OverwriteSaramaConfig: saramaConfig,
+	}, watermillLogger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kafka subscriber for consumer group %q: %w", consumerGroup, err)
+	}
+
+	return sub, nil
+}
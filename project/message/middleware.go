@@ -1,12 +1,16 @@
 package message
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"tickets/db"
+	"tickets/message/replay"
 	"time"
 
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
 	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/lithammer/shortuuid/v3"
@@ -62,6 +66,8 @@ func useMiddlewares(router *message.Router, watermillLogger watermill.LoggerAdap
 		Logger:          watermillLogger,
 	}.Middleware)
 
+	router.AddMiddleware(retryOnConcurrentModification)
+
 	router.AddMiddleware(func(h message.HandlerFunc) message.HandlerFunc {
 		return func(msg *message.Message) (events []*message.Message, err error) {
 			topic := message.SubscribeTopicFromCtx(msg.Context())
@@ -157,3 +163,65 @@ func useMiddlewares(router *message.Router, watermillLogger watermill.LoggerAdap
 	})
 
 }
+
+const maxConcurrentModificationRetries = 5
+
+// retryOnConcurrentModification re-runs the handler right away when it lost an optimistic
+// concurrency race, without waiting for the backoff of the generic retry.
+func retryOnConcurrentModification(next message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		for attempt := 1; ; attempt++ {
+			msgs, err := next(msg)
+
+			var concurrentModificationErr db.ErrConcurrentModification
+			if !errors.As(err, &concurrentModificationErr) || attempt > maxConcurrentModificationRetries {
+				return msgs, err
+			}
+
+			log.FromContext(msg.Context()).WithError(err).WithField("attempt", attempt).Info("Concurrent modification, retrying")
+		}
+	}
+}
+
+// sideEffectsHandler is a handler with external side effects, which must not be repeated by replayed events.
+type sideEffectsHandler struct {
+	cqrs.EventHandler
+}
+
+// withSideEffects marks the handler as having external side effects, so replayed events are ignored by it.
+func withSideEffects(handler cqrs.EventHandler) cqrs.EventHandler {
+	return sideEffectsHandler{handler}
+}
+
+// ignoreReplayed acks events replayed from the data lake without handling them by the handlers
+// marked withSideEffects and by handlers other than the replay's target.
+func ignoreReplayed(handlers ...cqrs.EventHandler) message.HandlerMiddleware {
+	handlersWithSideEffects := map[string]bool{}
+	for _, h := range handlers {
+		if _, ok := h.(sideEffectsHandler); ok {
+			handlersWithSideEffects[h.HandlerName()] = true
+		}
+	}
+
+	return func(next message.HandlerFunc) message.HandlerFunc {
+		return func(msg *message.Message) ([]*message.Message, error) {
+			if !replay.IsReplayed(msg) {
+				return next(msg)
+			}
+
+			handler := message.HandlerNameFromCtx(msg.Context())
+
+			target := msg.Metadata.Get(replay.TargetMetadataKey)
+			if target != "" && target != handler {
+				return nil, nil
+			}
+
+			if handlersWithSideEffects[handler] {
+				log.FromContext(msg.Context()).WithField("handler", handler).Info("Ignoring replayed message")
+				return nil, nil
+			}
+
+			return next(msg)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"tickets/entities"
+	"tickets/message/replay"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreReplayed(t *testing.T) {
+	noop := func(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error { return nil }
+	handlers := []cqrs.EventHandler{
+		withSideEffects(cqrs.NewEventHandler("AppendToTracker", noop)),
+		cqrs.NewEventHandler("StoreTickets", noop),
+	}
+
+	testCases := []struct {
+		name     string
+		metadata message.Metadata
+		handled  []string
+	}{
+		{
+			name:     "not_replayed",
+			metadata: message.Metadata{},
+			handled:  []string{"AppendToTracker", "StoreTickets"},
+		},
+		{
+			name:     "replayed",
+			metadata: message.Metadata{replay.ReplayedMetadataKey: "true"},
+			handled:  []string{"StoreTickets"},
+		},
+		{
+			name: "replayed_to_the_target",
+			metadata: message.Metadata{
+				replay.ReplayedMetadataKey: "true",
+				replay.TargetMetadataKey:   "StoreTickets",
+			},
+			handled: []string{"StoreTickets"},
+		},
+		{
+			name: "replayed_to_the_target_with_side_effects",
+			metadata: message.Metadata{
+				replay.ReplayedMetadataKey: "true",
+				replay.TargetMetadataKey:   "AppendToTracker",
+			},
+			handled: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handled := handleByRouter(t, ignoreReplayed(handlers...), handlers, tc.metadata)
+			assert.ElementsMatch(t, tc.handled, handled)
+		})
+	}
+}
+
+// handleByRouter handles the message by handlers named like the event handlers and returns the names of those called.
+func handleByRouter(
+	t *testing.T,
+	middleware message.HandlerMiddleware,
+	handlers []cqrs.EventHandler,
+	metadata message.Metadata,
+) []string {
+	t.Helper()
+
+	logger := watermill.NopLogger{}
+	pubSub := gochannel.NewGoChannel(gochannel.Config{BlockPublishUntilSubscriberAck: true}, logger)
+
+	router, err := message.NewRouter(message.RouterConfig{}, logger)
+	require.NoError(t, err)
+	router.AddMiddleware(middleware)
+
+	var lock sync.Mutex
+	var handled []string
+	for _, h := range handlers {
+		name := h.HandlerName()
+		router.AddNoPublisherHandler(name, "events", pubSub, func(msg *message.Message) error {
+			lock.Lock()
+			defer lock.Unlock()
+			handled = append(handled, name)
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = router.Run(ctx)
+	}()
+	<-router.Running()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+	msg.Metadata = metadata
+	// returns once all handlers acked the message
+	require.NoError(t, pubSub.Publish("events", msg))
+
+	require.NoError(t, router.Close())
+
+	lock.Lock()
+	defer lock.Unlock()
+	return handled
+}
@@ -0,0 +1,6 @@
+package outbox
+
+type Config struct {
+	Partitions Partitions
+	Retention  RetentionConfig
+}
@@ -1,31 +1,65 @@
 package outbox
 
 import (
+	"time"
+
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
 	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/sirupsen/logrus"
 
 	"github.com/ThreeDotsLabs/watermill/components/forwarder"
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
+// NewForwarders creates one forwarder per outbox partition.
+// Watermill uses the same handler name for every forwarder, so each of them runs its own router.
+func NewForwarders(
+	pgSusbscriber message.Subscriber,
+	redisPub message.Publisher,
+	logger watermill.LoggerAdapter,
+	partitions Partitions,
+) ([]*forwarder.Forwarder, error) {
+	var forwarders []*forwarder.Forwarder
+
+	for _, topic := range partitions.Topics() {
+		fwd, err := NewForwarder(pgSusbscriber, redisPub, logger, topic)
+		if err != nil {
+			return nil, err
+		}
+
+		forwarders = append(forwarders, fwd)
+	}
+
+	return forwarders, nil
+}
+
 func NewForwarder(
 	pgSusbscriber message.Subscriber,
 	redisPub message.Publisher,
 	logger watermill.LoggerAdapter,
-	router *message.Router) (*forwarder.Forwarder, error) {
+	topic string,
+) (*forwarder.Forwarder, error) {
 
 	fwd, err := forwarder.NewForwarder(pgSusbscriber, redisPub, logger,
 		forwarder.Config{
 			ForwarderTopic: topic,
-			Router:         router,
 			Middlewares: []message.HandlerMiddleware{
+				middleware.Recoverer,
+				middleware.Retry{
+					MaxRetries:      10,
+					InitialInterval: time.Millisecond * 100,
+					MaxInterval:     time.Second,
+					Multiplier:      2,
+					Logger:          logger,
+				}.Middleware,
 				func(h message.HandlerFunc) message.HandlerFunc {
 					return func(msg *message.Message) ([]*message.Message, error) {
 						log.FromContext(msg.Context()).WithFields(logrus.Fields{
 							"message_id": msg.UUID,
 							"payload":    string(msg.Payload),
 							"metadata":   msg.Metadata,
+							"topic":      topic,
 						}).Info("Forwarding message")
 						return h(msg)
 					}
@@ -0,0 +1,122 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitions_Topic(t *testing.T) {
+	assert.Equal(t, "events_to_forward", Partitions(1).Topic("booking-1"))
+	assert.Equal(t, []string{"events_to_forward"}, Partitions(0).Topics())
+
+	partitions := Partitions(4)
+	assert.Equal(t, partitions.Topic("booking-1"), partitions.Topic("booking-1"))
+	assert.Len(t, partitions.Topics(), 4)
+
+	used := map[string]struct{}{}
+	for i := 0; i < 100; i++ {
+		topic := partitions.Topic(strconv.Itoa(i))
+		assert.Contains(t, partitions.Topics(), topic)
+		used[topic] = struct{}{}
+	}
+	assert.Len(t, used, 4, "keys should be spread over all partitions")
+}
+
+// BenchmarkForwarder compares forwarding throughput of a single forwarder with partitioned ones.
+func BenchmarkForwarder(b *testing.B) {
+	for _, partitions := range []Partitions{1, 4, 8} {
+		b.Run(fmt.Sprintf("partitions=%d", partitions), func(b *testing.B) {
+			benchmarkForwarder(b, partitions)
+		})
+	}
+}
+
+func benchmarkForwarder(b *testing.B, partitions Partitions) {
+	db, err := sqlx.Open("postgres", os.Getenv("POSTGRES_URL"))
+	require.NoError(b, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := watermill.NopLogger{}
+	sub := SubscribeForPGMessages(db, logger, partitions)
+	defer sub.Close()
+
+	// messages left in the outbox by previous runs are forwarded as well, so only messages of this run are counted
+	runID := watermill.NewUUID()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	require.NoError(b, err)
+	for i := 0; i < b.N; i++ {
+		// 100 aggregates, so every partition receives messages
+		pub, err := NewPublisherForDb(ctx, tx, partitions, strconv.Itoa(i%100))
+		require.NoError(b, err)
+
+		msg := message.NewMessage(watermill.NewUUID(), []byte("{}"))
+		msg.Metadata.Set(benchmarkRunMetadataKey, runID)
+
+		err = pub.Publish("benchmark", msg)
+		require.NoError(b, err)
+	}
+	require.NoError(b, tx.Commit())
+
+	pub := &countingPublisher{runID: runID, done: make(chan struct{}), expected: int64(b.N)}
+
+	b.ResetTimer()
+
+	forwarders, err := NewForwarders(sub, pub, logger, partitions)
+	require.NoError(b, err)
+	for _, fwd := range forwarders {
+		go func() {
+			_ = fwd.Run(ctx)
+		}()
+	}
+
+	<-pub.done
+	b.StopTimer()
+}
+
+const benchmarkRunMetadataKey = "benchmark_run"
+
+// countingPublisher counts forwarded messages of one benchmark run.
+type countingPublisher struct {
+	runID     string
+	forwarded int64
+	expected  int64
+	done      chan struct{}
+	doneOnce  sync.Once
+}
+
+func (p *countingPublisher) Publish(topic string, messages ...*message.Message) error {
+	var forwarded int64
+	for _, msg := range messages {
+		if msg.Metadata.Get(benchmarkRunMetadataKey) == p.runID {
+			forwarded++
+		}
+	}
+
+	if atomic.AddInt64(&p.forwarded, forwarded) >= p.expected {
+		p.doneOnce.Do(func() {
+			close(p.done)
+		})
+	}
+
+	return nil
+}
+
+func (p *countingPublisher) Close() error {
+	return nil
+}
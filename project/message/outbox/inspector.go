@@ -0,0 +1,171 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill-sql/v2/pkg/sql"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	schemaAdapter  = sql.DefaultPostgreSQLSchema{}
+	offsetsAdapter = sql.DefaultPostgreSQLOffsetsAdapter{}
+)
+
+// forwarderConsumerGroup is the consumer group used by SubscribeForPGMessages (none is configured).
+const forwarderConsumerGroup = ""
+
+// visibleToForwarder is the predicate the forwarder's subscriber reads messages with, messages are
+// read only when no transaction which started before theirs is in flight, so they are forwarded in order.
+const visibleToForwarder = `transaction_id < pg_snapshot_xmin(pg_current_snapshot())`
+
+type PendingMessage struct {
+	Topic     string          `json:"topic" db:"-"`
+	Offset    int64           `json:"offset" db:"offset"`
+	UUID      string          `json:"uuid" db:"uuid"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	Metadata  json.RawMessage `json:"metadata" db:"metadata"`
+}
+
+type Stats struct {
+	PendingMessages int `json:"pending_messages"`
+	// LagSeconds is the age of the oldest message that was not forwarded yet.
+	LagSeconds float64 `json:"lag_seconds"`
+}
+
+// Inspector reads and cleans up the outbox tables used by the forwarders.
+type Inspector struct {
+	db         *sqlx.DB
+	partitions Partitions
+}
+
+func NewInspector(db *sqlx.DB, partitions Partitions) Inspector {
+	if db == nil {
+		panic("db is nil")
+	}
+
+	return Inspector{db: db, partitions: partitions}
+}
+
+// Pending returns the oldest messages waiting to be forwarded, across all partitions.
+func (i Inspector) Pending(ctx context.Context, limit int) ([]PendingMessage, error) {
+	var messages []PendingMessage
+
+	for _, topic := range i.partitions.Topics() {
+		query, args := pendingQuery(topic, `"offset", uuid, created_at, payload, metadata`)
+		query += fmt.Sprintf(` ORDER BY transaction_id ASC, "offset" ASC LIMIT $%d`, len(args)+1)
+
+		var partitionMessages []PendingMessage
+		err := i.db.SelectContext(ctx, &partitionMessages, query, append(args, limit)...)
+		if err != nil {
+			return nil, fmt.Errorf("could not get pending outbox messages from %s: %w", topic, err)
+		}
+
+		for _, m := range partitionMessages {
+			m.Topic = topic
+			messages = append(messages, m)
+		}
+	}
+
+	sort.SliceStable(messages, func(a, b int) bool {
+		return messages[a].CreatedAt.Before(messages[b].CreatedAt)
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return messages, nil
+}
+
+func (i Inspector) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+
+	for _, topic := range i.partitions.Topics() {
+		query, args := pendingQuery(topic, `
+			COUNT(*), 
+			coalesce(EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP::timestamp - MIN(created_at))), 0)`,
+		)
+
+		var partitionStats Stats
+		err := i.db.QueryRowContext(ctx, query, args...).Scan(&partitionStats.PendingMessages, &partitionStats.LagSeconds)
+		if err != nil {
+			return Stats{}, fmt.Errorf("could not get outbox stats for %s: %w", topic, err)
+		}
+
+		stats.PendingMessages += partitionStats.PendingMessages
+		stats.LagSeconds = max(stats.LagSeconds, partitionStats.LagSeconds)
+	}
+
+	return stats, nil
+}
+
+// DeleteForwarded removes messages older than retention which were already forwarded.
+func (i Inspector) DeleteForwarded(ctx context.Context, retention time.Duration) (int64, error) {
+	var deleted int64
+
+	for _, topic := range i.partitions.Topics() {
+		partitionDeleted, err := deleteForwarded(ctx, i.db, topic, retention)
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += partitionDeleted
+	}
+
+	return deleted, nil
+}
+
+func deleteForwarded(ctx context.Context, db *sqlx.DB, topic string, retention time.Duration) (int64, error) {
+	nextOffsetQuery, args := offsetsAdapter.NextOffsetQuery(topic, forwarderConsumerGroup)
+
+	res, err := db.ExecContext(ctx, fmt.Sprintf(`
+		WITH last_processed AS (
+			%s
+		)
+		DELETE FROM %s
+		WHERE 
+			created_at < CURRENT_TIMESTAMP::timestamp - make_interval(secs => $%d)
+			AND %s
+			AND (
+				transaction_id < (SELECT last_processed_transaction_id FROM last_processed)
+				OR
+				(
+					transaction_id = (SELECT last_processed_transaction_id FROM last_processed)
+					AND 
+					"offset" <= (SELECT offset_acked FROM last_processed)
+				)
+			)
+	`, nextOffsetQuery, schemaAdapter.MessagesTable(topic), len(args)+1, visibleToForwarder), append(args, retention.Seconds())...)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete forwarded outbox messages from %s: %w", topic, err)
+	}
+
+	return res.RowsAffected()
+}
+
+func pendingQuery(topic string, columns string) (string, []any) {
+	nextOffsetQuery, args := offsetsAdapter.NextOffsetQuery(topic, forwarderConsumerGroup)
+
+	return fmt.Sprintf(`
+		WITH last_processed AS (
+			%s
+		)
+		SELECT %s FROM %s
+		WHERE 
+			(
+				(
+					transaction_id = (SELECT last_processed_transaction_id FROM last_processed) 
+					AND 
+					"offset" > (SELECT offset_acked FROM last_processed)
+				)
+				OR
+				(transaction_id > (SELECT last_processed_transaction_id FROM last_processed))
+			)
+			AND %s
+	`, nextOffsetQuery, columns, schemaAdapter.MessagesTable(topic), visibleToForwarder), args
+}
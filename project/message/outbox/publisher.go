@@ -12,7 +12,8 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-func NewPublisherForDb(ctx context.Context, db *sqlx.Tx) (message.Publisher, error) {
+// NewPublisherForDb returns a publisher storing messages in the outbox partition of partitionKey.
+func NewPublisherForDb(ctx context.Context, db *sqlx.Tx, partitions Partitions, partitionKey string) (message.Publisher, error) {
 	var publisher message.Publisher
 
 	logger := log.NewWatermill(log.FromContext(ctx))
@@ -27,14 +28,14 @@ func NewPublisherForDb(ctx context.Context, db *sqlx.Tx) (message.Publisher, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to create outbox publisher: %w", err)
 	}
-	publisher = log.CorrelationPublisherDecorator{publisher}
-	publisher = observability.TracingPublisherDecorator{publisher}
+	publisher = log.CorrelationPublisherDecorator{Publisher: publisher}
+	publisher = observability.TracingPublisherDecorator{Publisher: publisher}
 
 	publisher = forwarder.NewPublisher(publisher, forwarder.PublisherConfig{
-		ForwarderTopic: topic,
+		ForwarderTopic: partitions.Topic(partitionKey),
 	})
-	publisher = log.CorrelationPublisherDecorator{publisher}
-	publisher = observability.TracingPublisherDecorator{publisher}
+	publisher = log.CorrelationPublisherDecorator{Publisher: publisher}
+	publisher = observability.TracingPublisherDecorator{Publisher: publisher}
 
 	return publisher, nil
 }
@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	pendingMessagesGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "outbox",
+			Name:      "pending_messages",
+			Help:      "The number of outbox messages waiting to be forwarded",
+		},
+	)
+
+	oldestPendingMessageAgeGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "outbox",
+			Name:      "oldest_pending_message_age_seconds",
+			Help:      "The age of the oldest outbox message waiting to be forwarded",
+		},
+	)
+)
+
+type RetentionConfig struct {
+	// Retention is how long forwarded messages are kept in the outbox table.
+	Retention time.Duration
+	// Interval is how often the cleanup runs and the outbox metrics are refreshed.
+	Interval time.Duration
+}
+
+// RetentionJob deletes forwarded outbox messages and exposes the outbox backlog as metrics.
+type RetentionJob struct {
+	inspector Inspector
+	config    RetentionConfig
+}
+
+func NewRetentionJob(inspector Inspector, config RetentionConfig) RetentionJob {
+	if config.Retention <= 0 {
+		panic("retention must be greater than 0")
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+
+	return RetentionJob{
+		inspector: inspector,
+		config:    config,
+	}
+}
+
+func (j RetentionJob) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		j.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j RetentionJob) runOnce(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	stats, err := j.inspector.Stats(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Could not get outbox stats")
+	} else {
+		pendingMessagesGauge.Set(float64(stats.PendingMessages))
+		oldestPendingMessageAgeGauge.Set(stats.LagSeconds)
+	}
+
+	deleted, err := j.inspector.DeleteForwarded(ctx, j.config.Retention)
+	if err != nil {
+		logger.WithError(err).Error("Could not clean up outbox")
+		return
+	}
+	if deleted > 0 {
+		logger.WithField("deleted", deleted).Info("Forwarded outbox messages cleaned up")
+	}
+}
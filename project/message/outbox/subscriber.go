@@ -7,19 +7,21 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-func SubscribeForPGMessages(db *sqlx.DB, logger watermill.LoggerAdapter) message.Subscriber {
+func SubscribeForPGMessages(db *sqlx.DB, logger watermill.LoggerAdapter, partitions Partitions) message.Subscriber {
 	subConfig := sql.SubscriberConfig{
-		SchemaAdapter:  sql.DefaultPostgreSQLSchema{},
-		OffsetsAdapter: sql.DefaultPostgreSQLOffsetsAdapter{},
+		SchemaAdapter:  schemaAdapter,
+		OffsetsAdapter: offsetsAdapter,
 	}
 
 	sub, err := sql.NewSubscriber(db, subConfig, logger)
 	if err != nil {
 		panic(err)
 	}
-	err = sub.SubscribeInitialize(topic)
-	if err != nil {
-		panic(err)
+	for _, topic := range partitions.Topics() {
+		err = sub.SubscribeInitialize(topic)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	return sub
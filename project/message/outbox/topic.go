@@ -1,3 +1,43 @@
 package outbox
 
+import (
+	"fmt"
+	"hash/fnv"
+)
+
 const topic = "events_to_forward"
+
+// Partitions is the number of outbox partitions. Every partition has its own table and forwarder.
+// Messages with the same partition key (for example booking ID) always land in the same partition,
+// so they are forwarded in order.
+//
+// Changing the number of partitions re-assigns keys, so it should be done only with an empty outbox.
+type Partitions int
+
+func (p Partitions) Topic(partitionKey string) string {
+	if p <= 1 {
+		return topic
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(partitionKey))
+
+	return partitionTopic(int(h.Sum32() % uint32(p)))
+}
+
+func (p Partitions) Topics() []string {
+	if p <= 1 {
+		return []string{topic}
+	}
+
+	topics := make([]string, 0, int(p))
+	for i := 0; i < int(p); i++ {
+		topics = append(topics, partitionTopic(i))
+	}
+
+	return topics
+}
+
+func partitionTopic(partition int) string {
+	return fmt.Sprintf("%s_%d", topic, partition)
+}
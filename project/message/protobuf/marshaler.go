@@ -0,0 +1,136 @@
+package protobuf
+
+import (
+	"fmt"
+
+	_ "tickets/message/protobuf/pb"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	// ContentTypeMetadataKey marks the wire format of the payload.
+	// Messages without it are JSON.
+	ContentTypeMetadataKey = "content_type"
+
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/protobuf"
+
+	protoPackage = "tickets"
+)
+
+var (
+	toJSONOptions = protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: true,
+	}
+	fromJSONOptions = protojson.UnmarshalOptions{
+		DiscardUnknown: true,
+	}
+)
+
+// Marshaler accepts both JSON and protobuf payloads, detected from the content type metadata.
+// It always marshals to JSON; PublisherDecorator converts messages published to protobuf topics.
+type Marshaler struct {
+	cqrs.CommandEventMarshaler
+}
+
+func (m Marshaler) Unmarshal(msg *message.Message, v interface{}) error {
+	jsonMsg, err := ToJSON(msg)
+	if err != nil {
+		return err
+	}
+
+	return m.CommandEventMarshaler.Unmarshal(jsonMsg, v)
+}
+
+// IsProtobuf returns true if the payload of the message is protobuf.
+func IsProtobuf(msg *message.Message) bool {
+	return msg.Metadata.Get(ContentTypeMetadataKey) == ContentTypeProtobuf
+}
+
+// ToJSON returns a copy of the message with the protobuf payload rendered as JSON.
+// JSON messages are returned unchanged.
+func ToJSON(msg *message.Message) (*message.Message, error) {
+	if !IsProtobuf(msg) {
+		return msg, nil
+	}
+
+	name := msg.Metadata.Get("name")
+
+	pbMsg, err := newMessage(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := proto.Unmarshal(msg.Payload, pbMsg); err != nil {
+		return nil, fmt.Errorf("could not unmarshal protobuf %s: %w", name, err)
+	}
+
+	payload, err := toJSONOptions.Marshal(pbMsg)
+	if err != nil {
+		return nil, fmt.Errorf("could not render %s as JSON: %w", name, err)
+	}
+
+	jsonMsg := msg.Copy()
+	jsonMsg.SetContext(msg.Context())
+	jsonMsg.Payload = payload
+	jsonMsg.Metadata.Set(ContentTypeMetadataKey, ContentTypeJSON)
+
+	return jsonMsg, nil
+}
+
+// ToProtobuf returns a copy of the JSON message encoded as protobuf.
+// Messages already encoded as protobuf are returned unchanged.
+func ToProtobuf(msg *message.Message) (*message.Message, error) {
+	if IsProtobuf(msg) {
+		return msg, nil
+	}
+
+	name := msg.Metadata.Get("name")
+
+	pbMsg, err := newMessage(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fromJSONOptions.Unmarshal(msg.Payload, pbMsg); err != nil {
+		return nil, fmt.Errorf("could not unmarshal JSON %s: %w", name, err)
+	}
+
+	payload, err := proto.Marshal(pbMsg)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal protobuf %s: %w", name, err)
+	}
+
+	pbMessage := msg.Copy()
+	pbMessage.SetContext(msg.Context())
+	pbMessage.Payload = payload
+	pbMessage.Metadata.Set(ContentTypeMetadataKey, ContentTypeProtobuf)
+
+	return pbMessage, nil
+}
+
+// HasDefinition returns true if the message with the name (for example `BookingMade_v1`) has a protobuf definition.
+func HasDefinition(name string) bool {
+	_, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(protoPackage + "." + name))
+	return err == nil
+}
+
+func newMessage(name string) (proto.Message, error) {
+	if name == "" {
+		return nil, fmt.Errorf("message has no name metadata")
+	}
+
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(protoPackage + "." + name))
+	if err != nil {
+		return nil, fmt.Errorf("no protobuf definition of %s: %w", name, err)
+	}
+
+	return messageType.New().Interface(), nil
+}
@@ -0,0 +1,80 @@
+package protobuf_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"tickets/entities"
+	"tickets/message/protobuf"
+	"tickets/schema"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllMessagesHaveDefinitions(t *testing.T) {
+	for _, msg := range schema.Messages {
+		assert.True(t, protobuf.HasDefinition(cqrs.StructName(msg)), "%s has no protobuf definition", cqrs.StructName(msg))
+	}
+}
+
+func TestMarshaler_roundTrip(t *testing.T) {
+	marshaler := protobuf.Marshaler{
+		CommandEventMarshaler: cqrs.JSONMarshaler{GenerateName: cqrs.StructName},
+	}
+
+	testCases := []any{
+		&entities.BookingMade_v1{
+			Header:          entities.NewEventHeader(),
+			NumberOfTickets: 3,
+			BookingID:       uuid.New(),
+			CustomerEmail:   "email@example.com",
+			ShowId:          uuid.New(),
+		},
+		&entities.TicketReceiptIssued_v1{
+			Header:        entities.NewEventHeader(),
+			TicketID:      uuid.NewString(),
+			ReceiptNumber: "receipt-1",
+			IssuedAt:      time.Now().UTC().Truncate(time.Microsecond),
+		},
+		&entities.FlightBooked_v1{
+			Header:      entities.NewEventHeader(),
+			FlightID:    uuid.New(),
+			TicketIDs:   []uuid.UUID{uuid.New(), uuid.New()},
+			ReferenceID: uuid.NewString(),
+		},
+		&entities.BookFlight{
+			CustomerEmail:  "email@example.com",
+			FlightID:       uuid.New(),
+			Passengers:     []string{"Jane", "John"},
+			ReferenceID:    uuid.NewString(),
+			IdempotencyKey: uuid.NewString(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(cqrs.StructName(tc), func(t *testing.T) {
+			msg, err := marshaler.Marshal(tc)
+			require.NoError(t, err)
+
+			pbMsg, err := protobuf.ToProtobuf(msg)
+			require.NoError(t, err)
+			assert.True(t, protobuf.IsProtobuf(pbMsg))
+			assert.Less(t, len(pbMsg.Payload), len(msg.Payload))
+
+			for _, m := range []*message.Message{msg, pbMsg} {
+				unmarshaled := reflect.New(reflect.TypeOf(tc).Elem()).Interface()
+				require.NoError(t, marshaler.Unmarshal(m, unmarshaled))
+				assert.Equal(t, tc, unmarshaled)
+			}
+
+			jsonMsg, err := protobuf.ToJSON(pbMsg)
+			require.NoError(t, err)
+			assert.True(t, json.Valid(jsonMsg.Payload))
+		})
+	}
+}
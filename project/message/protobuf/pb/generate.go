@@ -0,0 +1,4 @@
+// Package pb contains protobuf definitions of the events and commands from the entities package.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative common.proto events.proto commands.proto
@@ -0,0 +1,38 @@
+package protobuf
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// PublisherDecorator encodes messages published to the configured topics as protobuf.
+// Messages to other topics are published in the format they were marshaled in.
+type PublisherDecorator struct {
+	message.Publisher
+	Topics map[string]bool
+}
+
+func NewPublisherDecorator(publisher message.Publisher, protobufTopics []string) PublisherDecorator {
+	topics := map[string]bool{}
+	for _, topic := range protobufTopics {
+		topics[topic] = true
+	}
+
+	return PublisherDecorator{Publisher: publisher, Topics: topics}
+}
+
+func (p PublisherDecorator) Publish(topic string, messages ...*message.Message) error {
+	if !p.Topics[topic] {
+		return p.Publisher.Publish(topic, messages...)
+	}
+
+	encoded := make([]*message.Message, 0, len(messages))
+	for _, msg := range messages {
+		pbMsg, err := ToProtobuf(msg)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, pbMsg)
+	}
+
+	return p.Publisher.Publish(topic, encoded...)
+}
@@ -1,40 +1,37 @@
 package message
 
 import (
-	observability "tickets/trace"
+	"fmt"
 
-	"github.com/ThreeDotsLabs/go-event-driven/common/log"
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-redisstream/pkg/redisstream"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/redis/go-redis/v9"
 )
 
-func NewRedisPublisher(rdb *redis.Client, watermillLogger watermill.LoggerAdapter) message.Publisher {
-	var pub message.Publisher
+func NewRedisPublisher(rdb *redis.Client, watermillLogger watermill.LoggerAdapter) (message.Publisher, error) {
 	pub, err := redisstream.NewPublisher(redisstream.PublisherConfig{
 		Client: rdb,
 	}, watermillLogger)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not create redis publisher: %w", err)
 	}
-	pub = log.CorrelationPublisherDecorator{pub}
 
-	pubTracer := observability.TracingPublisherDecorator{
-		Publisher: pub,
-	}
-	return pubTracer
+	return pub, nil
 }
-func NewRedisSubscriber(rdb *redis.Client, watermillLogger watermill.LoggerAdapter) message.Subscriber {
+
+func NewRedisSubscriber(rdb *redis.Client, consumerGroup string, watermillLogger watermill.LoggerAdapter) (message.Subscriber, error) {
 	sub, err := redisstream.NewSubscriber(redisstream.SubscriberConfig{
-		Client: rdb,
+		Client:        rdb,
+		ConsumerGroup: consumerGroup,
 	}, watermillLogger)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not create redis subscriber for consumer group %q: %w", consumerGroup, err)
 	}
 
-	return sub
+	return sub, nil
 }
+
 func NewRedisClient(addr string) *redis.Client {
 	return redis.NewClient(&redis.Options{
 		Addr: addr,
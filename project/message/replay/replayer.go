@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"tickets/entities"
+	"tickets/message/upcast"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/lithammer/shortuuid/v3"
+)
+
+const (
+	// ReplayedMetadataKey is set to "true" for events republished from the data lake.
+	ReplayedMetadataKey = "replayed"
+	// TargetMetadataKey limits the replayed event to the handler (consumer group) with the name.
+	TargetMetadataKey = "replay_target"
+
+	correlationIDMetadataKey = "correlation_id"
+)
+
+func IsReplayed(msg *message.Message) bool {
+	return msg.Metadata.Get(ReplayedMetadataKey) == "true"
+}
+
+type Events interface {
+	Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error
+}
+
+type Config struct {
+	// EventNames are the newest versions of events, older versions are replayed upcasted.
+	EventNames []string
+	From       *time.Time
+	To         *time.Time
+
+	// Target is the name of the only handler which should handle the replayed events. Empty targets all handlers.
+	Target string
+}
+
+type Result struct {
+	CorrelationID  string
+	EventsReplayed int
+}
+
+// Replayer republishes events from the data lake to the events.<name> topics,
+// in the order they were published, with a correlation ID shared by the whole replay.
+type Replayer struct {
+	events    Events
+	publisher message.Publisher
+}
+
+func NewReplayer(events Events, publisher message.Publisher) Replayer {
+	if events == nil {
+		panic("events is nil")
+	}
+	if publisher == nil {
+		panic("publisher is nil")
+	}
+
+	return Replayer{
+		events:    events,
+		publisher: publisher,
+	}
+}
+
+func (r Replayer) Replay(ctx context.Context, config Config) (Result, error) {
+	result := Result{CorrelationID: "replay_" + shortuuid.New()}
+
+	var eventNames []string
+	for _, name := range config.EventNames {
+		eventNames = append(eventNames, upcast.Default.Versions(name)...)
+	}
+
+	err := r.events.Query(
+		ctx,
+		entities.EventQuery{
+			EventNames: eventNames,
+			From:       config.From,
+			To:         config.To,
+		},
+		func(event entities.Event, _ string) error {
+			msg := message.NewMessage(watermill.NewUUID(), event.EventPayload)
+			msg.Metadata.Set("name", event.EventName)
+
+			msg, err := upcast.Default.UpcastMessage(msg)
+			if err != nil {
+				return fmt.Errorf("could not upcast event %s: %w", event.EventID, err)
+			}
+
+			msg.Metadata.Set(ReplayedMetadataKey, "true")
+			msg.Metadata.Set(correlationIDMetadataKey, result.CorrelationID)
+			if config.Target != "" {
+				msg.Metadata.Set(TargetMetadataKey, config.Target)
+			}
+			msg.SetContext(ctx)
+
+			if err := r.publisher.Publish("events."+msg.Metadata.Get("name"), msg); err != nil {
+				return fmt.Errorf("could not publish event %s: %w", event.EventID, err)
+			}
+
+			result.EventsReplayed++
+			return nil
+		},
+	)
+	if err != nil {
+		return result, fmt.Errorf("could not replay events: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package replay_test
+
+import (
+	"context"
+	"testing"
+	"tickets/entities"
+	"tickets/message/replay"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEvents struct {
+	events []entities.Event
+	query  entities.EventQuery
+}
+
+func (f *fakeEvents) Query(ctx context.Context, query entities.EventQuery, fn func(event entities.Event, cursor string) error) error {
+	f.query = query
+	for _, event := range f.events {
+		if err := fn(event, event.EventID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type publishedMessage struct {
+	topic string
+	msg   *message.Message
+}
+
+type fakePublisher struct {
+	published []publishedMessage
+}
+
+func (f *fakePublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		f.published = append(f.published, publishedMessage{topic: topic, msg: msg})
+	}
+
+	return nil
+}
+
+func (f *fakePublisher) Close() error {
+	return nil
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	events := &fakeEvents{events: []entities.Event{
+		{
+			EventID:      uuid.NewString(),
+			PublishedAt:  time.Now(),
+			EventName:    "BookingMade_v0",
+			EventPayload: []byte(`{"header": {"id": "1"}, "number_of_tickets": 1, "booking_id": "` + uuid.NewString() + `"}`),
+		},
+	}}
+	publisher := &fakePublisher{}
+
+	result, err := replay.NewReplayer(events, publisher).Replay(context.Background(), replay.Config{
+		EventNames: []string{"BookingMade_v1"},
+		Target:     "AppendToTracker",
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"BookingMade_v1", "BookingMade_v0"}, events.query.EventNames)
+	assert.Equal(t, 1, result.EventsReplayed)
+
+	require.Len(t, publisher.published, 1)
+	published := publisher.published[0]
+
+	assert.Equal(t, "events.BookingMade_v1", published.topic)
+	assert.Equal(t, "BookingMade_v1", published.msg.Metadata.Get("name"))
+	assert.True(t, replay.IsReplayed(published.msg))
+	assert.Equal(t, "AppendToTracker", published.msg.Metadata.Get(replay.TargetMetadataKey))
+	assert.Equal(t, result.CorrelationID, published.msg.Metadata.Get("correlation_id"))
+}
@@ -6,23 +6,28 @@ import (
 	"tickets/entities"
 	"tickets/message/command"
 	"tickets/message/event"
-	"tickets/message/outbox"
+	"tickets/message/protobuf"
 	"tickets/message/sagas"
+	"tickets/message/upcast"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
+// Projection is a read model built with the db projection framework.
+type Projection interface {
+	EventHandlers() []cqrs.EventHandler
+}
+
 func NewWatermillRouter(
-	pgSubscriber message.Subscriber,
 	redisSub message.Subscriber,
 	commandProccesorConfig cqrs.CommandProcessorConfig,
 	publisher message.Publisher,
 	eventProcessorConfig cqrs.EventProcessorConfig,
 	commandHandler command.Handler,
 	eventHandler event.Handler,
-	opsReadModel db.OpsBookingReadModel,
+	projections []Projection,
 	dataLake db.EventRepository,
 	watermillLogger watermill.LoggerAdapter,
 	vipBundleProcessManager *sagas.VipBundleProcessManager,
@@ -34,10 +39,6 @@ func NewWatermillRouter(
 
 	useMiddlewares(router, watermillLogger)
 
-	_, err = outbox.NewForwarder(pgSubscriber, publisher, watermillLogger, router)
-	if err != nil {
-		panic(err)
-	}
 	eventProcessor, err := cqrs.NewEventProcessorWithConfig(router, eventProcessorConfig)
 	if err != nil {
 		panic(err)
@@ -55,6 +56,18 @@ func NewWatermillRouter(
 			"BookShowTickets",
 			commandHandler.BookShowTickets,
 		),
+		cqrs.NewCommandHandler(
+			"CancelBooking",
+			commandHandler.CancelBooking,
+		),
+		cqrs.NewCommandHandler(
+			"TransferBooking",
+			commandHandler.TransferBooking,
+		),
+		cqrs.NewCommandHandler(
+			"RefundBooking",
+			commandHandler.RefundBooking,
+		),
 		cqrs.NewCommandHandler(
 			"BookFlight",
 			commandHandler.BookFlight,
@@ -63,36 +76,58 @@ func NewWatermillRouter(
 			"BookTaxi",
 			commandHandler.BookTaxi,
 		),
+		cqrs.NewCommandHandler(
+			"BookHotel",
+			commandHandler.BookHotel,
+		),
 		cqrs.NewCommandHandler(
 			"CancelFlightTickets",
 			commandHandler.CancelFlightTickets,
 		),
+		cqrs.NewCommandHandler(
+			"CancelHotelBooking",
+			commandHandler.CancelHotelBooking,
+		),
+		cqrs.NewCommandHandler(
+			"CancelTaxiBooking",
+			commandHandler.CancelTaxiBooking,
+		),
+		cqrs.NewCommandHandler(
+			"vip_bundle_process_manager.CancelVipBundle",
+			vipBundleProcessManager.CancelVipBundle,
+		),
+		cqrs.NewCommandHandler(
+			"vip_bundle_process_manager.TimeOutVipBundleStep",
+			vipBundleProcessManager.TimeOutVipBundleStep,
+		),
 	)
 	if err != nil {
 		panic(err)
 	}
 
-	err = eventProcessor.AddHandlers(
-		cqrs.NewEventHandler(
+	// replayed events must not repeat calls to receipts, Dead Nation, the file service, spreadsheets
+	// or (through the VIP bundle commands) payments
+	eventHandlers := []cqrs.EventHandler{
+		withSideEffects(cqrs.NewEventHandler(
 			"BookPlaceInDeadNation",
 			eventHandler.BookTicketToDeadNotion,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"AppendToTracker",
 			eventHandler.AppendToTracker,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"TicketRefundToSheet",
 			eventHandler.TicketRefundToSheet,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"IssueReceipt",
 			eventHandler.IssueReceipt,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"PrintTicketHandler",
 			eventHandler.StoreTicketsInFile,
-		),
+		)),
 		cqrs.NewEventHandler(
 			"StoreTickets",
 			eventHandler.StoreTickets,
@@ -101,69 +136,80 @@ func NewWatermillRouter(
 			"RemoveCanceledTicket",
 			eventHandler.DeleteTicketCancel,
 		),
-		cqrs.NewEventHandler(
-			"ops_read_model.OnBookingMade",
-			opsReadModel.OnBookingMade,
-		),
-		cqrs.NewEventHandler(
-			"ops_read_model.IssueReceiptHandler",
-			opsReadModel.OnTicketReceiptIssued,
-		),
-		cqrs.NewEventHandler(
-			"ops_read_model.OnTicketBookingConfirmed",
-			opsReadModel.OnTicketBookingConfirmed,
-		),
-		cqrs.NewEventHandler(
-			"ops_read_model.OnTicketPrinted",
-			opsReadModel.OnTicketPrinted,
-		),
-		cqrs.NewEventHandler(
-			"ops_read_model.OnTicketRefunded",
-			opsReadModel.OnTicketRefunded,
-		),
-		cqrs.NewEventHandler(
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnVipBundleInitialized",
 			vipBundleProcessManager.OnVipBundleInitialized,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnBookingMade",
 			vipBundleProcessManager.OnBookingMade,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnTicketBookingConfirmed",
 			vipBundleProcessManager.OnTicketBookingConfirmed,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnBookingFailed",
 			vipBundleProcessManager.OnBookingFailed,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnFlightBooked",
 			vipBundleProcessManager.OnFlightBooked,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnFlightBookingFailed",
 			vipBundleProcessManager.OnFlightBookingFailed,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
+			"vip_bundle_process_manager.OnHotelBooked",
+			vipBundleProcessManager.OnHotelBooked,
+		)),
+		withSideEffects(cqrs.NewEventHandler(
+			"vip_bundle_process_manager.OnHotelBookingFailed",
+			vipBundleProcessManager.OnHotelBookingFailed,
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnTaxiBooked",
 			vipBundleProcessManager.OnTaxiBooked,
-		),
-		cqrs.NewEventHandler(
+		)),
+		withSideEffects(cqrs.NewEventHandler(
 			"vip_bundle_process_manager.OnTaxiBookingFailed",
 			vipBundleProcessManager.OnTaxiBookingFailed,
-		),
-	)
+		)),
+		withSideEffects(cqrs.NewEventHandler(
+			"vip_bundle_process_manager.OnVipBundleStepTimedOut",
+			vipBundleProcessManager.OnVipBundleStepTimedOut,
+		)),
+	}
 
-	if err != nil {
+	router.AddMiddleware(ignoreReplayed(eventHandlers...))
+
+	if err := eventProcessor.AddHandlers(eventHandlers...); err != nil {
 		panic(err)
 	}
 
+	for _, projection := range projections {
+		if err := eventProcessor.AddHandlers(projection.EventHandlers()...); err != nil {
+			panic(err)
+		}
+	}
+
 	router.AddNoPublisherHandler(
 		"events_splitter",
 		"events",
 		redisSub,
 		func(msg *message.Message) error {
+			msg, err := protobuf.ToJSON(msg)
+			if err != nil {
+				return err
+			}
+
+			// older versions are published as the newest one, so only handlers of the newest version are needed
+			msg, err = upcast.Default.UpcastMessage(msg)
+			if err != nil {
+				return err
+			}
+
 			eventName := eventProcessorConfig.Marshaler.NameFromMessage(msg)
 			if eventName == "" {
 				return fmt.Errorf("cannot get event name from message")
@@ -177,8 +223,15 @@ func NewWatermillRouter(
 		"events",
 		redisSub,
 		func(msg *message.Message) error {
+			// the data lake keeps JSON, so it can be queried regardless of the wire format
+			msg, err := protobuf.ToJSON(msg)
+			if err != nil {
+				return err
+			}
+
 			var event entities.Event
-			eventName := eventProcessorConfig.Marshaler.NameFromMessage(msg)
+			// events are stored in the version they were published in, they are upcasted when read
+			eventName := msg.Metadata.Get("name")
 			if eventName == "" {
 				return fmt.Errorf("cannot get event name from message")
 			}
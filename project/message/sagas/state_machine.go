@@ -0,0 +1,297 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+)
+
+// State is a state of a process manager.
+type State string
+
+// Process is the persisted position of a process in its state machine.
+type Process struct {
+	State State `json:"state"`
+	// Visited are the states the process went through, in order, used to compensate them.
+	Visited []State `json:"visited_states"`
+}
+
+// IllegalTransitionError is returned when no transition from the state is triggered by the event.
+type IllegalTransitionError struct {
+	Machine string
+	State   State
+	Trigger string
+}
+
+func (e IllegalTransitionError) Error() string {
+	return fmt.Sprintf("%s: illegal transition from state %q on %s", e.Machine, e.State, e.Trigger)
+}
+
+// Guard allows a transition only if the event and the process data pass the check.
+// S is the data of the process, and E is the environment actions and guards run in.
+type Guard[S, E any] struct {
+	Name   string
+	Allows func(env E, data S, event any) bool
+}
+
+// Action is a side effect of a transition, or the compensation of a state.
+// It may record what it did in the data, which is saved with the new state.
+type Action[S, E any] struct {
+	Name string
+	Run  func(ctx context.Context, env E, data *S, event any) error
+}
+
+type StateConfig[S, E any] struct {
+	Initial bool
+	Final   bool
+	// Compensation undoes the step started when entering the state.
+	// It runs when the process is rolled back after it visited the state.
+	Compensation *Action[S, E]
+}
+
+// Transition moves the process from one of the From states to To, when triggered by the event named Trigger.
+// Transitions are checked in the order they were added, the first one with a passing guard is taken.
+type Transition[S, E any] struct {
+	From    []State
+	Trigger string
+	// To is the state the process moves to, empty keeps the process in its state.
+	To    State
+	Guard *Guard[S, E]
+	// Compensate runs compensations of the visited states, the most recent first, before the action.
+	Compensate bool
+	Action     *Action[S, E]
+}
+
+// StateMachine declares the states of a process manager and the transitions between them.
+type StateMachine[S, E any] struct {
+	name        string
+	initial     State
+	states      []State
+	configs     map[State]StateConfig[S, E]
+	transitions []Transition[S, E]
+}
+
+func NewStateMachine[S, E any](name string) *StateMachine[S, E] {
+	return &StateMachine[S, E]{
+		name:    name,
+		configs: map[State]StateConfig[S, E]{},
+	}
+}
+
+func (m *StateMachine[S, E]) State(state State, config StateConfig[S, E]) *StateMachine[S, E] {
+	if _, ok := m.configs[state]; ok {
+		panic(fmt.Sprintf("%s: state %q declared twice", m.name, state))
+	}
+	if config.Initial {
+		if m.initial != "" {
+			panic(fmt.Sprintf("%s: states %q and %q are both initial", m.name, m.initial, state))
+		}
+		m.initial = state
+	}
+
+	m.states = append(m.states, state)
+	m.configs[state] = config
+
+	return m
+}
+
+func (m *StateMachine[S, E]) Transition(transition Transition[S, E]) *StateMachine[S, E] {
+	if transition.Trigger == "" {
+		panic(fmt.Sprintf("%s: transition without trigger", m.name))
+	}
+	if len(transition.From) == 0 {
+		panic(fmt.Sprintf("%s: transition on %s from no state", m.name, transition.Trigger))
+	}
+	for _, state := range append(slices.Clone(transition.From), transition.To) {
+		if _, ok := m.configs[state]; !ok && state != "" {
+			panic(fmt.Sprintf("%s: transition on %s uses undeclared state %q", m.name, transition.Trigger, state))
+		}
+	}
+
+	m.transitions = append(m.transitions, transition)
+
+	return m
+}
+
+// Start returns a process in the initial state.
+func (m *StateMachine[S, E]) Start() Process {
+	if m.initial == "" {
+		panic(fmt.Sprintf("%s: no initial state", m.name))
+	}
+
+	return Process{State: m.initial, Visited: []State{m.initial}}
+}
+
+// NonFinalStates returns the states the process may still leave, in the order they were declared.
+func (m *StateMachine[S, E]) NonFinalStates() []State {
+	var states []State
+	for _, state := range m.states {
+		if !m.configs[state].Final {
+			states = append(states, state)
+		}
+	}
+
+	return states
+}
+
+// TriggerOf returns the name transitions use for the event (or command).
+func TriggerOf(event any) string {
+	return cqrs.StructName(event)
+}
+
+// Effects are the compensations and action of a transition, run before the process is saved.
+type Effects[S, E any] struct {
+	From State
+	To   State
+
+	compensations []*Action[S, E]
+	action        *Action[S, E]
+}
+
+func (e Effects[S, E]) Run(ctx context.Context, env E, data *S, event any) error {
+	for _, compensation := range e.compensations {
+		if err := compensation.Run(ctx, env, data, event); err != nil {
+			return fmt.Errorf("could not compensate %s: %w", compensation.Name, err)
+		}
+	}
+
+	if e.action != nil {
+		if err := e.action.Run(ctx, env, data, event); err != nil {
+			return fmt.Errorf("could not %s: %w", e.action.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Fire moves the process to the state the event triggers, and returns the effects of the transition.
+// The process is left unchanged when it fails with IllegalTransitionError.
+func (m *StateMachine[S, E]) Fire(env E, process *Process, data S, event any) (Effects[S, E], error) {
+	trigger := TriggerOf(event)
+
+	for _, t := range m.transitions {
+		if t.Trigger != trigger || !slices.Contains(t.From, process.State) {
+			continue
+		}
+		if t.Guard != nil && !t.Guard.Allows(env, data, event) {
+			continue
+		}
+
+		effects := Effects[S, E]{From: process.State, To: process.State, action: t.Action}
+
+		if t.Compensate {
+			for i := len(process.Visited) - 1; i >= 0; i-- {
+				if compensation := m.configs[process.Visited[i]].Compensation; compensation != nil {
+					effects.compensations = append(effects.compensations, compensation)
+				}
+			}
+		}
+
+		if t.To != "" && t.To != process.State {
+			effects.To = t.To
+			process.State = t.To
+			process.Visited = append(process.Visited, t.To)
+		}
+
+		return effects, nil
+	}
+
+	return Effects[S, E]{}, IllegalTransitionError{Machine: m.name, State: process.State, Trigger: trigger}
+}
+
+func (m *StateMachine[S, E]) edgeLabel(t Transition[S, E]) string {
+	label := t.Trigger
+	if t.Guard != nil {
+		label += " [" + t.Guard.Name + "]"
+	}
+
+	var effects []string
+	if t.Compensate {
+		effects = append(effects, "compensate")
+	}
+	if t.Action != nil {
+		effects = append(effects, t.Action.Name)
+	}
+	if len(effects) > 0 {
+		label += " / " + strings.Join(effects, ", ")
+	}
+
+	return label
+}
+
+// Graphviz returns the state machine in the DOT language.
+func (m *StateMachine[S, E]) Graphviz() string {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "digraph %q {\n", m.name)
+	fmt.Fprintf(b, "\trankdir=LR;\n")
+	fmt.Fprintf(b, "\tnode [shape=box, style=rounded];\n")
+	fmt.Fprintf(b, "\t\"__start\" [shape=point];\n")
+
+	for _, state := range m.states {
+		config := m.configs[state]
+
+		label := string(state)
+		if config.Compensation != nil {
+			label += "\\ncompensation: " + config.Compensation.Name
+		}
+		attributes := fmt.Sprintf("label=\"%s\"", label)
+		if config.Final {
+			attributes += ", peripheries=2"
+		}
+
+		fmt.Fprintf(b, "\t%q [%s];\n", state, attributes)
+	}
+
+	if m.initial != "" {
+		fmt.Fprintf(b, "\t\"__start\" -> %q;\n", m.initial)
+	}
+	for _, t := range m.transitions {
+		for _, from := range t.From {
+			to := t.To
+			if to == "" {
+				to = from
+			}
+			fmt.Fprintf(b, "\t%q -> %q [label=%q];\n", from, to, m.edgeLabel(t))
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid returns the state machine as a Mermaid state diagram.
+func (m *StateMachine[S, E]) Mermaid() string {
+	b := &strings.Builder{}
+
+	b.WriteString("stateDiagram-v2\n")
+
+	if m.initial != "" {
+		fmt.Fprintf(b, "\t[*] --> %s\n", m.initial)
+	}
+	for _, t := range m.transitions {
+		for _, from := range t.From {
+			to := t.To
+			if to == "" {
+				to = from
+			}
+			fmt.Fprintf(b, "\t%s --> %s : %s\n", from, to, m.edgeLabel(t))
+		}
+	}
+
+	for _, state := range m.states {
+		config := m.configs[state]
+		if config.Final {
+			fmt.Fprintf(b, "\t%s --> [*]\n", state)
+		}
+		if config.Compensation != nil {
+			fmt.Fprintf(b, "\tnote right of %s : compensation - %s\n", state, config.Compensation.Name)
+		}
+	}
+
+	return b.String()
+}
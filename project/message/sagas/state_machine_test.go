@@ -0,0 +1,175 @@
+package sagas_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"tickets/message/sagas"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderPlaced struct{}
+type paymentReceived struct{ Amount int }
+type orderShipped struct{}
+type orderCanceled struct{}
+
+type order struct {
+	Total int
+}
+
+type log struct {
+	entries []string
+}
+
+type orderAction = sagas.Action[order, *log]
+
+func record(entry string) *orderAction {
+	return &orderAction{
+		Name: entry,
+		Run: func(_ context.Context, l *log, _ *order, _ any) error {
+			l.entries = append(l.entries, entry)
+			return nil
+		},
+	}
+}
+
+func newOrderStateMachine() *sagas.StateMachine[order, *log] {
+	m := sagas.NewStateMachine[order, *log]("order").
+		State("placed", sagas.StateConfig[order, *log]{Initial: true, Compensation: record("release stock")}).
+		State("paid", sagas.StateConfig[order, *log]{Compensation: record("refund")}).
+		State("shipped", sagas.StateConfig[order, *log]{Final: true}).
+		State("canceled", sagas.StateConfig[order, *log]{Final: true})
+
+	m.Transition(sagas.Transition[order, *log]{
+		From:    []sagas.State{"placed"},
+		Trigger: sagas.TriggerOf(paymentReceived{}),
+		To:      "paid",
+		Guard: &sagas.Guard[order, *log]{
+			Name: "paid in full",
+			Allows: func(_ *log, o order, event any) bool {
+				return event.(paymentReceived).Amount >= o.Total
+			},
+		},
+		Action: record("ship"),
+	})
+	m.Transition(sagas.Transition[order, *log]{
+		From:    []sagas.State{"placed"},
+		Trigger: sagas.TriggerOf(paymentReceived{}),
+		Action:  record("ask for the rest"),
+	})
+	m.Transition(sagas.Transition[order, *log]{
+		From:    []sagas.State{"paid"},
+		Trigger: sagas.TriggerOf(orderShipped{}),
+		To:      "shipped",
+	})
+	m.Transition(sagas.Transition[order, *log]{
+		From:       []sagas.State{"placed", "paid"},
+		Trigger:    sagas.TriggerOf(orderCanceled{}),
+		To:         "canceled",
+		Compensate: true,
+		Action:     record("notify customer"),
+	})
+
+	return m
+}
+
+func TestStateMachine(t *testing.T) {
+	m := newOrderStateMachine()
+	ctx := context.Background()
+	o := order{Total: 100}
+
+	fire := func(process *sagas.Process, event any) ([]string, error) {
+		l := &log{}
+		effects, err := m.Fire(l, process, o, event)
+		if err != nil {
+			return nil, err
+		}
+		require.NoError(t, effects.Run(ctx, l, &o, event))
+		return l.entries, nil
+	}
+
+	t.Run("guards", func(t *testing.T) {
+		process := m.Start()
+
+		entries, err := fire(&process, paymentReceived{Amount: 50})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ask for the rest"}, entries)
+		assert.Equal(t, sagas.State("placed"), process.State)
+
+		entries, err = fire(&process, paymentReceived{Amount: 100})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ship"}, entries)
+		assert.Equal(t, sagas.State("paid"), process.State)
+	})
+
+	t.Run("compensations", func(t *testing.T) {
+		process := m.Start()
+
+		_, err := fire(&process, paymentReceived{Amount: 100})
+		require.NoError(t, err)
+
+		entries, err := fire(&process, orderCanceled{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"refund", "release stock", "notify customer"}, entries)
+		assert.Equal(t, []sagas.State{"placed", "paid", "canceled"}, process.Visited)
+	})
+
+	t.Run("illegal_transition", func(t *testing.T) {
+		process := m.Start()
+
+		_, err := fire(&process, orderShipped{})
+
+		var illegal sagas.IllegalTransitionError
+		require.True(t, errors.As(err, &illegal))
+		assert.Equal(t, sagas.IllegalTransitionError{Machine: "order", State: "placed", Trigger: "orderShipped"}, illegal)
+		assert.Equal(t, sagas.State("placed"), process.State)
+	})
+}
+
+func TestStateMachine_undeclaredState(t *testing.T) {
+	assert.Panics(t, func() {
+		sagas.NewStateMachine[order, *log]("order").
+			State("placed", sagas.StateConfig[order, *log]{Initial: true}).
+			Transition(sagas.Transition[order, *log]{
+				From:    []sagas.State{"placed"},
+				Trigger: sagas.TriggerOf(orderShipped{}),
+				To:      "shipped",
+			})
+	})
+}
+
+func TestStateMachine_export(t *testing.T) {
+	m := newOrderStateMachine()
+
+	mermaid := m.Mermaid()
+	assert.Contains(t, mermaid, "[*] --> placed\n")
+	assert.Contains(t, mermaid, "placed --> paid : paymentReceived [paid in full] / ship\n")
+	assert.Contains(t, mermaid, "paid --> canceled : orderCanceled / compensate, notify customer\n")
+	assert.Contains(t, mermaid, "shipped --> [*]\n")
+	assert.Contains(t, mermaid, "note right of paid : compensation - refund\n")
+
+	graphviz := m.Graphviz()
+	assert.Contains(t, graphviz, `"placed" -> "placed" [label="paymentReceived / ask for the rest"];`)
+	assert.Contains(t, graphviz, `"shipped" [label="shipped", peripheries=2];`)
+}
+
+func TestVipBundleStateMachine_export(t *testing.T) {
+	mermaid := sagas.VipBundleStateMachine.Mermaid()
+
+	assert.Contains(t, mermaid, "booking_tickets --> booking_inbound_flight : BookingMade_v1 / book inbound flight\n")
+	assert.Contains(t, mermaid, "booking_taxi --> failed : TaxiBookingFailed_v1 / compensate\n")
+}
+
+func TestVipBundleStateMachine_operatorActions(t *testing.T) {
+	mermaid := sagas.VipBundleStateMachine.Mermaid()
+
+	assert.Contains(t, mermaid, "booking_hotel --> booking_hotel : ResendVipBundleStep / resend step\n")
+	assert.Contains(t, mermaid, "booking_tickets --> failed : ForceVipBundleRollback / compensate\n")
+	assert.Contains(t, mermaid, "failed --> resolved : ResolveVipBundle / cancel step timeouts\n")
+	assert.NotContains(t, mermaid, "finalized --> resolved")
+
+	assert.NotContains(t, sagas.VipBundleStateMachine.NonFinalStates(), sagas.VipBundleResolved)
+	assert.Contains(t, sagas.VipBundleStateMachine.NonFinalStates(), sagas.VipBundleBookingHotel)
+}
@@ -0,0 +1,28 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type StepTimeoutsConfig struct {
+	// Timeout is how long a step of the VIP bundle may take before it's retried.
+	Timeout time.Duration
+	// MaxRetries is how many times a timed-out step is retried before the VIP bundle is rolled back.
+	MaxRetries int
+}
+
+// CommandScheduler sends commands later, scheduled and cancelled in the transaction of the process manager.
+type CommandScheduler interface {
+	SendAt(ctx context.Context, cmd any, at time.Time, options ...scheduler.Option) error
+	Cancel(ctx context.Context, key string) error
+}
+
+// stepTimeoutKey identifies the timeout of the step, scheduling it again replaces the previous one.
+func stepTimeoutKey(vipBundleID uuid.UUID, step string) string {
+	return fmt.Sprintf("vip_bundle/%s/%s", vipBundleID, step)
+}
@@ -0,0 +1,93 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"tickets/entities"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/google/uuid"
+)
+
+// ResendVipBundleStep sends the command of the step the bundle waits for again.
+type ResendVipBundleStep struct {
+	VipBundleID uuid.UUID
+	Operator    string
+	Reason      string
+}
+
+// ForceVipBundleRollback fails the bundle and compensates its steps, even if not all tickets were confirmed yet.
+// Tickets confirmed later are refunded when they are confirmed.
+type ForceVipBundleRollback struct {
+	VipBundleID uuid.UUID
+	Operator    string
+	Reason      string
+}
+
+// ResolveVipBundle marks the bundle as resolved by hand, without compensating its steps.
+type ResolveVipBundle struct {
+	VipBundleID uuid.UUID
+	Operator    string
+	Reason      string
+}
+
+// StuckVipBundle is a bundle that is not in a final state.
+type StuckVipBundle struct {
+	VipBundle
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Stuck returns bundles that didn't reach a final state in the given time since they were created.
+func (v VipBundleProcessManager) Stuck(ctx context.Context, olderThan time.Duration) ([]StuckVipBundle, error) {
+	vbs, err := v.repository.FindInStates(ctx, VipBundleStateMachine.NonFinalStates(), time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, fmt.Errorf("could not find stuck vip bundles: %w", err)
+	}
+
+	return vbs, nil
+}
+
+func (v VipBundleProcessManager) ResendStep(ctx context.Context, cmd ResendVipBundleStep) error {
+	return v.operate(ctx, cmd.VipBundleID, entities.VipBundleOperatorActionResendStep, cmd.Operator, cmd.Reason, &cmd)
+}
+
+func (v VipBundleProcessManager) ForceRollback(ctx context.Context, cmd ForceVipBundleRollback) error {
+	return v.operate(ctx, cmd.VipBundleID, entities.VipBundleOperatorActionForceRollback, cmd.Operator, cmd.Reason, &cmd)
+}
+
+func (v VipBundleProcessManager) Resolve(ctx context.Context, cmd ResolveVipBundle) error {
+	return v.operate(ctx, cmd.VipBundleID, entities.VipBundleOperatorActionResolve, cmd.Operator, cmd.Reason, &cmd)
+}
+
+// operate fires the operator action and records it with VipBundleOperatorActionTaken_v1 in the same transaction.
+// Actions not allowed in the state of the bundle fail with IllegalTransitionError.
+func (v VipBundleProcessManager) operate(
+	ctx context.Context,
+	vipBundleID uuid.UUID,
+	action string,
+	operator string,
+	reason string,
+	trigger any,
+) error {
+	if operator == "" {
+		return fmt.Errorf("operator must be set")
+	}
+
+	return v.unitOfWork.Do(ctx, vipBundleID.String(), func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+		_, effects, err := v.transition(ctx, eventBus, commandBus, vipBundleID, trigger, nil)
+		if err != nil {
+			return err
+		}
+
+		return eventBus.Publish(ctx, entities.VipBundleOperatorActionTaken_v1{
+			Header:      entities.NewEventHeader(),
+			VipBundleID: vipBundleID,
+			Action:      action,
+			Operator:    operator,
+			Reason:      reason,
+			FromState:   string(effects.From),
+			ToState:     string(effects.To),
+		})
+	})
+}
@@ -2,12 +2,15 @@ package sagas
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"tickets/entities"
 	"time"
 
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
 	"github.com/ThreeDotsLabs/watermill/components/cqrs"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 )
 
 type VipBundle struct {
@@ -31,11 +34,37 @@ type VipBundle struct {
 	ReturnFlightBookedAt   *time.Time  `json:"return_flight_booked_at"`
 	ReturnFlightTicketsIDs []uuid.UUID `json:"return_flight_tickets_ids"`
 
+	HotelBookedAt  *time.Time `json:"hotel_booked_at"`
+	HotelBookingID *uuid.UUID `json:"hotel_booking_id"`
+
 	TaxiBookedAt  *time.Time `json:"taxi_booked_at"`
 	TaxiBookingID *uuid.UUID `json:"taxi_booking_id"`
 
-	IsFinalized bool `json:"finalized"`
-	Failed      bool `json:"failed"`
+	// Commands are the commands sent for the steps and compensations of the bundle, by name.
+	Commands map[string]SentCommand `json:"commands"`
+
+	// Process is the state of the bundle in VipBundleStateMachine.
+	Process
+}
+
+// SentCommand is a command sent by the process manager. Retries send it with the same idempotency key,
+// so external services act on it once.
+type SentCommand struct {
+	Command        string    `json:"command"`
+	IdempotencyKey string    `json:"idempotency_key"`
+	Attempt        int       `json:"attempt"`
+	SentAt         time.Time `json:"sent_at"`
+}
+
+func (vb VipBundle) commandSent(name string) bool {
+	_, ok := vb.Commands[name]
+	return ok
+}
+
+// rolledBack is true once the steps of the bundle were compensated, after a failure or cancellation.
+// Steps completing later are compensated when they complete.
+func (vb VipBundle) rolledBack() bool {
+	return vb.State == VipBundleFailed || vb.State == VipBundleCanceled
 }
 
 func NewVipBundle(
@@ -82,6 +111,7 @@ func NewVipBundle(
 		Passengers:      passengers,
 		InboundFlightID: inboundFlightID,
 		ReturnFlightID:  returnFlightID,
+		Process:         VipBundleStateMachine.Start(),
 	}, nil
 }
 
@@ -89,242 +119,354 @@ type VipBundleRepository interface {
 	Add(ctx context.Context, vipBundle VipBundle) error
 	Get(ctx context.Context, vipBundleID uuid.UUID) (VipBundle, error)
 	GetByBookingID(ctx context.Context, bookingID uuid.UUID) (VipBundle, error)
+	// FindInStates returns bundles in one of the states, created before the time, the oldest first.
+	FindInStates(ctx context.Context, states []State, createdBefore time.Time) ([]StuckVipBundle, error)
 
 	UpdateByID(
 		ctx context.Context,
-		bookingID uuid.UUID,
+		vipBundleID uuid.UUID,
 		updateFn func(vipBundle VipBundle) (VipBundle, error),
 	) (VipBundle, error)
+}
+
+type Inbox interface {
+	ProcessOnce(ctx context.Context, handlerName string, messageID string, fn func(ctx context.Context) error) error
+}
 
-	UpdateByBookingID(
+// UnitOfWork sends commands and events through the outbox, atomically with the VIP bundle updates done in fn.
+type UnitOfWork interface {
+	Do(
 		ctx context.Context,
-		bookingID uuid.UUID,
-		updateFn func(vipBundle VipBundle) (VipBundle, error),
-	) (VipBundle, error)
+		partitionKey string,
+		fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+	) error
 }
 
 type VipBundleProcessManager struct {
-	commandBus *cqrs.CommandBus
-	eventBus   *cqrs.EventBus
-	repository VipBundleRepository
+	repository     VipBundleRepository
+	inbox          Inbox
+	unitOfWork     UnitOfWork
+	scheduler      CommandScheduler
+	timeoutsConfig StepTimeoutsConfig
+	// bookHotel is false where there's no hotel partner, bundles go from the return flight to the taxi then.
+	bookHotel bool
 }
 
 func NewVipBundleProcessManager(
-	commandBus *cqrs.CommandBus,
-	eventBus *cqrs.EventBus,
 	repository VipBundleRepository,
+	inbox Inbox,
+	unitOfWork UnitOfWork,
+	scheduler CommandScheduler,
+	timeoutsConfig StepTimeoutsConfig,
+	bookHotel bool,
 ) *VipBundleProcessManager {
+	if scheduler == nil {
+		panic("scheduler is nil")
+	}
+	if timeoutsConfig.Timeout <= 0 {
+		panic("step timeout must be greater than 0")
+	}
+
 	return &VipBundleProcessManager{
-		commandBus: commandBus,
-		eventBus:   eventBus,
-		repository: repository,
+		repository:     repository,
+		inbox:          inbox,
+		unitOfWork:     unitOfWork,
+		scheduler:      scheduler,
+		timeoutsConfig: timeoutsConfig,
+		bookHotel:      bookHotel,
 	}
 }
 
 func (v VipBundleProcessManager) OnVipBundleInitialized(ctx context.Context, event *entities.VipBundleInitialized_v1) error {
-	vb, err := v.repository.Get(ctx, event.VipBundleID)
-	if err != nil {
-		return err
-	}
-
-	return v.commandBus.Send(ctx, entities.BookShowTickets{
-		BookingID:       vb.BookingID,
-		CustomerEmail:   vb.CustomerEmail,
-		NumberOfTickets: vb.NumberOfTickets,
-		ShowId:          vb.ShowId,
-	})
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnVipBundleInitialized",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, event.VipBundleID, event, nil)
+		},
+	)
 }
 
 func (v VipBundleProcessManager) OnBookingMade(ctx context.Context, event *entities.BookingMade_v1) error {
-	vb, err := v.repository.UpdateByBookingID(
+	return v.inbox.ProcessOnce(
 		ctx,
-		event.BookingID,
-		func(vipBundle VipBundle) (VipBundle, error) {
-			vipBundle.BookingMadeAt = &event.Header.PublishedAt
-			return vipBundle, nil
+		"vip_bundle_process_manager.OnBookingMade",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			vipBundleID, err := v.vipBundleIDByBookingID(ctx, event.BookingID)
+			if err != nil {
+				return err
+			}
+
+			return v.fire(ctx, vipBundleID, event, func(vb VipBundle) VipBundle {
+				vb.BookingMadeAt = &event.Header.PublishedAt
+				return vb
+			})
 		},
 	)
-	if err != nil {
-		return err
-	}
-
-	return v.commandBus.Send(ctx, entities.BookFlight{
-		CustomerEmail:  vb.CustomerEmail,
-		FlightID:       vb.InboundFlightID,
-		Passengers:     vb.Passengers,
-		ReferenceID:    vb.VipBundleID.String(),
-		IdempotencyKey: uuid.NewString(),
-	})
 }
 
+// OnTicketBookingConfirmed collects the tickets of the booking, it doesn't change the state of the bundle.
 func (v VipBundleProcessManager) OnTicketBookingConfirmed(ctx context.Context, event *entities.TicketBookingConfirmed_v1) error {
-	_, err := v.repository.UpdateByBookingID(
+	return v.inbox.ProcessOnce(
 		ctx,
-		uuid.MustParse(event.BookingID),
-		func(vipBundle VipBundle) (VipBundle, error) {
+		"vip_bundle_process_manager.OnTicketBookingConfirmed",
+		event.Header.ID,
+		func(ctx context.Context) error {
 			eventTicketID := uuid.MustParse(event.TicketID)
 
-			for _, ticketID := range vipBundle.TicketIDs {
-				if ticketID == eventTicketID {
-					continue
-				}
+			vipBundleID, err := v.vipBundleIDByBookingID(ctx, uuid.MustParse(event.BookingID))
+			if err != nil {
+				return err
 			}
 
-			vipBundle.TicketIDs = append(vipBundle.TicketIDs, eventTicketID)
-
-			return vipBundle, nil
+			return v.unitOfWork.Do(ctx, vipBundleID.String(), func(ctx context.Context, _ *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+				_, err := v.repository.UpdateByID(
+					ctx,
+					vipBundleID,
+					func(vipBundle VipBundle) (VipBundle, error) {
+						for _, ticketID := range vipBundle.TicketIDs {
+							if ticketID == eventTicketID {
+								// the same ticket may be confirmed again with a different event
+								return vipBundle, nil
+							}
+						}
+
+						vipBundle.TicketIDs = append(vipBundle.TicketIDs, eventTicketID)
+
+						if !vipBundle.rolledBack() {
+							return vipBundle, nil
+						}
+
+						// the bundle was rolled back before the ticket was confirmed, so rolling back didn't refund it
+						return vipBundle, refundTickets(ctx, commandBus, &vipBundle, []uuid.UUID{eventTicketID})
+					},
+				)
+				return err
+			})
 		},
 	)
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func (v VipBundleProcessManager) OnBookingFailed(ctx context.Context, event *entities.BookingFailed_v1) error {
-	vb, err := v.repository.GetByBookingID(ctx, event.BookingID)
-	if err != nil {
-		return err
-	}
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnBookingFailed",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			vipBundleID, err := v.vipBundleIDByBookingID(ctx, event.BookingID)
+			if err != nil {
+				return err
+			}
 
-	return v.rollbackProcess(ctx, vb.VipBundleID)
+			return v.fire(ctx, vipBundleID, event, nil)
+		},
+	)
 }
 
 func (v VipBundleProcessManager) OnFlightBooked(ctx context.Context, event *entities.FlightBooked_v1) error {
-	vb, err := v.repository.UpdateByID(
+	return v.inbox.ProcessOnce(
 		ctx,
-		uuid.MustParse(event.ReferenceID),
-		func(vipBundle VipBundle) (VipBundle, error) {
-			if vipBundle.InboundFlightID == event.FlightID {
-				vipBundle.InboundFlightBookedAt = &event.Header.PublishedAt
-				vipBundle.InboundFlightTicketsIDs = event.TicketIDs
-			}
-			if vipBundle.ReturnFlightID == event.FlightID {
-				vipBundle.ReturnFlightBookedAt = &event.Header.PublishedAt
-				vipBundle.ReturnFlightTicketsIDs = event.TicketIDs
-			}
+		"vip_bundle_process_manager.OnFlightBooked",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, func(vb VipBundle) VipBundle {
+				if vb.InboundFlightID == event.FlightID {
+					vb.InboundFlightBookedAt = &event.Header.PublishedAt
+					vb.InboundFlightTicketsIDs = event.TicketIDs
+				}
+				if vb.ReturnFlightID == event.FlightID {
+					vb.ReturnFlightBookedAt = &event.Header.PublishedAt
+					vb.ReturnFlightTicketsIDs = event.TicketIDs
+				}
+				return vb
+			})
+		},
+	)
+}
 
-			return vipBundle, nil
+func (v VipBundleProcessManager) OnFlightBookingFailed(ctx context.Context, event *entities.FlightBookingFailed_v1) error {
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnFlightBookingFailed",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, nil)
 		},
 	)
-	if err != nil {
-		return err
-	}
+}
 
-	switch {
-	case vb.InboundFlightBookedAt != nil && vb.ReturnFlightBookedAt == nil:
-		return v.commandBus.Send(ctx, entities.BookFlight{
-			CustomerEmail:  vb.CustomerEmail,
-			FlightID:       vb.ReturnFlightID,
-			Passengers:     vb.Passengers,
-			ReferenceID:    vb.VipBundleID.String(),
-			IdempotencyKey: uuid.NewString(),
-		})
-	case vb.InboundFlightBookedAt != nil && vb.ReturnFlightBookedAt != nil:
-		return v.commandBus.Send(ctx, entities.BookTaxi{
-			CustomerEmail:      vb.CustomerEmail,
-			CustomerName:       vb.Passengers[0],
-			NumberOfPassengers: vb.NumberOfTickets,
-			ReferenceID:        vb.VipBundleID.String(),
-			IdempotencyKey:     uuid.NewString(),
-		})
-	default:
-		return fmt.Errorf(
-			"unsupported state: InboundFlightBookedAt: %v, ReturnFlightBookedAt: %v",
-			vb.InboundFlightBookedAt,
-			vb.ReturnFlightBookedAt,
-		)
-	}
+func (v VipBundleProcessManager) OnHotelBooked(ctx context.Context, event *entities.HotelBooked_v1) error {
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnHotelBooked",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, func(vb VipBundle) VipBundle {
+				vb.HotelBookedAt = &event.Header.PublishedAt
+				vb.HotelBookingID = &event.HotelBookingID
+				return vb
+			})
+		},
+	)
 }
 
-func (v VipBundleProcessManager) OnFlightBookingFailed(ctx context.Context, event *entities.FlightBookingFailed_v1) error {
-	return v.rollbackProcess(ctx, uuid.MustParse(event.ReferenceID))
+func (v VipBundleProcessManager) OnHotelBookingFailed(ctx context.Context, event *entities.HotelBookingFailed_v1) error {
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnHotelBookingFailed",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, nil)
+		},
+	)
 }
 
 func (v VipBundleProcessManager) OnTaxiBooked(ctx context.Context, event *entities.TaxiBooked_v1) error {
-	vb, err := v.repository.UpdateByID(
+	return v.inbox.ProcessOnce(
 		ctx,
-		uuid.MustParse(event.ReferenceID),
-		func(vb VipBundle) (VipBundle, error) {
-			vb.TaxiBookedAt = &event.Header.PublishedAt
-			vb.TaxiBookingID = &event.TaxiBookingID
-
-			vb.IsFinalized = true
+		"vip_bundle_process_manager.OnTaxiBooked",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, func(vb VipBundle) VipBundle {
+				vb.TaxiBookedAt = &event.Header.PublishedAt
+				vb.TaxiBookingID = &event.TaxiBookingID
+				return vb
+			})
+		},
+	)
+}
 
-			return vb, nil
+func (v VipBundleProcessManager) OnTaxiBookingFailed(ctx context.Context, event *entities.TaxiBookingFailed_v1) error {
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnTaxiBookingFailed",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, uuid.MustParse(event.ReferenceID), event, nil)
 		},
 	)
-	if err != nil {
-		return err
-	}
+}
+
+// TimeOutVipBundleStep publishes VipBundleStepTimedOut_v1 when the timeout scheduled by the step is sent.
+func (v VipBundleProcessManager) TimeOutVipBundleStep(ctx context.Context, command *entities.TimeOutVipBundleStep) error {
+	return v.unitOfWork.Do(ctx, command.VipBundleID.String(), func(ctx context.Context, eventBus *cqrs.EventBus, _ *cqrs.CommandBus) error {
+		err := eventBus.Publish(ctx, entities.VipBundleStepTimedOut_v1{
+			Header:      entities.NewEventHeader(),
+			VipBundleID: command.VipBundleID,
+			Step:        command.Step,
+			Attempt:     command.Attempt,
+		})
+		if err != nil {
+			return fmt.Errorf("could not publish timeout of vip bundle %s step %s: %w", command.VipBundleID, command.Step, err)
+		}
 
-	return v.eventBus.Publish(ctx, entities.VipBundleFinalized_v1{
-		Header:      entities.NewEventHeader(),
-		VipBundleID: vb.VipBundleID,
+		return nil
 	})
 }
 
-func (v VipBundleProcessManager) OnTaxiBookingFailed(ctx context.Context, event *entities.TaxiBookingFailed_v1) error {
-	return v.rollbackProcess(ctx, uuid.MustParse(event.ReferenceID))
+// OnVipBundleStepTimedOut retries the step, and rolls the bundle back when it's out of retries.
+// Timeouts of attempts which were already retried or completed are skipped, as scheduled commands
+// are sent at least once.
+func (v VipBundleProcessManager) OnVipBundleStepTimedOut(ctx context.Context, event *entities.VipBundleStepTimedOut_v1) error {
+	return v.inbox.ProcessOnce(
+		ctx,
+		"vip_bundle_process_manager.OnVipBundleStepTimedOut",
+		event.Header.ID,
+		func(ctx context.Context) error {
+			return v.fire(ctx, event.VipBundleID, event, nil)
+		},
+	)
+}
+
+// CancelVipBundle compensates the steps of the bundle, in any state.
+// Steps in progress are compensated when they complete.
+func (v VipBundleProcessManager) CancelVipBundle(ctx context.Context, command *entities.CancelVipBundle) error {
+	return v.fire(ctx, command.VipBundleID, command, nil)
 }
 
-func (v VipBundleProcessManager) rollbackProcess(ctx context.Context, vipBundleID uuid.UUID) error {
-	vb, err := v.repository.Get(ctx, vipBundleID)
+// vipBundleIDByBookingID finds the bundle of booking events, so all messages sent for the bundle
+// are partitioned by its ID and forwarded in order.
+func (v VipBundleProcessManager) vipBundleIDByBookingID(ctx context.Context, bookingID uuid.UUID) (uuid.UUID, error) {
+	vb, err := v.repository.GetByBookingID(ctx, bookingID)
 	if err != nil {
-		return err
+		return uuid.Nil, err
 	}
 
-	if vb.BookingMadeAt != nil {
-		if err := v.rollbackTickets(ctx, vb); err != nil {
-			return err
-		}
-	}
-	if vb.InboundFlightBookedAt != nil {
-		if err := v.commandBus.Send(ctx, entities.CancelFlightTickets{
-			FlightTicketIDs: vb.InboundFlightTicketsIDs,
-		}); err != nil {
-			return err
-		}
-	}
-	if vb.ReturnFlightBookedAt != nil {
-		if err := v.commandBus.Send(ctx, entities.CancelFlightTickets{
-			FlightTicketIDs: vb.ReturnFlightTicketsIDs,
-		}); err != nil {
-			return err
-		}
-	}
+	return vb.VipBundleID, nil
+}
 
-	_, err = v.repository.UpdateByID(
-		ctx,
-		vb.VipBundleID,
-		func(vb VipBundle) (VipBundle, error) {
-			vb.IsFinalized = true
-			vb.Failed = true
-			return vb, nil
-		},
-	)
+// fire applies the event to the bundle, moves it to the state the event triggers
+// and runs the effects of the transition, all in one transaction. Messages sent by the transition
+// are partitioned by the bundle ID, so they are forwarded in order.
+//
+// Events not allowed in the state of the bundle are logged and acked, as redelivering them can't help.
+func (v VipBundleProcessManager) fire(
+	ctx context.Context,
+	vipBundleID uuid.UUID,
+	event any,
+	apply func(vb VipBundle) VipBundle,
+) error {
+	err := v.unitOfWork.Do(ctx, vipBundleID.String(), func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error {
+		_, _, err := v.transition(ctx, eventBus, commandBus, vipBundleID, event, apply)
+		return err
+	})
+
+	var illegal IllegalTransitionError
+	if errors.As(err, &illegal) {
+		log.FromContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"vip_bundle_id": vipBundleID,
+			"state":         illegal.State,
+			"trigger":       illegal.Trigger,
+		}).Warn("Message not allowed in the state of the VIP bundle, skipping")
+		return nil
+	}
 
 	return err
 }
 
-func (v VipBundleProcessManager) rollbackTickets(ctx context.Context, vb VipBundle) error {
-	if len(vb.TicketIDs) != vb.NumberOfTickets {
-		return fmt.Errorf(
-			"invalid number of tickets, expected %d, has %d: not all of TicketBookingConfirmed_v1 events were processed",
-			vb.NumberOfTickets,
-			len(vb.TicketIDs),
-		)
+// transition does the work of fire in the transaction of the unit of work.
+func (v VipBundleProcessManager) transition(
+	ctx context.Context,
+	eventBus *cqrs.EventBus,
+	commandBus *cqrs.CommandBus,
+	vipBundleID uuid.UUID,
+	event any,
+	apply func(vb VipBundle) VipBundle,
+) (VipBundle, Effects[VipBundle, vipBundleEnv], error) {
+	env := vipBundleEnv{
+		eventBus:       eventBus,
+		commandBus:     commandBus,
+		scheduler:      v.scheduler,
+		timeoutsConfig: v.timeoutsConfig,
+		bookHotel:      v.bookHotel,
 	}
 
-	for _, ticketID := range vb.TicketIDs {
-		if err := v.commandBus.Send(ctx, entities.RefundTicket{
-			Header:   entities.NewEventHeader(),
-			TicketID: ticketID.String(),
-		}); err != nil {
-			return err
+	var effects Effects[VipBundle, vipBundleEnv]
+	vb, err := v.repository.UpdateByID(ctx, vipBundleID, func(vb VipBundle) (VipBundle, error) {
+		if apply != nil {
+			vb = apply(vb)
 		}
-	}
 
-	return nil
+		var err error
+		effects, err = VipBundleStateMachine.Fire(env, &vb.Process, vb, event)
+		if err != nil {
+			return vb, err
+		}
+
+		if effects.From != effects.To {
+			// the step of the state is done, completed or compensated
+			if step, ok := vipBundleSteps[effects.From]; ok {
+				if err := v.scheduler.Cancel(ctx, stepTimeoutKey(vb.VipBundleID, step)); err != nil {
+					return vb, err
+				}
+			}
+		}
+
+		// effects record the commands they send on the bundle, so they run before it's saved
+		return vb, effects.Run(ctx, env, &vb, event)
+	})
+
+	return vb, effects, err
 }
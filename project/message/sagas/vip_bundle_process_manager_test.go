@@ -0,0 +1,661 @@
+package sagas_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"tickets/entities"
+	"tickets/message/command"
+	"tickets/message/event"
+	"tickets/message/sagas"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVipBundleProcessManager_redelivery(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+	ticketID := uuid.New()
+
+	// every event is delivered twice, with different IDs, as when the command that published it is redelivered
+	for range 2 {
+		require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+			Header:      entities.NewEventHeader(),
+			VipBundleID: vb.VipBundleID,
+		}))
+	}
+	for range 2 {
+		require.NoError(t, h.pm.OnTicketBookingConfirmed(ctx, &entities.TicketBookingConfirmed_v1{
+			Header:    entities.NewEventHeader(),
+			TicketID:  ticketID.String(),
+			BookingID: vb.BookingID.String(),
+		}))
+	}
+	for range 2 {
+		require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+			Header:    entities.NewEventHeader(),
+			BookingID: vb.BookingID,
+		}))
+	}
+	for _, flightID := range []uuid.UUID{vb.InboundFlightID, vb.ReturnFlightID} {
+		for range 2 {
+			require.NoError(t, h.pm.OnFlightBooked(ctx, &entities.FlightBooked_v1{
+				Header:      entities.NewEventHeader(),
+				FlightID:    flightID,
+				TicketIDs:   []uuid.UUID{uuid.New()},
+				ReferenceID: vb.VipBundleID.String(),
+			}))
+		}
+	}
+	for range 2 {
+		require.NoError(t, h.pm.OnHotelBooked(ctx, &entities.HotelBooked_v1{
+			Header:         entities.NewEventHeader(),
+			HotelBookingID: uuid.New(),
+			ReferenceID:    vb.VipBundleID.String(),
+		}))
+	}
+	for range 2 {
+		require.NoError(t, h.pm.OnTaxiBooked(ctx, &entities.TaxiBooked_v1{
+			Header:        entities.NewEventHeader(),
+			TaxiBookingID: uuid.New(),
+			ReferenceID:   vb.VipBundleID.String(),
+		}))
+	}
+
+	assert.Equal(t, map[string]int{
+		"BookShowTickets":       1,
+		"BookFlight":            2, // inbound and return
+		"BookHotel":             1,
+		"BookTaxi":              1,
+		"VipBundleFinalized_v1": 1,
+	}, h.published.counts())
+	assert.Equal(t, []string{vb.VipBundleID.String()}, h.published.partitionKeys(), "messages of the bundle must be sent in order")
+
+	var flights []entities.BookFlight
+	h.published.decode(t, "BookFlight", func(payload []byte) {
+		var cmd entities.BookFlight
+		require.NoError(t, json.Unmarshal(payload, &cmd))
+		flights = append(flights, cmd)
+	})
+	require.Len(t, flights, 2)
+	assert.NotEqual(t, flights[0].IdempotencyKey, flights[1].IdempotencyKey, "flights are distinct steps")
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleFinalized, saved.State)
+	assert.Equal(t, flights[0].IdempotencyKey, saved.Commands["inbound_flight"].IdempotencyKey)
+}
+
+func TestVipBundleProcessManager_retryKeepsIdempotencyKey(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	}))
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+	require.NoError(t, h.pm.OnVipBundleStepTimedOut(ctx, &entities.VipBundleStepTimedOut_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+		Step:        "inbound_flight",
+		Attempt:     0,
+	}))
+	require.NoError(t, h.pm.ResendStep(ctx, sagas.ResendVipBundleStep{
+		VipBundleID: vb.VipBundleID,
+		Operator:    "operator",
+	}))
+
+	var keys []string
+	h.published.decode(t, "BookFlight", func(payload []byte) {
+		var cmd entities.BookFlight
+		require.NoError(t, json.Unmarshal(payload, &cmd))
+		keys = append(keys, cmd.IdempotencyKey)
+	})
+	require.Len(t, keys, 3)
+	assert.Equal(t, keys[0], keys[1], "retry must not book the flight again")
+	assert.Equal(t, keys[0], keys[2], "resend must not book the flight again")
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, saved.Commands["inbound_flight"].Attempt)
+}
+
+func TestVipBundleProcessManager_retryReusesRecordedIdempotencyKey(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	}))
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+
+	// the step was sent with a key which isn't derived from the bundle, as by older versions of the service
+	_, err := h.repo.UpdateByID(ctx, vb.VipBundleID, func(vb sagas.VipBundle) (sagas.VipBundle, error) {
+		sent := vb.Commands["inbound_flight"]
+		sent.IdempotencyKey = "recorded-key"
+		vb.Commands["inbound_flight"] = sent
+		return vb, nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, h.pm.OnVipBundleStepTimedOut(ctx, &entities.VipBundleStepTimedOut_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+		Step:        "inbound_flight",
+		Attempt:     0,
+	}))
+
+	var keys []string
+	h.published.decode(t, "BookFlight", func(payload []byte) {
+		var cmd entities.BookFlight
+		require.NoError(t, json.Unmarshal(payload, &cmd))
+		keys = append(keys, cmd.IdempotencyKey)
+	})
+	require.Len(t, keys, 2)
+	assert.Equal(t, "recorded-key", keys[1])
+}
+
+func TestVipBundleProcessManager_stepTimeouts(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	}))
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+
+	bookingKey := fmt.Sprintf("vip_bundle/%s/booking", vb.VipBundleID)
+	inboundFlightKey := fmt.Sprintf("vip_bundle/%s/inbound_flight", vb.VipBundleID)
+
+	_, ok := h.scheduler.get(bookingKey)
+	assert.False(t, ok, "timeout of the completed step must be cancelled")
+
+	timeout := &entities.TimeOutVipBundleStep{VipBundleID: vb.VipBundleID, Step: "inbound_flight", Attempt: 0}
+	require.NoError(t, h.pm.TimeOutVipBundleStep(ctx, timeout))
+	assert.Equal(t, 1, h.published.counts()["VipBundleStepTimedOut_v1"])
+
+	// the scheduler sends commands at least once, so the timeout may be published twice
+	for i := 0; i < 2; i++ {
+		require.NoError(t, h.pm.OnVipBundleStepTimedOut(ctx, &entities.VipBundleStepTimedOut_v1{
+			Header:      entities.NewEventHeader(),
+			VipBundleID: vb.VipBundleID,
+			Step:        "inbound_flight",
+			Attempt:     0,
+		}))
+	}
+
+	assert.Equal(t, 2, h.published.counts()["BookFlight"], "redelivered timeout must not retry the step again")
+
+	scheduled, ok := h.scheduler.get(inboundFlightKey)
+	require.True(t, ok)
+	assert.Equal(t, entities.TimeOutVipBundleStep{VipBundleID: vb.VipBundleID, Step: "inbound_flight", Attempt: 1}, scheduled)
+}
+
+func TestVipBundleProcessManager_compensationRedelivery(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnTicketBookingConfirmed(ctx, &entities.TicketBookingConfirmed_v1{
+		Header:    entities.NewEventHeader(),
+		TicketID:  uuid.NewString(),
+		BookingID: vb.BookingID.String(),
+	}))
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+	require.NoError(t, h.pm.OnFlightBooked(ctx, &entities.FlightBooked_v1{
+		Header:      entities.NewEventHeader(),
+		FlightID:    vb.InboundFlightID,
+		TicketIDs:   []uuid.UUID{uuid.New()},
+		ReferenceID: vb.VipBundleID.String(),
+	}))
+
+	for range 2 {
+		require.NoError(t, h.pm.OnFlightBookingFailed(ctx, &entities.FlightBookingFailed_v1{
+			Header:        entities.NewEventHeader(),
+			FlightID:      vb.ReturnFlightID,
+			FailureReason: "no seats left",
+			ReferenceID:   vb.VipBundleID.String(),
+		}))
+	}
+	// the return flight was booked after all, after the bundle was rolled back
+	lateFlight := &entities.FlightBooked_v1{
+		Header:      entities.NewEventHeader(),
+		FlightID:    vb.ReturnFlightID,
+		TicketIDs:   []uuid.UUID{uuid.New()},
+		ReferenceID: vb.VipBundleID.String(),
+	}
+	for range 2 {
+		require.NoError(t, h.pm.OnFlightBooked(ctx, lateFlight))
+	}
+
+	counts := h.published.counts()
+	assert.Equal(t, 1, counts["RefundTicket"])
+	assert.Equal(t, 2, counts["CancelFlightTickets"], "inbound flight compensated and late return flight canceled")
+
+	var refund entities.RefundTicket
+	h.published.decode(t, "RefundTicket", func(payload []byte) {
+		require.NoError(t, json.Unmarshal(payload, &refund))
+	})
+	h.published.decode(t, "CancelFlightTickets", func(payload []byte) {
+		var cmd entities.CancelFlightTickets
+		require.NoError(t, json.Unmarshal(payload, &cmd))
+		assert.NotEmpty(t, cmd.IdempotencyKey)
+	})
+
+	// the refund is recorded with the key it was sent with
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleFailed, saved.State)
+	assert.Equal(t, saved.Commands["refund_ticket/"+refund.TicketID].IdempotencyKey, refund.Header.IdempotencyKey)
+}
+
+func TestVipBundleProcessManager_rollbackBeforeTicketsConfirmed(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+	require.NoError(t, h.pm.OnFlightBookingFailed(ctx, &entities.FlightBookingFailed_v1{
+		Header:        entities.NewEventHeader(),
+		FlightID:      vb.InboundFlightID,
+		FailureReason: "no seats left",
+		ReferenceID:   vb.VipBundleID.String(),
+	}))
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleFailed, saved.State)
+	assert.Zero(t, h.published.counts()["RefundTicket"])
+
+	// the ticket confirmed after the rollback is refunded when it's confirmed
+	require.NoError(t, h.pm.OnTicketBookingConfirmed(ctx, &entities.TicketBookingConfirmed_v1{
+		Header:    entities.NewEventHeader(),
+		TicketID:  uuid.NewString(),
+		BookingID: vb.BookingID.String(),
+	}))
+	assert.Equal(t, 1, h.published.counts()["RefundTicket"])
+}
+
+func TestVipBundleProcessManager_illegalTransitionIsAcked(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	}))
+
+	// the hotel can't be booked before the tickets, redelivering the event wouldn't change that
+	require.NoError(t, h.pm.OnHotelBooked(ctx, &entities.HotelBooked_v1{
+		Header:         entities.NewEventHeader(),
+		HotelBookingID: uuid.New(),
+		ReferenceID:    vb.VipBundleID.String(),
+	}))
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleBookingTickets, saved.State)
+	assert.Nil(t, saved.HotelBookingID)
+	assert.Equal(t, map[string]int{"BookShowTickets": 1}, h.published.counts())
+}
+
+func TestVipBundleProcessManager_noHotelRoomsAvailable(t *testing.T) {
+	h := newProcessManagerHarness(t)
+	ctx := context.Background()
+	vb := h.bookFlights(t)
+
+	require.NoError(t, h.pm.OnHotelBookingFailed(ctx, &entities.HotelBookingFailed_v1{
+		Header:        entities.NewEventHeader(),
+		FailureReason: entities.ErrNoHotelRoomsAvailable.Error(),
+		ReferenceID:   vb.VipBundleID.String(),
+	}))
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleFailed, saved.State)
+
+	counts := h.published.counts()
+	assert.Equal(t, 1, counts["RefundTicket"])
+	assert.Equal(t, 2, counts["CancelFlightTickets"], "both flights are canceled")
+	assert.Zero(t, counts["BookTaxi"])
+}
+
+func TestVipBundleProcessManager_withoutHotel(t *testing.T) {
+	h := newProcessManagerHarnessBookingHotel(t, false)
+	ctx := context.Background()
+	vb := h.bookFlights(t)
+
+	saved, err := h.repo.Get(ctx, vb.VipBundleID)
+	require.NoError(t, err)
+	assert.Equal(t, sagas.VipBundleBookingTaxi, saved.State)
+
+	counts := h.published.counts()
+	assert.Zero(t, counts["BookHotel"])
+	assert.Equal(t, 1, counts["BookTaxi"])
+}
+
+type processManagerHarness struct {
+	pm        *sagas.VipBundleProcessManager
+	repo      *memoryVipBundleRepository
+	published *recordingPublisher
+	scheduler *memoryScheduler
+}
+
+func newProcessManagerHarness(t *testing.T) processManagerHarness {
+	t.Helper()
+	return newProcessManagerHarnessBookingHotel(t, true)
+}
+
+func newProcessManagerHarnessBookingHotel(t *testing.T, bookHotel bool) processManagerHarness {
+	t.Helper()
+
+	repo := &memoryVipBundleRepository{bundles: map[uuid.UUID][]byte{}}
+	published := &recordingPublisher{}
+	scheduler := &memoryScheduler{commands: map[string]any{}}
+
+	pm := sagas.NewVipBundleProcessManager(
+		repo,
+		passThroughInbox{},
+		unitOfWork{publisher: published},
+		scheduler,
+		sagas.StepTimeoutsConfig{Timeout: time.Minute, MaxRetries: 2},
+		bookHotel,
+	)
+
+	return processManagerHarness{pm: pm, repo: repo, published: published, scheduler: scheduler}
+}
+
+func (h processManagerHarness) addVipBundle(t *testing.T) sagas.VipBundle {
+	t.Helper()
+
+	vb, err := sagas.NewVipBundle(
+		uuid.New(),
+		uuid.New(),
+		"email@example.com",
+		1,
+		uuid.New(),
+		[]string{"Passenger 0"},
+		uuid.New(),
+		uuid.New(),
+	)
+	require.NoError(t, err)
+	require.NoError(t, h.repo.Add(context.Background(), *vb))
+
+	return *vb
+}
+
+// bookFlights adds a bundle and completes its steps up to both flights.
+func (h processManagerHarness) bookFlights(t *testing.T) sagas.VipBundle {
+	t.Helper()
+
+	ctx := context.Background()
+	vb := h.addVipBundle(t)
+
+	require.NoError(t, h.pm.OnVipBundleInitialized(ctx, &entities.VipBundleInitialized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	}))
+	require.NoError(t, h.pm.OnTicketBookingConfirmed(ctx, &entities.TicketBookingConfirmed_v1{
+		Header:    entities.NewEventHeader(),
+		TicketID:  uuid.NewString(),
+		BookingID: vb.BookingID.String(),
+	}))
+	require.NoError(t, h.pm.OnBookingMade(ctx, &entities.BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: vb.BookingID,
+	}))
+	for _, flightID := range []uuid.UUID{vb.InboundFlightID, vb.ReturnFlightID} {
+		require.NoError(t, h.pm.OnFlightBooked(ctx, &entities.FlightBooked_v1{
+			Header:      entities.NewEventHeader(),
+			FlightID:    flightID,
+			TicketIDs:   []uuid.UUID{uuid.New()},
+			ReferenceID: vb.VipBundleID.String(),
+		}))
+	}
+
+	return vb
+}
+
+// memoryVipBundleRepository stores bundles serialized, like the database does.
+type memoryVipBundleRepository struct {
+	lock    sync.Mutex
+	bundles map[uuid.UUID][]byte
+}
+
+func (r *memoryVipBundleRepository) Add(_ context.Context, vb sagas.VipBundle) error {
+	return r.save(vb)
+}
+
+func (r *memoryVipBundleRepository) Get(_ context.Context, vipBundleID uuid.UUID) (sagas.VipBundle, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.get(vipBundleID)
+}
+
+func (r *memoryVipBundleRepository) GetByBookingID(_ context.Context, bookingID uuid.UUID) (sagas.VipBundle, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	id, err := r.idByBookingID(bookingID)
+	if err != nil {
+		return sagas.VipBundle{}, err
+	}
+
+	return r.get(id)
+}
+
+func (r *memoryVipBundleRepository) FindInStates(context.Context, []sagas.State, time.Time) ([]sagas.StuckVipBundle, error) {
+	return nil, nil
+}
+
+func (r *memoryVipBundleRepository) UpdateByID(
+	ctx context.Context,
+	vipBundleID uuid.UUID,
+	updateFn func(vipBundle sagas.VipBundle) (sagas.VipBundle, error),
+) (sagas.VipBundle, error) {
+	vb, err := r.Get(ctx, vipBundleID)
+	if err != nil {
+		return sagas.VipBundle{}, err
+	}
+
+	vb, err = updateFn(vb)
+	if err != nil {
+		return sagas.VipBundle{}, err
+	}
+
+	return vb, r.save(vb)
+}
+
+func (r *memoryVipBundleRepository) save(vb sagas.VipBundle) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	payload, err := json.Marshal(vb)
+	if err != nil {
+		return err
+	}
+	r.bundles[vb.VipBundleID] = payload
+
+	return nil
+}
+
+func (r *memoryVipBundleRepository) get(vipBundleID uuid.UUID) (sagas.VipBundle, error) {
+	payload, ok := r.bundles[vipBundleID]
+	if !ok {
+		return sagas.VipBundle{}, fmt.Errorf("%w: %s", entities.ErrVipBundleNotFound, vipBundleID)
+	}
+
+	var vb sagas.VipBundle
+	err := json.Unmarshal(payload, &vb)
+	return vb, err
+}
+
+func (r *memoryVipBundleRepository) idByBookingID(bookingID uuid.UUID) (uuid.UUID, error) {
+	for id, payload := range r.bundles {
+		var vb sagas.VipBundle
+		if err := json.Unmarshal(payload, &vb); err != nil {
+			return uuid.Nil, err
+		}
+		if vb.BookingID == bookingID {
+			return id, nil
+		}
+	}
+
+	return uuid.Nil, fmt.Errorf("%w: booking %s", entities.ErrVipBundleNotFound, bookingID)
+}
+
+// passThroughInbox doesn't deduplicate, so the process manager gets every delivery.
+type passThroughInbox struct{}
+
+func (passThroughInbox) ProcessOnce(ctx context.Context, _ string, _ string, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type unitOfWork struct {
+	publisher *recordingPublisher
+}
+
+func (u unitOfWork) Do(
+	ctx context.Context,
+	partitionKey string,
+	fn func(ctx context.Context, eventBus *cqrs.EventBus, commandBus *cqrs.CommandBus) error,
+) error {
+	publisher := partitionedPublisher{recordingPublisher: u.publisher, partitionKey: partitionKey}
+	return fn(ctx, event.NewBus(publisher), command.NewCommandBus(publisher))
+}
+
+// partitionedPublisher records the outbox partition key of the unit of work with the messages.
+type partitionedPublisher struct {
+	*recordingPublisher
+	partitionKey string
+}
+
+func (p partitionedPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		msg.Metadata.Set("partition_key", p.partitionKey)
+	}
+
+	return p.recordingPublisher.Publish(topic, messages...)
+}
+
+// memoryScheduler keeps the scheduled commands by their key, they are never sent.
+type memoryScheduler struct {
+	lock     sync.Mutex
+	commands map[string]any
+}
+
+func (s *memoryScheduler) SendAt(_ context.Context, cmd any, _ time.Time, options ...scheduler.Option) error {
+	scheduled := &scheduler.ScheduledCommand{}
+	for _, option := range options {
+		option(scheduled)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands[scheduled.Key] = cmd
+
+	return nil
+}
+
+func (s *memoryScheduler) Cancel(_ context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.commands, key)
+
+	return nil
+}
+
+func (s *memoryScheduler) get(key string) (any, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cmd, ok := s.commands[key]
+
+	return cmd, ok
+}
+
+// recordingPublisher records the commands and events sent by the process manager, each is one external call.
+type recordingPublisher struct {
+	lock     sync.Mutex
+	messages []*message.Message
+}
+
+func (p *recordingPublisher) Publish(_ string, messages ...*message.Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func (p *recordingPublisher) counts() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	counts := map[string]int{}
+	for _, msg := range p.messages {
+		counts[msg.Metadata.Get("name")]++
+	}
+
+	return counts
+}
+
+func (p *recordingPublisher) partitionKeys() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var keys []string
+	for _, msg := range p.messages {
+		keys = append(keys, msg.Metadata.Get("partition_key"))
+	}
+
+	return lo.Uniq(keys)
+}
+
+func (p *recordingPublisher) decode(t *testing.T, name string, fn func(payload []byte)) {
+	t.Helper()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, msg := range p.messages {
+		if msg.Metadata.Get("name") == name {
+			fn(msg.Payload)
+		}
+	}
+}
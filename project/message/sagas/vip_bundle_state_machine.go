@@ -0,0 +1,580 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"tickets/entities"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/google/uuid"
+)
+
+// States of the VIP bundle. Every in progress state waits for its step to complete.
+const (
+	VipBundleBookingTickets       State = "booking_tickets"
+	VipBundleBookingInboundFlight State = "booking_inbound_flight"
+	VipBundleBookingReturnFlight  State = "booking_return_flight"
+	VipBundleBookingHotel         State = "booking_hotel"
+	VipBundleBookingTaxi          State = "booking_taxi"
+	VipBundleFinalized            State = "finalized"
+	VipBundleFailed               State = "failed"
+	VipBundleCanceled             State = "canceled"
+	// VipBundleResolved is set by an operator who finished the bundle by hand, nothing is compensated.
+	VipBundleResolved State = "resolved"
+)
+
+// Steps the process manager waits for, each with its own timeout.
+const (
+	stepBooking       = "booking"
+	stepInboundFlight = "inbound_flight"
+	stepReturnFlight  = "return_flight"
+	stepHotel         = "hotel"
+	stepTaxi          = "taxi"
+)
+
+var vipBundleSteps = map[State]string{
+	VipBundleBookingTickets:       stepBooking,
+	VipBundleBookingInboundFlight: stepInboundFlight,
+	VipBundleBookingReturnFlight:  stepReturnFlight,
+	VipBundleBookingHotel:         stepHotel,
+	VipBundleBookingTaxi:          stepTaxi,
+}
+
+// vipBundleEnv is what VIP bundle actions run with, in the transaction of the transition.
+type vipBundleEnv struct {
+	eventBus       *cqrs.EventBus
+	commandBus     *cqrs.CommandBus
+	scheduler      CommandScheduler
+	timeoutsConfig StepTimeoutsConfig
+	bookHotel      bool
+}
+
+type (
+	vipBundleGuard  = Guard[VipBundle, vipBundleEnv]
+	vipBundleAction = Action[VipBundle, vipBundleEnv]
+)
+
+// VipBundleStateMachine is the process of booking a VIP bundle.
+var VipBundleStateMachine = newVipBundleStateMachine()
+
+func newVipBundleStateMachine() *StateMachine[VipBundle, vipBundleEnv] {
+	inProgress := []State{
+		VipBundleBookingTickets,
+		VipBundleBookingInboundFlight,
+		VipBundleBookingReturnFlight,
+		VipBundleBookingHotel,
+		VipBundleBookingTaxi,
+	}
+	rolledBack := []State{VipBundleFailed, VipBundleCanceled}
+	all := append(append(append([]State{}, inProgress...), VipBundleFinalized, VipBundleResolved), rolledBack...)
+
+	m := NewStateMachine[VipBundle, vipBundleEnv]("vip_bundle").
+		State(VipBundleBookingTickets, StateConfig[VipBundle, vipBundleEnv]{
+			Initial:      true,
+			Compensation: &vipBundleAction{Name: "refund tickets", Run: refundBookedTickets},
+		}).
+		State(VipBundleBookingInboundFlight, StateConfig[VipBundle, vipBundleEnv]{
+			Compensation: &vipBundleAction{Name: "cancel inbound flight", Run: cancelInboundFlight},
+		}).
+		State(VipBundleBookingReturnFlight, StateConfig[VipBundle, vipBundleEnv]{
+			Compensation: &vipBundleAction{Name: "cancel return flight", Run: cancelReturnFlight},
+		}).
+		State(VipBundleBookingHotel, StateConfig[VipBundle, vipBundleEnv]{
+			Compensation: &vipBundleAction{Name: "cancel hotel", Run: cancelHotel},
+		}).
+		State(VipBundleBookingTaxi, StateConfig[VipBundle, vipBundleEnv]{
+			Compensation: &vipBundleAction{Name: "cancel taxi", Run: cancelTaxi},
+		}).
+		State(VipBundleFinalized, StateConfig[VipBundle, vipBundleEnv]{Final: true}).
+		State(VipBundleFailed, StateConfig[VipBundle, vipBundleEnv]{Final: true}).
+		State(VipBundleCanceled, StateConfig[VipBundle, vipBundleEnv]{Final: true}).
+		State(VipBundleResolved, StateConfig[VipBundle, vipBundleEnv]{Final: true})
+
+	// happy path
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingTickets},
+		Trigger: TriggerOf(entities.VipBundleInitialized_v1{}),
+		Action:  &vipBundleAction{Name: "book tickets", Run: startStepAction(stepBooking)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingTickets},
+		Trigger: TriggerOf(entities.BookingMade_v1{}),
+		To:      VipBundleBookingInboundFlight,
+		Action:  &vipBundleAction{Name: "book inbound flight", Run: startStepAction(stepInboundFlight)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingInboundFlight},
+		Trigger: TriggerOf(entities.FlightBooked_v1{}),
+		To:      VipBundleBookingReturnFlight,
+		Guard:   &vipBundleGuard{Name: "inbound flight", Allows: isInboundFlight},
+		Action:  &vipBundleAction{Name: "book return flight", Run: startStepAction(stepReturnFlight)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingReturnFlight},
+		Trigger: TriggerOf(entities.FlightBooked_v1{}),
+		To:      VipBundleBookingHotel,
+		Guard:   &vipBundleGuard{Name: "return flight, hotel booked", Allows: isReturnFlightWithHotel},
+		Action:  &vipBundleAction{Name: "book hotel", Run: startStepAction(stepHotel)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingReturnFlight},
+		Trigger: TriggerOf(entities.FlightBooked_v1{}),
+		To:      VipBundleBookingTaxi,
+		Guard:   &vipBundleGuard{Name: "return flight, no hotel", Allows: isReturnFlightWithoutHotel},
+		Action:  &vipBundleAction{Name: "book taxi", Run: startStepAction(stepTaxi)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingHotel},
+		Trigger: TriggerOf(entities.HotelBooked_v1{}),
+		To:      VipBundleBookingTaxi,
+		Action:  &vipBundleAction{Name: "book taxi", Run: startStepAction(stepTaxi)},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    []State{VipBundleBookingTaxi},
+		Trigger: TriggerOf(entities.TaxiBooked_v1{}),
+		To:      VipBundleFinalized,
+		Action:  &vipBundleAction{Name: "publish VipBundleFinalized_v1", Run: publishVipBundleFinalized},
+	})
+
+	// failures
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       []State{VipBundleBookingTickets},
+		Trigger:    TriggerOf(entities.BookingFailed_v1{}),
+		To:         VipBundleFailed,
+		Compensate: true,
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       []State{VipBundleBookingInboundFlight, VipBundleBookingReturnFlight},
+		Trigger:    TriggerOf(entities.FlightBookingFailed_v1{}),
+		To:         VipBundleFailed,
+		Compensate: true,
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       []State{VipBundleBookingHotel},
+		Trigger:    TriggerOf(entities.HotelBookingFailed_v1{}),
+		To:         VipBundleFailed,
+		Compensate: true,
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       []State{VipBundleBookingTaxi},
+		Trigger:    TriggerOf(entities.TaxiBookingFailed_v1{}),
+		To:         VipBundleFailed,
+		Compensate: true,
+	})
+
+	// timeouts
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    inProgress,
+		Trigger: TriggerOf(entities.VipBundleStepTimedOut_v1{}),
+		Guard:   &vipBundleGuard{Name: "retries left", Allows: canRetryStep},
+		Action:  &vipBundleAction{Name: "retry step", Run: retryStep},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       inProgress,
+		Trigger:    TriggerOf(entities.VipBundleStepTimedOut_v1{}),
+		To:         VipBundleFailed,
+		Guard:      &vipBundleGuard{Name: "no retries left", Allows: isOutOfRetries},
+		Compensate: true,
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    all,
+		Trigger: TriggerOf(entities.VipBundleStepTimedOut_v1{}),
+		Guard:   &vipBundleGuard{Name: "step already completed", Allows: isStaleTimeout},
+	})
+
+	// cancellation
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       append(append([]State{}, inProgress...), VipBundleFinalized),
+		Trigger:    TriggerOf(entities.CancelVipBundle{}),
+		To:         VipBundleCanceled,
+		Compensate: true,
+		Action:     &vipBundleAction{Name: "publish VipBundleCanceled_v1", Run: publishVipBundleCanceled},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    append(append([]State{}, rolledBack...), VipBundleResolved),
+		Trigger: TriggerOf(entities.CancelVipBundle{}),
+	})
+
+	// operator actions on stuck bundles
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    inProgress,
+		Trigger: TriggerOf(ResendVipBundleStep{}),
+		Action:  &vipBundleAction{Name: "resend step", Run: resendStep},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:       inProgress,
+		Trigger:    TriggerOf(ForceVipBundleRollback{}),
+		To:         VipBundleFailed,
+		Compensate: true,
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    append(append([]State{}, inProgress...), rolledBack...),
+		Trigger: TriggerOf(ResolveVipBundle{}),
+		To:      VipBundleResolved,
+		Action:  &vipBundleAction{Name: "cancel step timeouts", Run: cancelStepTimeouts},
+	})
+
+	// steps completing after the bundle was rolled back are compensated when they complete,
+	// tickets are refunded when they are confirmed
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    rolledBack,
+		Trigger: TriggerOf(entities.BookingMade_v1{}),
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    rolledBack,
+		Trigger: TriggerOf(entities.FlightBooked_v1{}),
+		Action:  &vipBundleAction{Name: "cancel late flight", Run: cancelLateFlight},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    rolledBack,
+		Trigger: TriggerOf(entities.HotelBooked_v1{}),
+		Action:  &vipBundleAction{Name: "cancel late hotel", Run: cancelLateHotel},
+	})
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    rolledBack,
+		Trigger: TriggerOf(entities.TaxiBooked_v1{}),
+		Action:  &vipBundleAction{Name: "cancel late taxi", Run: cancelLateTaxi},
+	})
+	// the operator took over resolved bundles, so steps completing later are left to them
+	for _, completion := range []any{
+		entities.BookingMade_v1{},
+		entities.FlightBooked_v1{},
+		entities.HotelBooked_v1{},
+		entities.TaxiBooked_v1{},
+	} {
+		m.Transition(Transition[VipBundle, vipBundleEnv]{
+			From:    []State{VipBundleResolved},
+			Trigger: TriggerOf(completion),
+		})
+	}
+	// the process is at least once, events completing steps the bundle already moved past are duplicates,
+	// published again by a redelivered command
+	m.Transition(Transition[VipBundle, vipBundleEnv]{
+		From:    slices.DeleteFunc(slices.Clone(all), func(s State) bool { return s == VipBundleBookingTickets }),
+		Trigger: TriggerOf(entities.VipBundleInitialized_v1{}),
+	})
+	for _, completion := range []any{
+		entities.BookingMade_v1{},
+		entities.FlightBooked_v1{},
+		entities.HotelBooked_v1{},
+		entities.TaxiBooked_v1{},
+	} {
+		m.Transition(Transition[VipBundle, vipBundleEnv]{
+			From:    append(append([]State{}, inProgress...), VipBundleFinalized),
+			Trigger: TriggerOf(completion),
+			Guard:   &vipBundleGuard{Name: "duplicate", Allows: isDuplicateCompletion},
+		})
+	}
+	for _, failure := range []any{
+		entities.BookingFailed_v1{},
+		entities.FlightBookingFailed_v1{},
+		entities.HotelBookingFailed_v1{},
+		entities.TaxiBookingFailed_v1{},
+	} {
+		m.Transition(Transition[VipBundle, vipBundleEnv]{
+			From:    append(append([]State{}, rolledBack...), VipBundleResolved),
+			Trigger: TriggerOf(failure),
+		})
+	}
+
+	return m
+}
+
+func isInboundFlight(_ vipBundleEnv, vb VipBundle, event any) bool {
+	return event.(*entities.FlightBooked_v1).FlightID == vb.InboundFlightID
+}
+
+func isReturnFlight(_ vipBundleEnv, vb VipBundle, event any) bool {
+	return event.(*entities.FlightBooked_v1).FlightID == vb.ReturnFlightID
+}
+
+func isReturnFlightWithHotel(env vipBundleEnv, vb VipBundle, event any) bool {
+	return isReturnFlight(env, vb, event) && env.bookHotel
+}
+
+// isReturnFlightWithoutHotel skips the hotel step where there's no hotel partner to book it with.
+func isReturnFlightWithoutHotel(env vipBundleEnv, vb VipBundle, event any) bool {
+	return isReturnFlight(env, vb, event) && !env.bookHotel
+}
+
+// isDuplicateCompletion is true when the bundle already moved past the step the event completes.
+func isDuplicateCompletion(_ vipBundleEnv, vb VipBundle, event any) bool {
+	var next State
+	switch e := event.(type) {
+	case *entities.BookingMade_v1:
+		next = VipBundleBookingInboundFlight
+	case *entities.FlightBooked_v1:
+		if e.FlightID == vb.InboundFlightID {
+			next = VipBundleBookingReturnFlight
+			break
+		}
+		// the hotel step is skipped where hotels aren't booked
+		return slices.Contains(vb.Visited, VipBundleBookingHotel) || slices.Contains(vb.Visited, VipBundleBookingTaxi)
+	case *entities.HotelBooked_v1:
+		next = VipBundleBookingTaxi
+	case *entities.TaxiBooked_v1:
+		next = VipBundleFinalized
+	default:
+		return false
+	}
+
+	return slices.Contains(vb.Visited, next)
+}
+
+// isStepTimeout is true for the timeout of the last attempt of the current step.
+func isStepTimeout(_ vipBundleEnv, vb VipBundle, event any) bool {
+	timeout := event.(*entities.VipBundleStepTimedOut_v1)
+	return timeout.Step == vipBundleSteps[vb.State] && timeout.Attempt == vb.Commands[timeout.Step].Attempt
+}
+
+func isStaleTimeout(env vipBundleEnv, vb VipBundle, event any) bool {
+	return !isStepTimeout(env, vb, event)
+}
+
+func canRetryStep(env vipBundleEnv, vb VipBundle, event any) bool {
+	return isStepTimeout(env, vb, event) &&
+		event.(*entities.VipBundleStepTimedOut_v1).Attempt < env.timeoutsConfig.MaxRetries
+}
+
+func isOutOfRetries(env vipBundleEnv, vb VipBundle, event any) bool {
+	return isStepTimeout(env, vb, event) &&
+		event.(*entities.VipBundleStepTimedOut_v1).Attempt >= env.timeoutsConfig.MaxRetries
+}
+
+// idempotencyKey is derived from the bundle and the name of the command sent for it,
+// so every delivery, retry and resend of the command has the same key.
+func idempotencyKey(vipBundleID uuid.UUID, name string) string {
+	return uuid.NewSHA1(vipBundleID, []byte(name)).String()
+}
+
+func startStepAction(step string) func(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	return func(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+		if vb.commandSent(step) {
+			// the event starting the step was delivered again
+			return nil
+		}
+
+		return startStep(ctx, env, vb, step, 0)
+	}
+}
+
+func retryStep(ctx context.Context, env vipBundleEnv, vb *VipBundle, event any) error {
+	timedOut := event.(*entities.VipBundleStepTimedOut_v1)
+	return startStep(ctx, env, vb, timedOut.Step, timedOut.Attempt+1)
+}
+
+// resendStep sends the command of the current step again, for steps stuck without a timeout firing.
+func resendStep(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	step := vipBundleSteps[vb.State]
+	return startStep(ctx, env, vb, step, vb.Commands[step].Attempt)
+}
+
+// startStep sends the command of the step and schedules its timeout.
+// The command has the same idempotency key in every attempt, so a step that was only slow isn't done twice.
+func startStep(ctx context.Context, env vipBundleEnv, vb *VipBundle, step string, attempt int) error {
+	key := idempotencyKey(vb.VipBundleID, step)
+	if sent, ok := vb.Commands[step]; ok && sent.IdempotencyKey != "" {
+		// retries reuse the key the step was first sent with
+		key = sent.IdempotencyKey
+	}
+
+	var cmd any
+	switch step {
+	case stepBooking:
+		cmd = entities.BookShowTickets{
+			BookingID:       vb.BookingID,
+			CustomerEmail:   vb.CustomerEmail,
+			NumberOfTickets: vb.NumberOfTickets,
+			ShowId:          vb.ShowId,
+		}
+		// bookings are idempotent by their ID
+		key = vb.BookingID.String()
+	case stepInboundFlight, stepReturnFlight:
+		flightID := vb.InboundFlightID
+		if step == stepReturnFlight {
+			flightID = vb.ReturnFlightID
+		}
+		cmd = entities.BookFlight{
+			CustomerEmail:  vb.CustomerEmail,
+			FlightID:       flightID,
+			Passengers:     vb.Passengers,
+			ReferenceID:    vb.VipBundleID.String(),
+			IdempotencyKey: key,
+		}
+	case stepHotel:
+		cmd = entities.BookHotel{
+			CustomerEmail:  vb.CustomerEmail,
+			GuestNames:     vb.Passengers,
+			ShowID:         vb.ShowId,
+			ReferenceID:    vb.VipBundleID.String(),
+			IdempotencyKey: key,
+		}
+	case stepTaxi:
+		cmd = entities.BookTaxi{
+			CustomerEmail:      vb.CustomerEmail,
+			CustomerName:       vb.Passengers[0],
+			NumberOfPassengers: vb.NumberOfTickets,
+			ReferenceID:        vb.VipBundleID.String(),
+			IdempotencyKey:     key,
+		}
+	default:
+		return fmt.Errorf("unknown vip bundle step %q", step)
+	}
+
+	if err := sendCommand(ctx, env.commandBus, vb, step, key, attempt, cmd); err != nil {
+		return err
+	}
+
+	return env.scheduler.SendAt(
+		ctx,
+		entities.TimeOutVipBundleStep{VipBundleID: vb.VipBundleID, Step: step, Attempt: attempt},
+		time.Now().Add(env.timeoutsConfig.Timeout),
+		scheduler.WithKey(stepTimeoutKey(vb.VipBundleID, step)),
+	)
+}
+
+// sendCommand sends the command and records it on the bundle under the name.
+func sendCommand(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, name string, key string, attempt int, cmd any) error {
+	if err := commandBus.Send(ctx, cmd); err != nil {
+		return err
+	}
+
+	if vb.Commands == nil {
+		vb.Commands = map[string]SentCommand{}
+	}
+	vb.Commands[name] = SentCommand{
+		Command:        TriggerOf(cmd),
+		IdempotencyKey: key,
+		Attempt:        attempt,
+		SentAt:         time.Now(),
+	}
+
+	return nil
+}
+
+// compensate sends the compensation command once, it's delivered by the outbox so it's never sent again.
+func compensate(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, name string, cmd func(key string) any) error {
+	if vb.commandSent(name) {
+		return nil
+	}
+
+	key := idempotencyKey(vb.VipBundleID, name)
+	return sendCommand(ctx, commandBus, vb, name, key, 0, cmd(key))
+}
+
+func cancelStepTimeouts(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	for _, step := range vipBundleSteps {
+		if err := env.scheduler.Cancel(ctx, stepTimeoutKey(vb.VipBundleID, step)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func publishVipBundleFinalized(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	return env.eventBus.Publish(ctx, entities.VipBundleFinalized_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	})
+}
+
+func publishVipBundleCanceled(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	return env.eventBus.Publish(ctx, entities.VipBundleCanceled_v1{
+		Header:      entities.NewEventHeader(),
+		VipBundleID: vb.VipBundleID,
+	})
+}
+
+// refundBookedTickets refunds the tickets confirmed so far. Tickets confirmed later are refunded
+// by OnTicketBookingConfirmed, as the bundle is rolled back by then.
+func refundBookedTickets(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	return refundTickets(ctx, env.commandBus, vb, vb.TicketIDs)
+}
+
+func refundTickets(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, ticketIDs []uuid.UUID) error {
+	for _, ticketID := range ticketIDs {
+		err := compensate(ctx, commandBus, vb, "refund_ticket/"+ticketID.String(), func(key string) any {
+			return entities.RefundTicket{
+				Header:   entities.NewEventHeaderWithIdempotencyKey(key),
+				TicketID: ticketID.String(),
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cancelInboundFlight(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	if vb.InboundFlightBookedAt == nil {
+		return nil
+	}
+
+	return cancelFlightTickets(ctx, env.commandBus, vb, vb.InboundFlightID, vb.InboundFlightTicketsIDs)
+}
+
+func cancelReturnFlight(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	if vb.ReturnFlightBookedAt == nil {
+		return nil
+	}
+
+	return cancelFlightTickets(ctx, env.commandBus, vb, vb.ReturnFlightID, vb.ReturnFlightTicketsIDs)
+}
+
+func cancelFlightTickets(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, flightID uuid.UUID, ticketIDs []uuid.UUID) error {
+	return compensate(ctx, commandBus, vb, "cancel_flight/"+flightID.String(), func(key string) any {
+		return entities.CancelFlightTickets{
+			FlightTicketIDs: ticketIDs,
+			IdempotencyKey:  key,
+		}
+	})
+}
+
+func cancelHotel(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	if vb.HotelBookingID == nil {
+		return nil
+	}
+
+	return cancelHotelBooking(ctx, env.commandBus, vb, *vb.HotelBookingID)
+}
+
+func cancelHotelBooking(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, hotelBookingID uuid.UUID) error {
+	return compensate(ctx, commandBus, vb, "cancel_hotel/"+hotelBookingID.String(), func(string) any {
+		// the booking is canceled by its ID, so it's idempotent without a key
+		return entities.CancelHotelBooking{HotelBookingID: hotelBookingID}
+	})
+}
+
+func cancelTaxi(ctx context.Context, env vipBundleEnv, vb *VipBundle, _ any) error {
+	if vb.TaxiBookingID == nil {
+		return nil
+	}
+
+	return cancelTaxiBooking(ctx, env.commandBus, vb, *vb.TaxiBookingID)
+}
+
+func cancelTaxiBooking(ctx context.Context, commandBus *cqrs.CommandBus, vb *VipBundle, taxiBookingID uuid.UUID) error {
+	return compensate(ctx, commandBus, vb, "cancel_taxi/"+taxiBookingID.String(), func(string) any {
+		// the booking is canceled by its ID, so it's idempotent without a key
+		return entities.CancelTaxiBooking{TaxiBookingID: taxiBookingID}
+	})
+}
+
+func cancelLateFlight(ctx context.Context, env vipBundleEnv, vb *VipBundle, event any) error {
+	booked := event.(*entities.FlightBooked_v1)
+	return cancelFlightTickets(ctx, env.commandBus, vb, booked.FlightID, booked.TicketIDs)
+}
+
+func cancelLateHotel(ctx context.Context, env vipBundleEnv, vb *VipBundle, event any) error {
+	return cancelHotelBooking(ctx, env.commandBus, vb, event.(*entities.HotelBooked_v1).HotelBookingID)
+}
+
+func cancelLateTaxi(ctx context.Context, env vipBundleEnv, vb *VipBundle, event any) error {
+	return cancelTaxiBooking(ctx, env.commandBus, vb, event.(*entities.TaxiBooked_v1).TaxiBookingID)
+}
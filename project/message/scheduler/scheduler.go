@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ScheduledCommand is a command marshaled by the command bus, waiting to be sent at DueAt.
+type ScheduledCommand struct {
+	// Key identifies the command to cancel or replace it, it's optional.
+	Key          string
+	Name         string
+	Topic        string
+	Message      *message.Message
+	PartitionKey string
+	DueAt        time.Time
+}
+
+// Store persists scheduled commands, in the transaction of the caller when there is one.
+type Store interface {
+	// Schedule stores the command, replacing the command scheduled with the same key.
+	Schedule(ctx context.Context, cmd ScheduledCommand) error
+	Cancel(ctx context.Context, key string) error
+}
+
+// Scheduler sends commands at a later time: they are stored with the work of the caller,
+// and sent through the outbox by Worker when they are due.
+type Scheduler struct {
+	store         Store
+	newCommandBus func(message.Publisher) *cqrs.CommandBus
+}
+
+func NewScheduler(store Store, newCommandBus func(message.Publisher) *cqrs.CommandBus) Scheduler {
+	if store == nil {
+		panic("store is nil")
+	}
+	if newCommandBus == nil {
+		panic("newCommandBus is nil")
+	}
+
+	return Scheduler{store: store, newCommandBus: newCommandBus}
+}
+
+type Option func(*ScheduledCommand)
+
+// WithKey sets the key to cancel the command by. Scheduling a command with the key of
+// a command that wasn't sent yet replaces it. The key is also the outbox partition key of the command.
+func WithKey(key string) Option {
+	return func(cmd *ScheduledCommand) {
+		cmd.Key = key
+		cmd.PartitionKey = key
+	}
+}
+
+// SendAt sends the command at the time, like CommandBus.Send. Commands are sent at least once,
+// as soon as possible when the time is in the past.
+func (s Scheduler) SendAt(ctx context.Context, cmd any, at time.Time, options ...Option) error {
+	captured := &capturingPublisher{}
+	// correlation ID of the caller is kept for when the command is sent
+	err := s.newCommandBus(log.CorrelationPublisherDecorator{Publisher: captured}).Send(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("could not marshal command: %w", err)
+	}
+
+	scheduled := ScheduledCommand{
+		Name:         cqrs.StructName(cmd),
+		Topic:        captured.topic,
+		Message:      captured.msg,
+		PartitionKey: captured.msg.UUID,
+		DueAt:        at,
+	}
+	for _, option := range options {
+		option(&scheduled)
+	}
+
+	if err := s.store.Schedule(ctx, scheduled); err != nil {
+		return fmt.Errorf("could not schedule %s: %w", scheduled.Name, err)
+	}
+
+	return nil
+}
+
+// Cancel cancels the command scheduled with the key, if it wasn't sent yet.
+func (s Scheduler) Cancel(ctx context.Context, key string) error {
+	if err := s.store.Cancel(ctx, key); err != nil {
+		return fmt.Errorf("could not cancel scheduled command %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// capturingPublisher keeps the message instead of publishing it.
+type capturingPublisher struct {
+	topic string
+	msg   *message.Message
+}
+
+func (p *capturingPublisher) Publish(topic string, messages ...*message.Message) error {
+	if len(messages) != 1 {
+		return fmt.Errorf("expected one message, got %d", len(messages))
+	}
+
+	p.topic = topic
+	p.msg = messages[0]
+
+	return nil
+}
+
+func (p *capturingPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package scheduler_test
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"tickets/entities"
+	"tickets/message/command"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_SendAt(t *testing.T) {
+	store := &store{}
+	s := scheduler.NewScheduler(store, command.NewCommandBus)
+	ctx := context.Background()
+	at := time.Now().Add(time.Hour)
+
+	cmd := entities.BookTaxi{
+		CustomerEmail:      "email@example.com",
+		CustomerName:       "Passenger 0",
+		NumberOfPassengers: 1,
+		ReferenceID:        "reference",
+		IdempotencyKey:     "key",
+	}
+	require.NoError(t, s.SendAt(ctx, cmd, at, scheduler.WithKey("reminder")))
+	require.NoError(t, s.SendAt(ctx, cmd, at))
+
+	require.Len(t, store.scheduled, 2)
+
+	withKey := store.scheduled[0]
+	assert.Equal(t, "reminder", withKey.Key)
+	assert.Equal(t, "reminder", withKey.PartitionKey)
+	assert.Equal(t, "BookTaxi", withKey.Name)
+	assert.Equal(t, "commands.BookTaxi", withKey.Topic)
+	assert.Equal(t, at, withKey.DueAt)
+
+	var sent entities.BookTaxi
+	require.NoError(t, json.Unmarshal(withKey.Message.Payload, &sent))
+	assert.Equal(t, cmd, sent)
+
+	withoutKey := store.scheduled[1]
+	assert.Empty(t, withoutKey.Key)
+	assert.Equal(t, withoutKey.Message.UUID, withoutKey.PartitionKey)
+
+	require.NoError(t, s.Cancel(ctx, "reminder"))
+	assert.Equal(t, []string{"reminder"}, store.canceled)
+}
+
+func TestWorker_sendsAllDueCommands(t *testing.T) {
+	due := &dueCommands{remaining: 150}
+	worker := scheduler.NewWorker(due, scheduler.Config{PollInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// sends the due commands once before it stops
+	require.NoError(t, worker.Run(ctx))
+
+	assert.Equal(t, 0, due.remaining)
+	assert.Equal(t, 2, due.batches)
+}
+
+type store struct {
+	scheduled []scheduler.ScheduledCommand
+	canceled  []string
+}
+
+func (s *store) Schedule(_ context.Context, cmd scheduler.ScheduledCommand) error {
+	s.scheduled = append(s.scheduled, cmd)
+	return nil
+}
+
+func (s *store) Cancel(_ context.Context, key string) error {
+	s.canceled = append(s.canceled, key)
+	return nil
+}
+
+type dueCommands struct {
+	lock      sync.Mutex
+	remaining int
+	batches   int
+}
+
+func (d *dueCommands) SendDue(_ context.Context, now time.Time, limit int) ([]scheduler.SentCommand, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	n := min(limit, d.remaining)
+	d.remaining -= n
+	d.batches++
+
+	sent := make([]scheduler.SentCommand, n)
+	for i := range sent {
+		sent[i] = scheduler.SentCommand{Name: "BookTaxi", DueAt: now.Add(-time.Second)}
+	}
+
+	return sent, nil
+}
+
+func (d *dueCommands) OldestDue(context.Context, time.Time) (*time.Time, error) {
+	return nil, nil
+}
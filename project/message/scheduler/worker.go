@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sendLatenessHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "scheduler",
+			Name:      "send_lateness_seconds",
+			Help:      "How long after their due time scheduled commands were stored in the outbox",
+			Buckets:   []float64{0.5, 1, 2, 5, 10, 30, 60, 300, 900},
+		},
+		[]string{"command_name"},
+	)
+
+	oldestDueCommandLatenessGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "scheduler",
+			Name:      "oldest_due_command_lateness_seconds",
+			Help:      "How long the oldest due command, that wasn't sent yet, is past its due time",
+		},
+	)
+)
+
+// SentCommand is a scheduled command that was sent.
+type SentCommand struct {
+	Name  string
+	DueAt time.Time
+}
+
+type DueCommands interface {
+	// SendDue sends at most limit commands due at now through the outbox, and removes them.
+	SendDue(ctx context.Context, now time.Time, limit int) ([]SentCommand, error)
+	// OldestDue returns the due time of the oldest command due at now, or nil if there is none.
+	OldestDue(ctx context.Context, now time.Time) (*time.Time, error)
+}
+
+type Config struct {
+	// PollInterval is how often the worker sends due commands.
+	PollInterval time.Duration
+}
+
+const batchSize = 100
+
+// Worker sends scheduled commands when they are due.
+type Worker struct {
+	commands DueCommands
+	interval time.Duration
+}
+
+func NewWorker(commands DueCommands, config Config) Worker {
+	if commands == nil {
+		panic("commands is nil")
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+
+	return Worker{
+		commands: commands,
+		interval: config.PollInterval,
+	}
+}
+
+func (w Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w Worker) runOnce(ctx context.Context) {
+	logger := log.FromContext(ctx)
+
+	oldest, err := w.commands.OldestDue(ctx, time.Now())
+	if err != nil {
+		logger.WithError(err).Error("Could not get the oldest due scheduled command")
+	} else if oldest == nil {
+		oldestDueCommandLatenessGauge.Set(0)
+	} else {
+		oldestDueCommandLatenessGauge.Set(time.Since(*oldest).Seconds())
+	}
+
+	for {
+		now := time.Now()
+		sent, err := w.commands.SendDue(ctx, now, batchSize)
+		if err != nil {
+			logger.WithError(err).Error("Could not send scheduled commands")
+			return
+		}
+
+		for _, cmd := range sent {
+			sendLatenessHistogram.WithLabelValues(cmd.Name).Observe(now.Sub(cmd.DueAt).Seconds())
+		}
+		if len(sent) > 0 {
+			logger.WithField("sent", len(sent)).Info("Scheduled commands sent")
+		}
+		if len(sent) < batchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/redis/go-redis/v9"
+)
+
+type TransportKind string
+
+const (
+	TransportRedis     TransportKind = "redis"
+	TransportKafka     TransportKind = "kafka"
+	TransportGoChannel TransportKind = "gochannel"
+)
+
+type TransportConfig struct {
+	Kind TransportKind
+
+	// RedisClient is required for TransportRedis.
+	RedisClient *redis.Client
+	// KafkaBrokers are required for TransportKafka.
+	KafkaBrokers []string
+}
+
+// Transport creates publishers and subscribers for the configured message broker.
+//
+// Topics and consumer groups are generated by the callers, so they are the same for every transport.
+// GoChannel doesn't support consumer groups: each subscriber receives all messages,
+// which is equivalent to a consumer group per handler when running a single instance.
+type Transport struct {
+	config    TransportConfig
+	logger    watermill.LoggerAdapter
+	goChannel *gochannel.GoChannel
+}
+
+func NewTransport(config TransportConfig, logger watermill.LoggerAdapter) (Transport, error) {
+	t := Transport{
+		config: config,
+		logger: logger,
+	}
+
+	switch config.Kind {
+	case TransportRedis:
+		if config.RedisClient == nil {
+			return Transport{}, fmt.Errorf("redis client is required for %s transport", config.Kind)
+		}
+	case TransportKafka:
+		if len(config.KafkaBrokers) == 0 {
+			return Transport{}, fmt.Errorf("kafka brokers are required for %s transport", config.Kind)
+		}
+	case TransportGoChannel:
+		// publisher and subscribers must share the same instance
+		t.goChannel = gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 1024}, logger)
+	default:
+		return Transport{}, fmt.Errorf("unknown transport: %q", config.Kind)
+	}
+
+	return t, nil
+}
+
+func (t Transport) Kind() TransportKind {
+	return t.config.Kind
+}
+
+func (t Transport) NewPublisher() (message.Publisher, error) {
+	switch t.config.Kind {
+	case TransportKafka:
+		return NewKafkaPublisher(t.config.KafkaBrokers, t.logger)
+	case TransportGoChannel:
+		return t.goChannel, nil
+	default:
+		return NewRedisPublisher(t.config.RedisClient, t.logger)
+	}
+}
+
+func (t Transport) NewSubscriber(consumerGroup string) (message.Subscriber, error) {
+	switch t.config.Kind {
+	case TransportKafka:
+		return NewKafkaSubscriber(t.config.KafkaBrokers, consumerGroup, t.logger)
+	case TransportGoChannel:
+		return t.goChannel, nil
+	default:
+		return NewRedisSubscriber(t.config.RedisClient, consumerGroup, t.logger)
+	}
+}
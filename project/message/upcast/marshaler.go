@@ -0,0 +1,29 @@
+package upcast
+
+import (
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// nameMetadataKey is the metadata key used by cqrs.JSONMarshaler to store the message name.
+const nameMetadataKey = "name"
+
+// Marshaler upcasts older message versions before they are unmarshaled,
+// so handlers only ever see the newest version.
+type Marshaler struct {
+	cqrs.CommandEventMarshaler
+	Registry Registry
+}
+
+func (m Marshaler) NameFromMessage(msg *message.Message) string {
+	return m.Registry.CurrentName(m.CommandEventMarshaler.NameFromMessage(msg))
+}
+
+func (m Marshaler) Unmarshal(msg *message.Message, v interface{}) error {
+	upcasted, err := m.Registry.UpcastMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	return m.CommandEventMarshaler.Unmarshal(upcasted, v)
+}
@@ -0,0 +1,117 @@
+package upcast
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// UpcastFunc transforms the payload of a message to the next version.
+type UpcastFunc func(payload []byte) ([]byte, error)
+
+type upcaster struct {
+	to     string
+	upcast UpcastFunc
+}
+
+// Registry holds upcasters by the name of the message version they upcast from (for example `BookingMade_v0`).
+// Upcasters are chained, so BookingMade_v0 can be upcasted to BookingMade_v1 and then to BookingMade_v2.
+type Registry struct {
+	upcasters map[string]upcaster
+}
+
+func NewRegistry() Registry {
+	return Registry{upcasters: map[string]upcaster{}}
+}
+
+// Register adds the upcaster from one message version to the next one.
+func (r Registry) Register(from, to string, fn UpcastFunc) {
+	if _, ok := r.upcasters[from]; ok {
+		panic(fmt.Sprintf("upcaster from %s is already registered", from))
+	}
+
+	r.upcasters[from] = upcaster{to: to, upcast: fn}
+}
+
+// CurrentName returns the name of the newest version the message can be upcasted to.
+func (r Registry) CurrentName(name string) string {
+	for i := 0; i <= len(r.upcasters); i++ {
+		u, ok := r.upcasters[name]
+		if !ok {
+			return name
+		}
+		name = u.to
+	}
+
+	panic(fmt.Sprintf("upcasters of %s form a cycle", name))
+}
+
+// Versions returns the name with the names of all versions upcasted to it.
+func (r Registry) Versions(name string) []string {
+	versions := []string{name}
+	for from := range r.upcasters {
+		if from != name && r.CurrentName(from) == name {
+			versions = append(versions, from)
+		}
+	}
+
+	return versions
+}
+
+// Upcast transforms the payload to the newest version, returning its name.
+// Messages without registered upcasters are returned unchanged.
+func (r Registry) Upcast(name string, payload []byte) (string, []byte, error) {
+	for i := 0; i <= len(r.upcasters); i++ {
+		u, ok := r.upcasters[name]
+		if !ok {
+			return name, payload, nil
+		}
+
+		var err error
+		payload, err = u.upcast(payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not upcast %s to %s: %w", name, u.to, err)
+		}
+		name = u.to
+	}
+
+	return "", nil, fmt.Errorf("upcasters of %s form a cycle", name)
+}
+
+// UpcastMessage returns a copy of the message upcasted to the newest version, with the name metadata updated.
+func (r Registry) UpcastMessage(msg *message.Message) (*message.Message, error) {
+	name := msg.Metadata.Get(nameMetadataKey)
+	if _, ok := r.upcasters[name]; !ok {
+		return msg, nil
+	}
+
+	newName, payload, err := r.Upcast(name, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	upcasted := msg.Copy()
+	upcasted.SetContext(msg.Context())
+	upcasted.Payload = payload
+	upcasted.Metadata.Set(nameMetadataKey, newName)
+
+	return upcasted, nil
+}
+
+// JSON builds an UpcastFunc from a function mapping the old JSON struct to the new one.
+func JSON[From, To any](fn func(From) (To, error)) UpcastFunc {
+	return func(payload []byte) ([]byte, error) {
+		var from From
+		if err := json.Unmarshal(payload, &from); err != nil {
+			return nil, err
+		}
+
+		to, err := fn(from)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(to)
+	}
+}
@@ -0,0 +1,66 @@
+package upcast_test
+
+import (
+	"encoding/json"
+	"testing"
+	"tickets/entities"
+	"tickets/message/upcast"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type ticketPrinted_v2 struct {
+	Header entities.EventHeader `json:"header"`
+
+	TicketID string `json:"ticket_id"`
+	FileName string `json:"file_name"`
+	Format   string `json:"format"`
+}
+
+func TestRegistry_Upcast_chain(t *testing.T) {
+	registry := upcast.NewRegistry()
+	registry.Register("TicketPrinted_v1", "TicketPrinted_v2", upcast.JSON(func(e entities.TicketPrinted_v1) (ticketPrinted_v2, error) {
+		return ticketPrinted_v2{
+			Header:   e.Header,
+			TicketID: e.TicketID,
+			FileName: e.FileName,
+			Format:   "pdf",
+		}, nil
+	}))
+	registry.Register("TicketPrinted_v0", "TicketPrinted_v1", upcast.JSON(func(e entities.TicketPrinted_v1) (entities.TicketPrinted_v1, error) {
+		return e, nil
+	}))
+
+	assert.Equal(t, "TicketPrinted_v2", registry.CurrentName("TicketPrinted_v0"))
+	assert.Equal(t, "BookingMade_v1", registry.CurrentName("BookingMade_v1"))
+
+	name, payload, err := registry.Upcast("TicketPrinted_v0", []byte(`{"ticket_id": "ticket-1", "file_name": "ticket-1.html"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "TicketPrinted_v2", name)
+
+	var upcasted ticketPrinted_v2
+	require.NoError(t, json.Unmarshal(payload, &upcasted))
+	assert.Equal(t, ticketPrinted_v2{TicketID: "ticket-1", FileName: "ticket-1.html", Format: "pdf"}, upcasted)
+}
+
+func TestMarshaler_Unmarshal(t *testing.T) {
+	marshaler := upcast.Marshaler{
+		CommandEventMarshaler: cqrs.JSONMarshaler{GenerateName: cqrs.StructName},
+		Registry:              upcast.Default,
+	}
+
+	bookingID := uuid.New()
+	msg := message.NewMessage(uuid.NewString(), []byte(`{"booking_id": "`+bookingID.String()+`", "number_of_tickets": 2}`))
+	msg.Metadata.Set("name", "BookingMade_v0")
+
+	assert.Equal(t, "BookingMade_v1", marshaler.NameFromMessage(msg))
+
+	var event entities.BookingMade_v1
+	require.NoError(t, marshaler.Unmarshal(msg, &event))
+	assert.Equal(t, bookingID, event.BookingID)
+	assert.Equal(t, 2, event.NumberOfTickets)
+}
@@ -0,0 +1,103 @@
+package upcast
+
+import (
+	"tickets/entities"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Default is the registry used by the event and command marshalers and by data lake replays.
+var Default = newDefaultRegistry()
+
+func newDefaultRegistry() Registry {
+	r := NewRegistry()
+
+	r.Register("BookingMade_v0", "BookingMade_v1", JSON(func(e bookingMade_v0) (entities.BookingMade_v1, error) {
+		return entities.BookingMade_v1{
+			Header:          e.Header,
+			NumberOfTickets: e.NumberOfTickets,
+			BookingID:       e.BookingID,
+			CustomerEmail:   e.CustomerEmail,
+			ShowId:          e.ShowId,
+		}, nil
+	}))
+	r.Register("TicketBookingConfirmed_v0", "TicketBookingConfirmed_v1", JSON(func(e ticketBookingConfirmed_v0) (entities.TicketBookingConfirmed_v1, error) {
+		return entities.TicketBookingConfirmed_v1{
+			Header:        e.Header,
+			TicketID:      e.TicketID,
+			CustomerEmail: e.CustomerEmail,
+			Price:         e.Price,
+			BookingID:     e.BookingID,
+		}, nil
+	}))
+	r.Register("TicketReceiptIssued_v0", "TicketReceiptIssued_v1", JSON(func(e ticketReceiptIssued_v0) (entities.TicketReceiptIssued_v1, error) {
+		return entities.TicketReceiptIssued_v1{
+			Header:        e.Header,
+			TicketID:      e.TicketID,
+			ReceiptNumber: e.ReceiptNumber,
+			IssuedAt:      e.IssuedAt,
+		}, nil
+	}))
+	r.Register("TicketPrinted_v0", "TicketPrinted_v1", JSON(func(e ticketPrinted_v0) (entities.TicketPrinted_v1, error) {
+		return entities.TicketPrinted_v1{
+			Header:   e.Header,
+			TicketID: e.TicketID,
+			FileName: e.FileName,
+		}, nil
+	}))
+	r.Register("TicketRefunded_v0", "TicketRefunded_v1", JSON(func(e ticketRefunded_v0) (entities.TicketRefunded_v1, error) {
+		return entities.TicketRefunded_v1{
+			Header:   e.Header,
+			TicketID: e.TicketID,
+		}, nil
+	}))
+
+	return r
+}
+
+// v0 events are only stored in the data lake; they have the same fields as v1,
+// but they are mapped explicitly to show how upcasting works when the format doesn't match.
+
+type bookingMade_v0 struct {
+	Header entities.EventHeader `json:"header"`
+
+	NumberOfTickets int `json:"number_of_tickets"`
+
+	BookingID uuid.UUID `json:"booking_id"`
+
+	CustomerEmail string    `json:"customer_email"`
+	ShowId        uuid.UUID `json:"show_id"`
+}
+
+type ticketBookingConfirmed_v0 struct {
+	Header entities.EventHeader `json:"header"`
+
+	TicketID      string         `json:"ticket_id"`
+	CustomerEmail string         `json:"customer_email"`
+	Price         entities.Money `json:"price"`
+
+	BookingID string `json:"booking_id"`
+}
+
+type ticketReceiptIssued_v0 struct {
+	Header entities.EventHeader `json:"header"`
+
+	TicketID      string `json:"ticket_id"`
+	ReceiptNumber string `json:"receipt_number"`
+
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+type ticketPrinted_v0 struct {
+	Header entities.EventHeader `json:"header"`
+
+	TicketID string `json:"ticket_id"`
+	FileName string `json:"file_name"`
+}
+
+type ticketRefunded_v0 struct {
+	Header entities.EventHeader `json:"header"`
+
+	TicketID string `json:"ticket_id"`
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tickets/db"
+	"tickets/message/outbox"
+
+	"github.com/urfave/cli/v2"
+)
+
+var outboxCommand = &cli.Command{
+	Name:  "outbox",
+	Usage: "Inspect the outbox",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "stats",
+			Usage: "show the number of pending messages and the forwarder lag",
+			Action: func(c *cli.Context) error {
+				inspector, closeDB, err := newOutboxInspector()
+				if err != nil {
+					return err
+				}
+				defer closeDB()
+
+				stats, err := inspector.Stats(c.Context)
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("pending messages:\t%d\n", stats.PendingMessages)
+				fmt.Printf("lag:\t%.1fs\n", stats.LagSeconds)
+
+				return nil
+			},
+		},
+		{
+			Name:  "pending",
+			Usage: "list messages waiting to be forwarded",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "limit",
+					Value: 100,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				inspector, closeDB, err := newOutboxInspector()
+				if err != nil {
+					return err
+				}
+				defer closeDB()
+
+				messages, err := inspector.Pending(c.Context, c.Int("limit"))
+				if err != nil {
+					return err
+				}
+
+				for _, m := range messages {
+					fmt.Printf("%v\t%v\t%v\t%v\t%s\n", m.Topic, m.Offset, m.UUID, m.CreatedAt, m.Metadata)
+				}
+
+				return nil
+			},
+		},
+	},
+}
+
+func newOutboxInspector() (outbox.Inspector, func() error, error) {
+	partitions, err := intFromEnv("OUTBOX_PARTITIONS", 1)
+	if err != nil {
+		return outbox.Inspector{}, nil, err
+	}
+
+	database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+	if err != nil {
+		return outbox.Inspector{}, nil, err
+	}
+
+	return outbox.NewInspector(database.Conn, outbox.Partitions(partitions)), database.Close, nil
+}
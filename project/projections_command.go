@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tickets/db"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/message/outbox"
+
+	"github.com/urfave/cli/v2"
+)
+
+var projectionsCommand = &cli.Command{
+	Name:  "projections",
+	Usage: "Manage read model projections",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "status",
+			Usage: "show the progress of the last rebuild of each projection",
+			Action: func(c *cli.Context) error {
+				rebuilder, closeDB, err := newProjectionRebuilder()
+				if err != nil {
+					return err
+				}
+				defer closeDB()
+
+				for _, name := range rebuilder.Projections() {
+					status, err := rebuilder.Status(c.Context, name)
+					if err != nil {
+						return err
+					}
+					printRebuildStatus(status)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:      "rebuild",
+			Usage:     "rebuild the projection from the data lake, resuming an interrupted rebuild",
+			ArgsUsage: "<projection>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("expected projection name")
+				}
+
+				rebuilder, closeDB, err := newProjectionRebuilder()
+				if err != nil {
+					return err
+				}
+				defer closeDB()
+
+				return rebuilder.Rebuild(c.Context, c.Args().First(), printRebuildStatus)
+			},
+		},
+	},
+}
+
+func printRebuildStatus(status entities.ProjectionRebuildStatus) {
+	progress := ""
+	if status.EventsTotal > 0 {
+		progress = fmt.Sprintf(" (%.0f%%)", 100*float64(status.EventsProcessed)/float64(status.EventsTotal))
+	}
+
+	fmt.Printf(
+		"%s\t%s\t%d/%d events%s\t%d skipped\t%v\n",
+		status.Projection,
+		status.Status,
+		status.EventsProcessed,
+		status.EventsTotal,
+		progress,
+		status.EventsSkipped,
+		status.UnknownEvents,
+	)
+	if status.LastError != "" {
+		fmt.Printf("%s\tlast error: %s\n", status.Projection, status.LastError)
+	}
+}
+
+func newProjectionRebuilder() (db.ProjectionRebuilder, func() error, error) {
+	partitions, err := intFromEnv("OUTBOX_PARTITIONS", 1)
+	if err != nil {
+		return db.ProjectionRebuilder{}, nil, err
+	}
+
+	database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+	if err != nil {
+		return db.ProjectionRebuilder{}, nil, err
+	}
+	database.MigrateSchema()
+
+	opsReadModel := db.NewOpsBookingReadModel(&database, outbox.Partitions(partitions), event.NewBus)
+	vipBundleReadModel := db.NewVipBundleReadModel(&database, outbox.Partitions(partitions), event.NewBus)
+
+	return db.NewProjectionRebuilder(
+		newDataLake(&database),
+		opsReadModel.Projection(),
+		vipBundleReadModel.Projection(),
+	), database.Close, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"tickets/db"
+	"tickets/message/command"
+	"tickets/message/event"
+	"tickets/message/outbox"
+	"tickets/message/sagas"
+	"tickets/message/scheduler"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+// operatorFlags identify the operator and the bundle of an operator action, which is recorded as an audit event.
+var operatorFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "id",
+		Usage:    "VIP bundle ID",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:    "operator",
+		Usage:   "who takes the action",
+		EnvVars: []string{"USER"},
+	},
+	&cli.StringFlag{
+		Name:  "reason",
+		Usage: "why the action is taken",
+	},
+}
+
+var sagasCommand = &cli.Command{
+	Name:  "sagas",
+	Usage: "Inspect process managers and fix stuck VIP bundles",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "graph",
+			Usage: "print the state machine of the VIP bundle process manager",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "mermaid or graphviz",
+					Value: "mermaid",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				switch c.String("format") {
+				case "mermaid":
+					fmt.Print(sagas.VipBundleStateMachine.Mermaid())
+				case "graphviz":
+					fmt.Print(sagas.VipBundleStateMachine.Graphviz())
+				default:
+					return fmt.Errorf("unknown format %q, expected mermaid or graphviz", c.String("format"))
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "stuck",
+			Usage: "list VIP bundles not in a final state some time after they were created",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "older-than",
+					Value: 30 * time.Minute,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				pm, closeDB, err := newVipBundleProcessManager()
+				if err != nil {
+					return err
+				}
+				defer closeDB()
+
+				vipBundles, err := pm.Stuck(c.Context, c.Duration("older-than"))
+				if err != nil {
+					return err
+				}
+
+				for _, vb := range vipBundles {
+					visited, err := json.Marshal(vb.Visited)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%v\t%v\t%v\t%v\t%s\n", vb.VipBundleID, vb.CreatedAt, vb.CustomerEmail, vb.State, visited)
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "resend-step",
+			Usage: "send the command of the step the VIP bundle waits for again",
+			Flags: operatorFlags,
+			Action: func(c *cli.Context) error {
+				return vipBundleOperatorAction(c, func(pm *sagas.VipBundleProcessManager, id uuid.UUID) error {
+					return pm.ResendStep(c.Context, sagas.ResendVipBundleStep{
+						VipBundleID: id,
+						Operator:    c.String("operator"),
+						Reason:      c.String("reason"),
+					})
+				})
+			},
+		},
+		{
+			Name:  "force-rollback",
+			Usage: "fail the VIP bundle and compensate its steps",
+			Flags: operatorFlags,
+			Action: func(c *cli.Context) error {
+				return vipBundleOperatorAction(c, func(pm *sagas.VipBundleProcessManager, id uuid.UUID) error {
+					return pm.ForceRollback(c.Context, sagas.ForceVipBundleRollback{
+						VipBundleID: id,
+						Operator:    c.String("operator"),
+						Reason:      c.String("reason"),
+					})
+				})
+			},
+		},
+		{
+			Name:  "resolve",
+			Usage: "mark the VIP bundle as resolved by hand, without compensating its steps",
+			Flags: operatorFlags,
+			Action: func(c *cli.Context) error {
+				return vipBundleOperatorAction(c, func(pm *sagas.VipBundleProcessManager, id uuid.UUID) error {
+					return pm.Resolve(c.Context, sagas.ResolveVipBundle{
+						VipBundleID: id,
+						Operator:    c.String("operator"),
+						Reason:      c.String("reason"),
+					})
+				})
+			},
+		},
+	},
+}
+
+func vipBundleOperatorAction(c *cli.Context, action func(pm *sagas.VipBundleProcessManager, id uuid.UUID) error) error {
+	vipBundleID, err := uuid.Parse(c.String("id"))
+	if err != nil {
+		return fmt.Errorf("invalid vip bundle id: %w", err)
+	}
+
+	pm, closeDB, err := newVipBundleProcessManager()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return action(pm, vipBundleID)
+}
+
+// newVipBundleProcessManager returns the process manager for operator actions.
+// Messages it sends go through the outbox, and are forwarded by the running service.
+func newVipBundleProcessManager() (*sagas.VipBundleProcessManager, func() error, error) {
+	partitions, err := intFromEnv("OUTBOX_PARTITIONS", 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	stepTimeout, err := durationFromEnv("VIP_BUNDLE_STEP_TIMEOUT", 10*time.Minute)
+	if err != nil {
+		return nil, nil, err
+	}
+	stepMaxRetries, err := intFromEnv("VIP_BUNDLE_STEP_MAX_RETRIES", 2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	database, err := db.NewDBConn(os.Getenv("POSTGRES_URL"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pm := sagas.NewVipBundleProcessManager(
+		db.NewVipBundleRepository(database.Conn, outbox.Partitions(partitions), event.NewBus),
+		db.NewInbox(&database),
+		db.NewUnitOfWork(&database, outbox.Partitions(partitions), event.NewBus, command.NewCommandBus),
+		scheduler.NewScheduler(db.NewScheduledCommands(&database, outbox.Partitions(partitions)), command.NewCommandBus),
+		sagas.StepTimeoutsConfig{Timeout: stepTimeout, MaxRetries: stepMaxRetries},
+		hotelBookingEnabled(),
+	)
+
+	return pm, database.Close, nil
+}
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CheckBackwardCompatibility returns an error if payloads valid against the registered schema
+// may not be readable by consumers using the new schema.
+//
+// Removing fields and adding optional ones is allowed, while adding required fields,
+// narrowing types or changing formats is not.
+func CheckBackwardCompatibility(registered, new *Schema) error {
+	return errors.Join(checkCompatibility("", registered, new)...)
+}
+
+func checkCompatibility(path string, old, new *Schema) []error {
+	if old == nil || new == nil {
+		return nil
+	}
+
+	var errs []error
+	field := path
+	if field == "" {
+		field = "(root)"
+	}
+
+	for _, typ := range old.Type {
+		if new.Type.Has(typ) {
+			continue
+		}
+		if typ == "integer" && new.Type.Has("number") {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: type %s is no longer allowed", field, typ))
+	}
+
+	if new.Format != "" && new.Format != old.Format {
+		errs = append(errs, fmt.Errorf("%s: format changed from %q to %q", field, old.Format, new.Format))
+	}
+
+	oldRequired := map[string]bool{}
+	for _, name := range old.Required {
+		oldRequired[name] = true
+	}
+	for _, name := range new.Required {
+		if !oldRequired[name] {
+			errs = append(errs, fmt.Errorf("%s: required field %s was added", field, join(path, name)))
+		}
+	}
+
+	names := make([]string, 0, len(new.Properties))
+	for name := range new.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		errs = append(errs, checkCompatibility(join(path, name), old.Properties[name], new.Properties[name])...)
+	}
+
+	errs = append(errs, checkCompatibility(path+"[]", old.Items, new.Items)...)
+	errs = append(errs, checkCompatibility(path+"{}", old.AdditionalProperties, new.AdditionalProperties)...)
+
+	return errs
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
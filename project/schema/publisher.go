@@ -0,0 +1,46 @@
+package schema
+
+import (
+	"fmt"
+	"tickets/message/protobuf"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ValidatingPublisherDecorator rejects messages which don't match their registered schema.
+// Messages without the name metadata (not published by the CQRS buses) are not validated.
+//
+// It decorates publishers writing to the outbox, so the caller gets the error and the message isn't stored.
+// An invalid message in the outbox would block its partition, as the forwarder would retry it forever.
+type ValidatingPublisherDecorator struct {
+	message.Publisher
+	Registry Registry
+}
+
+func (v ValidatingPublisherDecorator) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		name := msg.Metadata.Get("name")
+		if name == "" {
+			continue
+		}
+
+		jsonMsg, err := protobuf.ToJSON(msg)
+		if err != nil {
+			return err
+		}
+
+		if err := v.Registry.Validate(name, jsonMsg.Payload); err != nil {
+			return fmt.Errorf("message %s (%s) published to %s does not match its schema: %w", msg.UUID, name, topic, err)
+		}
+	}
+
+	return v.Publisher.Publish(topic, messages...)
+}
+
+// ValidatingBus decorates the publisher of the buses created by newBus (event.NewBus or command.NewCommandBus)
+// with ValidatingPublisherDecorator.
+func ValidatingBus[B any](newBus func(message.Publisher) B, registry Registry) func(message.Publisher) B {
+	return func(publisher message.Publisher) B {
+		return newBus(ValidatingPublisherDecorator{Publisher: publisher, Registry: registry})
+	}
+}
@@ -0,0 +1,225 @@
+package schema
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"tickets/entities"
+
+	"github.com/ThreeDotsLabs/watermill/components/cqrs"
+)
+
+// Messages are the events and commands which have their schema registered.
+// New events and commands should be added here, and their schema generated with `tickets schemas generate`.
+// They also need a protobuf definition in message/protobuf/pb.
+var Messages = []any{
+	entities.TicketBookingConfirmed_v1{},
+	entities.TicketBookingCanceled_v1{},
+	entities.TicketRefunded_v1{},
+	entities.TicketPrinted_v1{},
+	entities.TicketReceiptIssued_v1{},
+	entities.BookingMade_v1{},
+	entities.BookingFailed_v1{},
+	entities.BookingCanceled_v1{},
+	entities.BookingTransferred_v1{},
+	entities.BookingRefunded_v1{},
+	entities.VipBundleInitialized_v1{},
+	entities.VipBundleFinalized_v1{},
+	entities.VipBundleCanceled_v1{},
+	entities.VipBundleStepTimedOut_v1{},
+	entities.VipBundleOperatorActionTaken_v1{},
+	entities.FlightBooked_v1{},
+	entities.FlightBookingFailed_v1{},
+	entities.HotelBooked_v1{},
+	entities.HotelBookingFailed_v1{},
+	entities.TaxiBooked_v1{},
+	entities.TaxiBookingFailed_v1{},
+	entities.InternalOpsReadModelUpdated{},
+
+	entities.RefundTicket{},
+	entities.BookShowTickets{},
+	entities.CancelBooking{},
+	entities.TransferBooking{},
+	entities.RefundBooking{},
+	entities.BookFlight{},
+	entities.BookHotel{},
+	entities.BookTaxi{},
+	entities.CancelFlightTickets{},
+	entities.CancelHotelBooking{},
+	entities.CancelTaxiBooking{},
+	entities.CancelVipBundle{},
+	entities.TimeOutVipBundleStep{},
+}
+
+// Dir is the checked-in directory with the registered schemas, relative to the module root.
+const Dir = "schema/registry"
+
+//go:embed registry
+var registryFS embed.FS
+
+var versionRegexp = regexp.MustCompile(`^(.+)_v(\d+)$`)
+
+// NameAndVersion splits the message name (as generated by cqrs.StructName) into the name and the version.
+// Messages without a version suffix are version 1.
+func NameAndVersion(messageName string) (string, int) {
+	matches := versionRegexp.FindStringSubmatch(messageName)
+	if matches == nil {
+		return messageName, 1
+	}
+
+	version, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return messageName, 1
+	}
+
+	return matches[1], version
+}
+
+func schemaPath(messageName string) string {
+	name, version := NameAndVersion(messageName)
+	return path.Join(name, fmt.Sprintf("v%d.json", version))
+}
+
+// Registry holds schemas by the message name (for example `BookingMade_v1`).
+type Registry struct {
+	schemas map[string]*Schema
+}
+
+// NewRegistry returns the registry with the schemas checked in to the repository.
+func NewRegistry() (Registry, error) {
+	sub, err := fs.Sub(registryFS, "registry")
+	if err != nil {
+		return Registry{}, err
+	}
+
+	return LoadRegistry(sub)
+}
+
+// LoadRegistry loads all schemas stored as <name>/v<version>.json.
+func LoadRegistry(fsys fs.FS) (Registry, error) {
+	r := Registry{schemas: map[string]*Schema{}}
+
+	files, err := fs.Glob(fsys, "*/v*.json")
+	if err != nil {
+		return Registry{}, err
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return Registry{}, fmt.Errorf("could not read schema %s: %w", file, err)
+		}
+
+		var s Schema
+		if err := json.Unmarshal(data, &s); err != nil {
+			return Registry{}, fmt.Errorf("could not unmarshal schema %s: %w", file, err)
+		}
+
+		if s.Title == "" {
+			return Registry{}, fmt.Errorf("schema %s has no title", file)
+		}
+		if schemaPath(s.Title) != file {
+			return Registry{}, fmt.Errorf("schema %s is stored under a wrong path, expected %s", s.Title, schemaPath(s.Title))
+		}
+
+		r.schemas[s.Title] = &s
+	}
+
+	return r, nil
+}
+
+func (r Registry) Get(messageName string) (*Schema, bool) {
+	s, ok := r.schemas[messageName]
+	return s, ok
+}
+
+// Validate checks the JSON payload of the message against its registered schema.
+func (r Registry) Validate(messageName string, payload []byte) error {
+	s, ok := r.Get(messageName)
+	if !ok {
+		return fmt.Errorf("no schema registered for %s", messageName)
+	}
+
+	return ValidateJSON(s, payload)
+}
+
+// Check verifies that every message in Messages has a registered schema
+// which is up to date and that the current struct is backward compatible with it.
+func (r Registry) Check() error {
+	var errs []error
+
+	for _, msg := range Messages {
+		name := cqrs.StructName(msg)
+
+		generated, err := Generate(msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: could not generate schema: %w", name, err))
+			continue
+		}
+
+		registered, ok := r.Get(name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: schema is not registered, run `tickets schemas generate`", name))
+			continue
+		}
+
+		if err := CheckBackwardCompatibility(registered, generated); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w, add a new version of the message instead", name, err))
+			continue
+		}
+
+		if !equal(registered, generated) {
+			errs = append(errs, fmt.Errorf("%s: schema is outdated, run `tickets schemas generate`", name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Generate writes schemas of all Messages to dir.
+// It refuses to overwrite a registered schema with a backward incompatible one.
+func (r Registry) Generate(dir string) error {
+	for _, msg := range Messages {
+		name := cqrs.StructName(msg)
+
+		generated, err := Generate(msg)
+		if err != nil {
+			return fmt.Errorf("could not generate schema of %s: %w", name, err)
+		}
+
+		if registered, ok := r.Get(name); ok {
+			if err := CheckBackwardCompatibility(registered, generated); err != nil {
+				return fmt.Errorf("%s: %w, add a new version of the message instead", name, err)
+			}
+		}
+
+		data, err := json.MarshalIndent(generated, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		file := filepath.Join(dir, filepath.FromSlash(schemaPath(name)))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("could not write schema %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+func equal(a, b *Schema) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+
+	return string(aJSON) == string(bJSON)
+}
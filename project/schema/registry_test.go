@@ -0,0 +1,141 @@
+package schema_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"tickets/entities"
+	"tickets/message/event"
+	"tickets/schema"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisteredSchemasAreCompatible(t *testing.T) {
+	registry, err := schema.NewRegistry()
+	require.NoError(t, err)
+
+	assert.NoError(t, registry.Check())
+}
+
+func TestCheckBackwardCompatibility(t *testing.T) {
+	type Ticket_v1 struct {
+		TicketID string `json:"ticket_id"`
+		Price    int    `json:"price"`
+	}
+	type TicketRemovedField struct {
+		TicketID string `json:"ticket_id"`
+	}
+	type TicketOptionalField struct {
+		TicketID string `json:"ticket_id"`
+		Price    int    `json:"price"`
+		Note     string `json:"note,omitempty"`
+	}
+	type TicketRequiredField struct {
+		TicketID string `json:"ticket_id"`
+		Price    int    `json:"price"`
+		Note     string `json:"note"`
+	}
+	type TicketChangedType struct {
+		TicketID uuid.UUID `json:"ticket_id"`
+		Price    int       `json:"price"`
+	}
+
+	registered, err := schema.Generate(Ticket_v1{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name       string
+		New        any
+		Compatible bool
+	}{
+		{Name: "removed_field", New: TicketRemovedField{}, Compatible: true},
+		{Name: "optional_field", New: TicketOptionalField{}, Compatible: true},
+		{Name: "required_field", New: TicketRequiredField{}, Compatible: false},
+		{Name: "changed_type", New: TicketChangedType{}, Compatible: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			newSchema, err := schema.Generate(tc.New)
+			require.NoError(t, err)
+
+			err = schema.CheckBackwardCompatibility(registered, newSchema)
+			if tc.Compatible {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	registry, err := schema.NewRegistry()
+	require.NoError(t, err)
+
+	valid, err := json.Marshal(entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		NumberOfTickets: 1,
+		BookingID:       uuid.New(),
+		CustomerEmail:   "email@example.com",
+		ShowId:          uuid.New(),
+	})
+	require.NoError(t, err)
+	assert.NoError(t, registry.Validate("BookingMade_v1", valid))
+
+	assert.Error(t, registry.Validate("BookingMade_v1", []byte(`{"booking_id": "not-uuid"}`)))
+	assert.Error(t, registry.Validate("Unknown_v1", valid))
+}
+
+func TestValidatingBus(t *testing.T) {
+	registry, err := schema.NewRegistry()
+	require.NoError(t, err)
+
+	outbox := &recordingPublisher{}
+	eventBus := schema.ValidatingBus(event.NewBus, registry)(outbox)
+	ctx := context.Background()
+
+	err = eventBus.Publish(ctx, entities.BookingMade_v1{
+		Header:          entities.NewEventHeader(),
+		NumberOfTickets: 1,
+		BookingID:       uuid.New(),
+		CustomerEmail:   "email@example.com",
+		ShowId:          uuid.New(),
+	})
+	require.NoError(t, err)
+
+	err = eventBus.Publish(ctx, BookingMade_v1{
+		Header:    entities.NewEventHeader(),
+		BookingID: "not-uuid",
+	})
+	assert.Error(t, err)
+
+	assert.Len(t, outbox.messages, 1, "invalid messages must not reach the outbox")
+}
+
+// BookingMade_v1 is published under the name of entities.BookingMade_v1, without its required fields.
+type BookingMade_v1 struct {
+	Header    entities.EventHeader `json:"header"`
+	BookingID string               `json:"booking_id"`
+}
+
+func (BookingMade_v1) IsInternal() bool {
+	return false
+}
+
+type recordingPublisher struct {
+	messages []*message.Message
+}
+
+func (p *recordingPublisher) Publish(_ string, messages ...*message.Message) error {
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ValidateJSON validates the JSON payload against the schema.
+func ValidateJSON(s *Schema, payload []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return errors.Join(validate("(root)", s, v)...)
+}
+
+func validate(path string, s *Schema, v any) []error {
+	if s == nil {
+		return nil
+	}
+
+	switch v := v.(type) {
+	case nil:
+		if !s.Type.Has("null") {
+			return []error{fmt.Errorf("%s: must not be null", path)}
+		}
+		return nil
+	case bool:
+		if !s.Type.Has("boolean") {
+			return []error{typeError(path, s, "boolean")}
+		}
+	case json.Number:
+		if s.Type.Has("number") {
+			return nil
+		}
+		if _, err := v.Int64(); err != nil || !s.Type.Has("integer") {
+			return []error{typeError(path, s, "number")}
+		}
+	case string:
+		if !s.Type.Has("string") {
+			return []error{typeError(path, s, "string")}
+		}
+		return validateFormat(path, s.Format, v)
+	case []any:
+		if !s.Type.Has("array") {
+			return []error{typeError(path, s, "array")}
+		}
+		var errs []error
+		for i, item := range v {
+			errs = append(errs, validate(fmt.Sprintf("%s[%d]", path, i), s.Items, item)...)
+		}
+		return errs
+	case map[string]any:
+		if !s.Type.Has("object") {
+			return []error{typeError(path, s, "object")}
+		}
+		return validateObject(path, s, v)
+	}
+
+	return nil
+}
+
+func validateObject(path string, s *Schema, v map[string]any) []error {
+	var errs []error
+
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			errs = append(errs, fmt.Errorf("%s: missing required field %s", path, name))
+		}
+	}
+
+	for name, value := range v {
+		propertySchema, ok := s.Properties[name]
+		if !ok {
+			propertySchema = s.AdditionalProperties
+		}
+		errs = append(errs, validate(path+"."+name, propertySchema, value)...)
+	}
+
+	return errs
+}
+
+func validateFormat(path string, format string, v string) []error {
+	var err error
+
+	switch format {
+	case "uuid":
+		_, err = uuid.Parse(v)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, v)
+	}
+
+	if err != nil {
+		return []error{fmt.Errorf("%s: invalid %s: %w", path, format, err)}
+	}
+
+	return nil
+}
+
+func typeError(path string, s *Schema, got string) error {
+	return fmt.Errorf("%s: expected %v, got %s", path, []string(s.Type), got)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"tickets/schema"
+
+	"github.com/urfave/cli/v2"
+)
+
+var schemasCommand = &cli.Command{
+	Name:  "schemas",
+	Usage: "Manage the event and command schema registry",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "generate",
+			Usage: "generate schemas of events and commands, failing on backward incompatible changes",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "dir",
+					Value: schema.Dir,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				registry, err := schema.NewRegistry()
+				if err != nil {
+					return err
+				}
+
+				return registry.Generate(c.String("dir"))
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "check that registered schemas are up to date and backward compatible",
+			Action: func(c *cli.Context) error {
+				registry, err := schema.NewRegistry()
+				if err != nil {
+					return err
+				}
+
+				if err := registry.Check(); err != nil {
+					return err
+				}
+
+				fmt.Println("schemas are up to date")
+
+				return nil
+			},
+		},
+	},
+}
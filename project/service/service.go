@@ -2,19 +2,24 @@ package service
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"tickets/db"
 	ticketsHttp "tickets/http"
 	"tickets/message"
 	"tickets/message/command"
 	"tickets/message/event"
 	"tickets/message/outbox"
+	"tickets/message/protobuf"
 	"tickets/message/sagas"
+	"tickets/message/scheduler"
+	"tickets/schema"
 	observability "tickets/trace"
 
 	"github.com/ThreeDotsLabs/go-event-driven/common/log"
+	"github.com/ThreeDotsLabs/watermill/components/forwarder"
 	watermillMessage "github.com/ThreeDotsLabs/watermill/message"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/sync/errgroup"
@@ -30,15 +35,33 @@ type ReceiptService interface {
 }
 
 type Service struct {
-	watermillRouter *watermillMessage.Router
-	echoRouter      *echo.Echo
-	dataLakeRepo    db.IEventRepository
-	readModel       db.OpsBookingReadModel
-	traceProvider   *tracesdk.TracerProvider
+	watermillRouter    *watermillMessage.Router
+	echoRouter         *echo.Echo
+	dataLakeRepo       db.IEventRepository
+	readModel          db.OpsBookingReadModel
+	traceProvider      *tracesdk.TracerProvider
+	outboxRetentionJob outbox.RetentionJob
+	outboxForwarders   []*forwarder.Forwarder
+	schedulerWorker    scheduler.Worker
+	rebuildWorker      *db.RebuildWorker
+}
+
+// Config are the settings of the service, the services it calls are passed to New.
+type Config struct {
+	Transport message.Transport
+	Outbox    outbox.Config
+	// ValidateSchemas validates payloads against the registered schemas before they are stored in the outbox.
+	ValidateSchemas bool
+	// ProtobufTopics are the topics to which messages are published as protobuf.
+	ProtobufTopics []string
+	// ColdStorage keeps archived events of the data lake, it's optional.
+	ColdStorage           db.ColdStorage
+	VipBundleStepTimeouts sagas.StepTimeoutsConfig
+	Scheduler             scheduler.Config
 }
 
 func New(
-	redisClient *redis.Client,
+	config Config,
 	spreadsheetsService event.SpreadsheetsAPI,
 	receiptsService ReceiptService,
 	fileService event.FileService,
@@ -46,66 +69,103 @@ func New(
 	deadNotionService event.DeadNationService,
 	transportaionService command.TransportationService,
 	paymentsService command.PaymentsService,
+	// hotelService is nil where there's no hotel partner, the hotel step of VIP bundles is skipped then
+	hotelService command.HotelService,
 ) Service {
 	traceConfig := observability.ConfigureTraceProvider()
 	watermillLogger := log.NewWatermill(log.FromContext(context.Background()))
 
-	var redisPublisher watermillMessage.Publisher
-	redisPublisher = message.NewRedisPublisher(redisClient, watermillLogger)
-	redisPublisher = log.CorrelationPublisherDecorator{Publisher: redisPublisher}
-	redisPublisher = observability.TracingPublisherDecorator{Publisher: redisPublisher}
-	eventBus := event.NewBus(redisPublisher)
-	commandBus := command.NewCommandBus(redisPublisher)
+	publisher, err := config.Transport.NewPublisher()
+	if err != nil {
+		panic(err)
+	}
+	publisher = protobuf.NewPublisherDecorator(publisher, config.ProtobufTopics)
+	publisher = log.CorrelationPublisherDecorator{Publisher: publisher}
+	publisher = observability.TracingPublisherDecorator{Publisher: publisher}
+	eventBus := event.NewBus(publisher)
+
+	// messages are validated before they are stored in the outbox, where an invalid one would block its partition
+	newEventBus := event.NewBus
+	newCommandBus := command.NewCommandBus
+	if config.ValidateSchemas {
+		registry, err := schema.NewRegistry()
+		if err != nil {
+			panic(err)
+		}
+		newEventBus = schema.ValidatingBus(event.NewBus, registry)
+		newCommandBus = schema.ValidatingBus(command.NewCommandBus, registry)
+	}
 
 	ticketRepo := db.NewTicketRepo(&conn)
 	showRepo := db.NewShowRepository(&conn)
-	bookingRepo := db.NewBookingRespository(&conn)
-	showRepository := db.NewShowRepository(&conn)
-	bundleRepo := db.NewVipBundleRepository(conn.Conn)
+	bookingRepo := db.NewBookingRespository(&conn, config.Outbox.Partitions, newEventBus)
+	bundleRepo := db.NewVipBundleRepository(conn.Conn, config.Outbox.Partitions, newEventBus)
+	inbox := db.NewInbox(&conn)
+	unitOfWork := db.NewUnitOfWork(&conn, config.Outbox.Partitions, newEventBus, newCommandBus)
 
 	eventsHandler := event.NewHandler(
 		spreadsheetsService,
 		receiptsService,
 		ticketRepo,
 		fileService,
-		eventBus,
+		unitOfWork,
 		deadNotionService,
-		showRepository,
+		showRepo,
+		inbox,
 	)
-	commandsHandler := command.NewHandler(eventBus, receiptsService, bookingRepo, transportaionService, commandBus, paymentsService)
+	commandsHandler := command.NewHandler(unitOfWork, receiptsService, bookingRepo, transportaionService, paymentsService, hotelService)
 
-	vipBundleProcessManager := sagas.NewVipBundleProcessManager(commandBus, eventBus, bundleRepo)
+	scheduledCommands := db.NewScheduledCommands(&conn, config.Outbox.Partitions)
+	commandScheduler := scheduler.NewScheduler(scheduledCommands, newCommandBus)
+	vipBundleProcessManager := sagas.NewVipBundleProcessManager(bundleRepo, inbox, unitOfWork, commandScheduler, config.VipBundleStepTimeouts, hotelService != nil)
 
-	redisSubscriber := message.NewRedisSubscriber(redisClient, watermillLogger)
-	eventProcessorConfig := event.NewProcessorConfig(redisClient, watermillLogger)
-	commandProccessorConfig := command.NewCommandProcessorConfig(redisClient, watermillLogger)
-	opsReadModel := db.NewOpsBookingReadModel(&conn, eventBus)
+	subscriber, err := config.Transport.NewSubscriber("")
+	if err != nil {
+		panic(err)
+	}
+	eventProcessorConfig := event.NewProcessorConfig(config.Transport.NewSubscriber, watermillLogger)
+	commandProccessorConfig := command.NewCommandProcessorConfig(config.Transport.NewSubscriber, watermillLogger)
+	opsReadModel := db.NewOpsBookingReadModel(&conn, config.Outbox.Partitions, newEventBus)
+	vipBundleReadModel := db.NewVipBundleReadModel(&conn, config.Outbox.Partitions, newEventBus)
 	dataLakeRepo := db.NewEventRepository(&conn, eventBus)
+	if config.ColdStorage != nil {
+		dataLakeRepo = dataLakeRepo.WithColdStorage(config.ColdStorage)
+	}
+
+	pgSubscriber := outbox.SubscribeForPGMessages(conn.Conn, watermillLogger, config.Outbox.Partitions)
+	outboxForwarders, err := outbox.NewForwarders(pgSubscriber, publisher, watermillLogger, config.Outbox.Partitions)
+	if err != nil {
+		panic(err)
+	}
+	outboxInspector := outbox.NewInspector(conn.Conn, config.Outbox.Partitions)
+	rebuildWorker := db.NewRebuildWorker(db.NewProjectionRebuilder(dataLakeRepo, opsReadModel.Projection(), vipBundleReadModel.Projection()))
 
-	pgSubscriber := outbox.SubscribeForPGMessages(conn.Conn, watermillLogger)
 	watermillRouter := message.NewWatermillRouter(
-		pgSubscriber,
-		redisSubscriber,
+		subscriber,
 		commandProccessorConfig,
-		redisPublisher,
+		publisher,
 		eventProcessorConfig,
 		commandsHandler,
 		eventsHandler,
-		opsReadModel,
+		[]message.Projection{opsReadModel, vipBundleReadModel},
 		dataLakeRepo,
 		watermillLogger,
 		vipBundleProcessManager,
 	)
 
 	echoRouter := ticketsHttp.NewHttpRouter(
-		eventBus,
-		commandBus,
+		unitOfWork,
 		spreadsheetsService,
 		ticketRepo,
 		showRepo,
 		bookingRepo,
 		opsReadModel,
 		bundleRepo,
+		outboxInspector,
+		rebuildWorker,
+		dataLakeRepo,
+		vipBundleReadModel,
+		vipBundleProcessManager,
 	)
 
 	return Service{
@@ -114,6 +174,10 @@ func New(
 		dataLakeRepo,
 		opsReadModel,
 		traceConfig,
+		outbox.NewRetentionJob(outboxInspector, config.Outbox.Retention),
+		outboxForwarders,
+		scheduler.NewWorker(scheduledCommands, config.Scheduler),
+		rebuildWorker,
 	}
 }
 
@@ -133,7 +197,7 @@ func (s Service) Run(
 
 		err := s.echoRouter.Start(":8080")
 
-		if err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return err
 		}
 
@@ -145,6 +209,30 @@ func (s Service) Run(
 		return s.echoRouter.Shutdown(context.Background())
 	})
 
+	for _, fwd := range s.outboxForwarders {
+		errgrp.Go(func() error {
+			return fwd.Run(ctx)
+		})
+	}
+
+	errgrp.Go(func() error {
+		<-s.watermillRouter.Running()
+
+		return s.outboxRetentionJob.Run(ctx)
+	})
+
+	errgrp.Go(func() error {
+		<-s.watermillRouter.Running()
+
+		return s.schedulerWorker.Run(ctx)
+	})
+
+	errgrp.Go(func() error {
+		<-s.watermillRouter.Running()
+
+		return s.rebuildWorker.Run(ctx)
+	})
+
 	errgrp.Go(func() error {
 		return s.traceProvider.Shutdown(context.Background())
 	})
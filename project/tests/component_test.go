@@ -11,9 +11,13 @@ import (
 	"tickets/db"
 	"tickets/entities"
 	"tickets/message"
+	"tickets/message/outbox"
+	"tickets/message/sagas"
+	"tickets/message/scheduler"
 	"tickets/service"
 	"time"
 
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/google/uuid"
 	"github.com/lithammer/shortuuid/v3"
 	"github.com/stretchr/testify/assert"
@@ -24,8 +28,35 @@ func TestComponent(t *testing.T) {
 	redisClient := message.NewRedisClient(os.Getenv("REDIS_ADDR"))
 	defer redisClient.Close()
 
+	transports := []message.TransportConfig{
+		{Kind: message.TransportRedis, RedisClient: redisClient},
+		{Kind: message.TransportGoChannel},
+	}
+	if kafkaAddr := os.Getenv("KAFKA_ADDR"); kafkaAddr != "" {
+		transports = append(transports, message.TransportConfig{
+			Kind:         message.TransportKafka,
+			KafkaBrokers: []string{kafkaAddr},
+		})
+	}
+
+	for _, transportConfig := range transports {
+		t.Run(string(transportConfig.Kind), func(t *testing.T) {
+			transport, err := message.NewTransport(transportConfig, watermill.NopLogger{})
+			require.NoError(t, err)
+
+			testComponent(t, transport)
+		})
+	}
+}
+
+func testComponent(t *testing.T, transport message.Transport) {
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	stopped := make(chan struct{})
+	defer func() {
+		cancel()
+		// the next transport reuses the HTTP port
+		<-stopped
+	}()
 
 	spreadsheetsService := &api.SpreadsheetsMock{}
 	receiptsService := &api.ReceiptsMock{}
@@ -38,8 +69,19 @@ func TestComponent(t *testing.T) {
 		panic(err)
 	}
 	go func() {
+		defer close(stopped)
+
 		svc := service.New(
-			redisClient,
+			service.Config{
+				Transport: transport,
+				Outbox: outbox.Config{
+					Partitions: 1,
+					Retention:  outbox.RetentionConfig{Retention: time.Hour},
+				},
+				ValidateSchemas:       true,
+				VipBundleStepTimeouts: sagas.StepTimeoutsConfig{Timeout: time.Minute, MaxRetries: 2},
+				Scheduler:             scheduler.Config{PollInterval: time.Second},
+			},
 			spreadsheetsService,
 			receiptsService,
 			fileService,
@@ -47,6 +89,7 @@ func TestComponent(t *testing.T) {
 			deadNationservice,
 			transportationService,
 			paymentsService,
+			api.NewLocalHotelService(),
 		)
 
 		assert.NoError(t, svc.Run(ctx))